@@ -0,0 +1,135 @@
+package gno
+
+import (
+	"fmt"
+	"math"
+)
+
+//----------------------------------------
+// Allocation
+//
+// A machine with an Allocator accounts for the bytes it
+// allocates for arrays, slices, maps, structs, strings, and
+// blocks, by the same sizes as are charged for gas.  Sizes are
+// accounted for before allocating, and once the allocator's
+// limit would be exceeded, the machine panics with an
+// AllocationError, which unwinds like any other panic.
+//
+// Bytes are never freed, so the limit bounds the total
+// allocated during the lifetime of the allocator.
+// XXX account for garbage, e.g. with a refcount pass.
+
+type Allocator struct {
+	MaxBytes int64
+	Bytes    int64
+}
+
+func NewAllocator(maxBytes int64) *Allocator {
+	return &Allocator{
+		MaxBytes: maxBytes,
+	}
+}
+
+// Panics with an AllocationError if the limit would be
+// exceeded, in which case nothing is accounted for.
+func (alloc *Allocator) Allocate(size int64) {
+	if size < 0 {
+		panic("should not happen")
+	}
+	if size > alloc.MaxBytes-alloc.Bytes {
+		panic(AllocationError{
+			MaxBytes: alloc.MaxBytes,
+			Bytes:    alloc.Bytes,
+			Size:     size,
+		})
+	}
+	alloc.Bytes += size
+}
+
+type AllocationError struct {
+	MaxBytes int64
+	Bytes    int64
+	Size     int64
+}
+
+func (ae AllocationError) Error() string {
+	return fmt.Sprintf("allocation limit exceeded: %d + %d exceeds %d bytes",
+		ae.Bytes, ae.Size, ae.MaxBytes)
+}
+
+//----------------------------------------
+// Machine allocations
+//
+// Each charges gas and accounts for the allocation, if the
+// machine has a gas meter or an allocator respectively.
+
+func (m *Machine) allocate(size int64) {
+	m.incrAllocGas(size)
+	if m.Alloc != nil {
+		m.Alloc.Allocate(size)
+	}
+}
+
+// For a block of n values.
+func (m *Machine) allocateBlock(n int) {
+	m.allocate(listSize(nil, n))
+}
+
+// For the zero value of type t, as by defaultValue(), including
+// the nested arrays and structs that are initialized lazily.
+func (m *Machine) allocateDefault(t Type) {
+	m.allocate(defaultSize(t))
+}
+
+// Returns the size of the zero value of type t, including that
+// of nested arrays and structs.  Byte arrays are backed by
+// data.  Sizes that overflow are clamped to math.MaxInt64,
+// which exceeds any limit.
+func defaultSize(t Type) int64 {
+	switch ct := baseOf(t).(type) {
+	case *ArrayType:
+		if ct.Elt.Kind() == Uint8Kind {
+			return listSize(ct.Elt, ct.Len)
+		}
+		esize := defaultSize(ct.Elt)
+		if ct.Len > 0 && esize > math.MaxInt64/int64(ct.Len)-sizeTypedValue {
+			return math.MaxInt64
+		}
+		return int64(ct.Len) * (sizeTypedValue + esize)
+	case *StructType:
+		size := listSize(nil, len(ct.Fields))
+		for _, f := range ct.Fields {
+			fsize := defaultSize(f.Type)
+			if fsize > math.MaxInt64-size {
+				return math.MaxInt64
+			}
+			size += fsize
+		}
+		return size
+	default:
+		return 0
+	}
+}
+
+// For appending n elements to a slice of type st with length
+// l and capacity c, which allocates a new list if beyond
+// capacity.
+func (m *Machine) allocateAppend(st Type, l, n, c int) {
+	et := st.Elem()
+	if l+n <= c {
+		m.incrCopyGas(listSize(et, n))
+		return
+	}
+	m.allocate(listSize(et, l+n))
+	m.incrCopyGas(listSize(et, l+n))
+}
+
+// For the concatenation of strings.
+func (m *Machine) allocateConcat(lv, rv *TypedValue) {
+	if lv.T.Kind() != StringKind {
+		return
+	}
+	size := int64(len(lv.GetString())) + int64(len(rv.GetString()))
+	m.allocate(size)
+	m.incrCopyGas(size)
+}
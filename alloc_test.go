@@ -0,0 +1,165 @@
+package gno
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+func TestAllocationLimit(t *testing.T) {
+	cases := []struct {
+		name string
+		code string
+	}{
+		{"make", `package main
+func main() {
+	n := 1099511627776
+	x := make([]byte, n)
+	println(len(x))
+}`},
+		{"make huge", `package main
+func main() {
+	n := 4611686018427387904
+	x := make([]int, n)
+	println(len(x))
+}`},
+		{"append", `package main
+func main() {
+	x := []int{1}
+	for i := 0; i < 100; i++ {
+		x = append(x, x...)
+	}
+}`},
+		{"append nil", `package main
+func main() {
+	for i := 0; i < 1000; i++ {
+		var x []int
+		x = append(x, i)
+	}
+}`},
+		{"map", `package main
+func main() {
+	x := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		x[i] = i
+	}
+}`},
+		{"string", `package main
+func main() {
+	s := "0123456789"
+	for i := 0; i < 100; i++ {
+		s += s
+	}
+}`},
+		{"array", `package main
+func main() {
+	var x [100000]int
+	println(len(x))
+}`},
+		{"nested array", `package main
+func main() {
+	var x [64][65536]int
+	println(len(x))
+}`},
+		{"struct", `package main
+type S struct {
+	A, B, C int
+}
+func main() {
+	for i := 0; i < 1000; i++ {
+		_ = S{A: i}
+	}
+}`},
+		{"call", `package main
+func f(a, b, c int) int {
+	return a + b + c
+}
+func main() {
+	for i := 0; i < 1000; i++ {
+		f(i, i, i)
+	}
+}`},
+	}
+	for _, c := range cases {
+		alloc := NewAllocator(10000)
+		_, r := runMain(MachineOptions{Alloc: alloc}, c.code)
+		ae, ok := r.(AllocationError)
+		assert.True(t, ok, c.name)
+		assert.Equal(t, ae.MaxBytes, int64(10000), c.name)
+		assert.True(t, alloc.Bytes <= alloc.MaxBytes, c.name)
+		assert.Contains(t, ae.Error(), "allocation limit exceeded", c.name)
+		// the error is deterministic.
+		_, r2 := runMain(MachineOptions{Alloc: NewAllocator(10000)}, c.code)
+		assert.Equal(t, r2, r, c.name)
+	}
+}
+
+func TestAllocationWithinLimit(t *testing.T) {
+	alloc := NewAllocator(10000)
+	_, r := runMain(MachineOptions{Alloc: alloc}, `package main
+func main() {
+	x := []int{1, 2, 3}
+	x = append(x, x...)
+	m := map[string]int{"a": 1}
+	m["b"] = 2
+	s := "a" + "b"
+	println(len(x), m["b"], s)
+}`)
+	assert.Nil(t, r)
+	assert.True(t, alloc.Bytes > 0)
+}
+
+func TestAllocationByteArray(t *testing.T) {
+	// byte arrays are charged a byte per element.
+	alloc := NewAllocator(10000)
+	_, r := runMain(MachineOptions{Alloc: alloc}, `package main
+func main() {
+	var b [4096]byte
+	b[1] = 1
+	println(b[1])
+}`)
+	assert.Nil(t, r)
+	assert.True(t, alloc.Bytes >= 4096)
+}
+
+func TestAllocationNative(t *testing.T) {
+	pkg := NewPackageNode("bz", "test.bz", nil)
+	pkg.DefineGoNativeFunc("Bytes", func() []byte {
+		return []byte{}
+	})
+	pv := pkg.NewPackage(nil)
+	importer := func(pkgPath string) *PackageValue {
+		if pkgPath == "test.bz" {
+			return pv
+		}
+		return nil
+	}
+	// each append allocates more than the other allocations
+	// of the loop combined.
+	s := strings.Repeat("0123456789", 200)
+	cases := []struct {
+		name string
+		arg  string
+	}{
+		{"string", `"` + s + `"...`},
+		{"slice", `[]byte("` + s + `")...`},
+	}
+	for _, c := range cases {
+		alloc := NewAllocator(10000)
+		_, r := runMain(MachineOptions{
+			Importer: importer,
+			Alloc:    alloc,
+		}, `package main
+import "test.bz"
+func main() {
+	b := bz.Bytes()
+	for i := 0; i < 10; i++ {
+		println(append(b, `+c.arg+`))
+	}
+}`)
+		ae, ok := r.(AllocationError)
+		assert.True(t, ok, c.name)
+		assert.Equal(t, ae.Size, int64(len(s)), c.name)
+	}
+}
@@ -0,0 +1,65 @@
+package gno
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+func TestPanicUnrecovered(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, r := runMain(MachineOptions{Output: buf}, `package main
+type E struct {
+	Code int
+}
+func main() {
+	defer println("deferred")
+	panic(E{Code: 7})
+}`)
+	assert.Equal(t, buf.String(), "deferred\n")
+	ex, ok := r.(Exception)
+	assert.True(t, ok)
+	assert.Equal(t, ex.Value.V.(*StructValue).Fields[0].GetInt(), 7)
+}
+
+func TestPanicRealmRollback(t *testing.T) {
+	store := NewMemStore()
+	pv, err := AddPackage(store, "gno.land/r/test",
+		MustParseFile("test.go", `package test
+var count int
+func init() {
+	count = 1
+}
+func Fail() {
+	count = 2
+	panic("fail")
+}
+func Count() int {
+	return count
+}`))
+	assert.Nil(t, err)
+	rlm := pv.GetRealm()
+	hash := rlm.GetHash()
+	buf := new(bytes.Buffer)
+	m := NewMachineWithOptions(MachineOptions{
+		Output:   buf,
+		Importer: store.GetPackage,
+	})
+	m.RunFiles(MustParseFile("main.go", `package main
+import "gno.land/r/test"
+func try() {
+	defer func() {
+		println(recover())
+	}()
+	test.Fail()
+}
+func main() {
+	try()
+	println(test.Count())
+}`))
+	m.RunMain()
+	// the realm's updates were discarded.
+	assert.Equal(t, buf.String(), "fail\n1\n")
+	assert.Equal(t, rlm.GetHash(), hash)
+}
@@ -0,0 +1,109 @@
+package gno
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+//----------------------------------------
+// Package deployment
+//
+// Packages are added to a store at runtime with AddPackage().
+// The paths of pure packages, which are libraries without
+// persisted state, start with "gno.land/p/", and those of
+// realms with "gno.land/r/".  Added packages are importable by
+// subsequent machines whose Importer is the store's
+// GetPackage().
+
+var rePathElem = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func IsPurePath(pkgPath string) bool {
+	return strings.HasPrefix(pkgPath, "gno.land/p/")
+}
+
+// Returns an error unless pkgPath is a valid path for a pure
+// package or realm.
+func ValidatePackagePath(pkgPath string) error {
+	var elems []string
+	if IsPurePath(pkgPath) || IsRealmPath(pkgPath) {
+		elems = strings.Split(pkgPath, "/")[2:]
+	} else {
+		return fmt.Errorf(
+			"invalid package path %q: expected gno.land/p/... or gno.land/r/...",
+			pkgPath)
+	}
+	for _, elem := range elems {
+		if !rePathElem.MatchString(elem) {
+			return fmt.Errorf(
+				"invalid package path %q: invalid element %q",
+				pkgPath, elem)
+		}
+	}
+	return nil
+}
+
+// Adds a new package of pkgPath to store, from its files.  The
+// files are preprocessed, and their declarations and the init
+// function, if any, are run as a single realm transaction.
+// The package is only persisted if all succeed, and so are the
+// transactions of realms called by init.  Imports are
+// resolved by store, and pure packages may not import realms.
+func AddPackage(store Store, pkgPath string, fns ...*FileNode) (pv *PackageValue, err error) {
+	if err := ValidatePackagePath(pkgPath); err != nil {
+		return nil, err
+	}
+	if len(fns) == 0 {
+		return nil, fmt.Errorf("package %s has no files", pkgPath)
+	}
+	if store.GetPackage(pkgPath) != nil {
+		return nil, fmt.Errorf("package %s already exists", pkgPath)
+	}
+	if IsPurePath(pkgPath) {
+		for _, fn := range fns {
+			for _, d := range fn.Body {
+				if id, ok := d.(*ImportDecl); ok && IsRealmPath(id.PkgPath) {
+					return nil, fmt.Errorf(
+						"pure package %s cannot import realm %s",
+						pkgPath, id.PkgPath)
+				}
+			}
+		}
+	}
+	// Errors of user code, e.g. a panicking init function,
+	// are returned.  The machine rolls back the realms.
+	defer func() {
+		if r := recover(); r != nil {
+			store.Discard()
+			pv = nil
+			err = fmt.Errorf("cannot add package %s: %v", pkgPath, r)
+		}
+	}()
+	var rlmr Realmer
+	if IsRealmPath(pkgPath) {
+		rlmr = NewStoreRealmer(store)
+	}
+	pn := NewPackageNode(fns[0].PkgName, pkgPath, &FileSet{})
+	pv = pn.NewPackage(rlmr)
+	m := NewMachineWithOptions(MachineOptions{
+		Package:  pv,
+		Output:   ioutil.Discard,
+		Importer: store.GetPackage,
+	})
+	// as a single run, such that realms finalized by the runs
+	// below are only committed along with the package.
+	defer m.commitOrRollback(m.checkpoint())
+	m.RunFiles(fns...)
+	if _, ok := pn.GetLocalIndex("init"); ok {
+		m.RunStatement(S(Call(X("init"))))
+	}
+	if rlm := pv.GetRealm(); rlm != nil {
+		// the package block is persisted even if unchanged.
+		rlm.MarkDirty(&pv.Block)
+		m.finalizeRealm(rlm)
+	}
+	store.SetPackage(pv)
+	store.Commit()
+	return pv, nil
+}
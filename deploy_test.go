@@ -0,0 +1,192 @@
+package gno
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+func TestValidatePackagePath(t *testing.T) {
+	assert.Nil(t, ValidatePackagePath("gno.land/p/util"))
+	assert.Nil(t, ValidatePackagePath("gno.land/r/demo/users_2"))
+	assert.NotNil(t, ValidatePackagePath("fmt"))
+	assert.NotNil(t, ValidatePackagePath("gno.land/x/util"))
+	assert.NotNil(t, ValidatePackagePath("gno.land/r/"))
+	assert.NotNil(t, ValidatePackagePath("gno.land/r/Test"))
+	assert.NotNil(t, ValidatePackagePath("gno.land/r/test/../util"))
+}
+
+func TestAddPackage(t *testing.T) {
+	db := NewMemDB()
+	store := NewKVStore(db)
+	_, err := AddPackage(store, "gno.land/p/util",
+		MustParseFile("util.go", `package util
+func Double(x int) int {
+	return 2 * x
+}`))
+	assert.Nil(t, err)
+	pv, err := AddPackage(store, "gno.land/r/test",
+		MustParseFile("test.go", `package test
+import "gno.land/p/util"
+var count int
+func init() {
+	count = util.Double(21)
+}
+func Count() int {
+	return count
+}`))
+	assert.Nil(t, err)
+	assert.Equal(t, store.GetPackage("gno.land/r/test"), pv)
+	idx := pv.Source.GetPathForName("count").Index
+	assert.Equal(t, pv.Values[idx].GetInt(), 42)
+
+	// paths are validated, and packages are only added once.
+	_, err = AddPackage(store, "gno.land/x/test",
+		MustParseFile("test.go", `package test`))
+	assert.NotNil(t, err)
+	_, err = AddPackage(store, "gno.land/r/test",
+		MustParseFile("test.go", `package test`))
+	assert.Contains(t, err.Error(), "already exists")
+	_, err = AddPackage(store, "gno.land/p/bad",
+		MustParseFile("bad.go", `package bad
+import "gno.land/r/test"`))
+	assert.Contains(t, err.Error(), "cannot import realm")
+
+	// failing packages are not persisted.
+	_, err = AddPackage(store, "gno.land/r/fail",
+		MustParseFile("fail.go", `package fail
+var count int
+func init() {
+	count = 1
+	panic("fail")
+}`))
+	assert.Contains(t, err.Error(), "fail")
+	assert.Nil(t, store.GetPackage("gno.land/r/fail"))
+	assert.Nil(t, db.Get(packageKey("gno.land/r/fail")))
+
+	// added packages are importable by subsequent machines,
+	// even upon restart.
+	store2 := NewKVStore(db)
+	buf := new(bytes.Buffer)
+	_, r := runMain(MachineOptions{
+		Output:   buf,
+		Importer: store2.GetPackage,
+	}, `package main
+import "gno.land/r/test"
+func main() {
+	println(test.Count())
+}`)
+	assert.Nil(t, r)
+	assert.Equal(t, buf.String(), "42\n")
+}
+
+// Fails to persist packages.
+type failingStore struct {
+	Store
+}
+
+func (fs failingStore) SetPackage(pv *PackageValue) {
+	panic("cannot persist package")
+}
+
+func TestAddPackageCrossCall(t *testing.T) {
+	db := NewMemDB()
+	store := NewKVStore(db)
+	bobv, err := AddPackage(store, "gno.land/r/bob",
+		MustParseFile("bob.go", `package bob
+var Count int
+func Inc() {
+	Count++
+}`))
+	assert.Nil(t, err)
+	hash := bobv.GetRealm().GetHash()
+	nkvs := len(db.kvs)
+
+	// bob's transaction is only persisted along with alice,
+	// which cannot be persisted.
+	_, err = AddPackage(failingStore{store}, "gno.land/r/alice",
+		MustParseFile("alice.go", `package alice
+import "gno.land/r/bob"
+func init() {
+	bob.Inc()
+}`))
+	assert.Contains(t, err.Error(), "cannot persist package")
+	idx := bobv.Source.GetPathForName("Count").Index
+	assert.Equal(t, bobv.Values[idx].GetInt(), 0)
+	assert.Equal(t, bobv.GetRealm().GetHash(), hash)
+	assert.Equal(t, len(db.kvs), nkvs)
+	assert.Nil(t, store.GetPackage("gno.land/r/alice"))
+
+	_, err = AddPackage(store, "gno.land/r/alice",
+		MustParseFile("alice.go", `package alice
+import "gno.land/r/bob"
+func init() {
+	bob.Inc()
+}`))
+	assert.Nil(t, err)
+	bobv2 := NewKVStore(db).GetPackage("gno.land/r/bob")
+	assert.Equal(t, bobv2.Values[idx].GetInt(), 1)
+}
+
+func TestAddPackageStd(t *testing.T) {
+	db := NewMemDB()
+	_, err := AddPackage(NewKVStore(db), "gno.land/r/test",
+		MustParseFile("test.go", `package test
+import "std"
+func Caller() string {
+	return std.CallerPkgPath()
+}`))
+	assert.Nil(t, err)
+
+	// std is resolved upon restart.
+	store2 := NewKVStore(db)
+	buf := new(bytes.Buffer)
+	_, r := runMain(MachineOptions{
+		Output:   buf,
+		Importer: store2.GetPackage,
+	}, `package main
+import "gno.land/r/test"
+func main() {
+	println(test.Caller())
+}`)
+	assert.Nil(t, r)
+	// the caller is main, as args are evaluated before
+	// entering println.
+	assert.Equal(t, buf.String(), ".main\n")
+}
+
+func TestAddPackagePureInit(t *testing.T) {
+	db := NewMemDB()
+	_, err := AddPackage(NewKVStore(db), "gno.land/p/util",
+		MustParseFile("util.go", `package util
+func Double(x int) int {
+	return 2 * x
+}`))
+	assert.Nil(t, err)
+	_, err = AddPackage(NewKVStore(db), "gno.land/p/cfg",
+		MustParseFile("cfg.go", `package cfg
+import "gno.land/p/util"
+var N int
+func init() {
+	N = util.Double(7)
+}
+func Get() int {
+	return N
+}`))
+	assert.Nil(t, err)
+
+	// pure packages are initialized again upon restart.
+	store2 := NewKVStore(db)
+	buf := new(bytes.Buffer)
+	_, r := runMain(MachineOptions{
+		Output:   buf,
+		Importer: store2.GetPackage,
+	}, `package main
+import "gno.land/p/cfg"
+func main() {
+	println(cfg.Get())
+}`)
+	assert.Nil(t, r)
+	assert.Equal(t, buf.String(), "14\n")
+}
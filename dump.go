@@ -0,0 +1,206 @@
+package gno
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//----------------------------------------
+// ObjectDump
+//
+// An ObjectDump is a human-readable dump of an object and its
+// owned descendants, e.g. of a realm's package block, which can
+// be printed as indented text or marshalled as JSON.  Objects
+// that are only borrowed are referred to by their ObjectID.
+
+type ObjectDump struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`
+	Hash     string     `json:"hash"`
+	Owner    string     `json:"owner,omitempty"`
+	RefCount int        `json:"refcount"`
+	Data     string     `json:"data,omitempty"` // hex, if byte-array.
+	Elems    []ElemDump `json:"elems,omitempty"`
+}
+
+type ElemDump struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type,omitempty"`
+	Value string      `json:"value,omitempty"` // if not an object.
+	Owned *ObjectDump `json:"owned,omitempty"` // if owned.
+	Ref   string      `json:"ref,omitempty"`   // if borrowed.
+}
+
+// Dumps the realm's package block and its owned descendants.
+// The realm should be finalized, such that object hashes are
+// up to date.  Unloaded objects are loaded from the realm's
+// store.
+func DumpRealm(rlm *Realm) (*ObjectDump, error) {
+	if rlm.pkg == nil {
+		return nil, errors.New("realm has no package")
+	}
+	return DumpObject(&rlm.pkg.Block), nil
+}
+
+// Dumps oo and its owned descendants.
+func DumpObject(oo Object) *ObjectDump {
+	return dumpObject(oo, make(map[Object]struct{}))
+}
+
+func dumpObject(oo Object, seen map[Object]struct{}) *ObjectDump {
+	seen[oo] = struct{}{}
+	oi := oo.GetObjectInfo()
+	od := &ObjectDump{
+		ID:       dumpObjectID(oi.ID),
+		Hash:     hex.EncodeToString(oi.Hash[:]),
+		RefCount: oi.RefCount,
+	}
+	if po := oi.Owner; po != nil {
+		od.Owner = dumpObjectID(po.GetObjectID())
+	}
+	names := []string(nil)
+	switch cv := oo.(type) {
+	case *ArrayValue:
+		od.Kind = "array"
+		if cv.Data != nil {
+			od.Data = hex.EncodeToString(cv.Data)
+			return od
+		}
+		for i := range cv.List {
+			names = append(names, fmt.Sprintf("[%d]", i))
+		}
+	case *StructValue:
+		od.Kind = "struct"
+		for i := range cv.Fields {
+			if cv.st != nil && i < len(cv.st.Fields) {
+				names = append(names, string(cv.st.Fields[i].Name))
+			} else {
+				names = append(names, fmt.Sprintf("%d", i))
+			}
+		}
+	case *MapValue:
+		od.Kind = "map"
+		if cv.List == nil {
+			return od
+		}
+		for cur := cv.List.Head; cur != nil; cur = cur.Next {
+			ed := dumpElem(oo, &cur.Value, seen)
+			ed.Name = dumpValue(&cur.Key)
+			od.Elems = append(od.Elems, ed)
+		}
+		return od
+	case *Block:
+		od.Kind = "block"
+		if cv.Source != nil {
+			for _, n := range cv.Source.GetNames() {
+				names = append(names, string(n))
+			}
+		}
+	default:
+		panic("should not happen")
+	}
+	i := 0
+	forEachElem(oo, func(tv *TypedValue) {
+		ed := dumpElem(oo, tv, seen)
+		if i < len(names) {
+			ed.Name = names[i]
+		} else {
+			ed.Name = fmt.Sprintf("%d", i)
+		}
+		od.Elems = append(od.Elems, ed)
+		i++
+	})
+	return od
+}
+
+func dumpElem(oo Object, tv *TypedValue, seen map[Object]struct{}) ElemDump {
+	ed := ElemDump{}
+	if tv.T != nil {
+		ed.Type = tv.T.String()
+	}
+	ch := tv.GetFirstObject()
+	if ch == nil {
+		ed.Value = dumpValue(tv)
+		return ed
+	}
+	if _, ok := seen[ch]; !ok && ch.GetOwner() == oo {
+		ed.Owned = dumpObject(ch, seen)
+	} else {
+		ed.Ref = dumpObjectID(ch.GetObjectID())
+	}
+	return ed
+}
+
+// Returns a short representation of tv, which must not refer
+// to an object.
+func dumpValue(tv *TypedValue) string {
+	if tv.T == nil {
+		return "undefined"
+	}
+	switch tv.T.Kind() {
+	case StringKind:
+		return strconv.Quote(string(tv.GetString()))
+	case InterfaceKind:
+		return "nil"
+	}
+	if _, ok := baseOf(tv.T).(PrimitiveType); ok {
+		return printString(tv)
+	}
+	switch cv := tv.V.(type) {
+	case nil:
+		return "nil"
+	case TypeValue:
+		return cv.Type.String()
+	default:
+		return fmt.Sprintf("%v", tv.V)
+	}
+}
+
+// ObjectIDs are dumped as <realm id>:<ordinal>, or "new" if
+// not yet real.
+func dumpObjectID(oid ObjectID) string {
+	if oid.IsZero() {
+		return "new"
+	}
+	return fmt.Sprintf("%X:%d", oid.RealmID.Bytes(), oid.Ordinal)
+}
+
+func (od *ObjectDump) String() string {
+	return od.StringIndented("    ")
+}
+
+// Each element is printed on its own line, prefixed by indent,
+// and the elements of owned objects further indented.
+func (od *ObjectDump) StringIndented(indent string) string {
+	lines := []string{}
+	head := fmt.Sprintf("%s[%s] hash=%s refcount=%d",
+		od.Kind, od.ID, od.Hash, od.RefCount)
+	if od.Owner != "" {
+		head += " owner=" + od.Owner
+	}
+	if od.Data != "" {
+		head += " data=" + od.Data
+	}
+	lines = append(lines, head)
+	for _, ed := range od.Elems {
+		prefix := fmt.Sprintf("%s%s %s: ", indent, ed.Name, ed.Type)
+		switch {
+		case ed.Owned != nil:
+			lines = append(lines,
+				prefix+ed.Owned.StringIndented(indent+"    "))
+		case ed.Ref != "":
+			lines = append(lines, prefix+"borrowed["+ed.Ref+"]")
+		default:
+			lines = append(lines, prefix+ed.Value)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (od *ObjectDump) JSON() ([]byte, error) {
+	return json.MarshalIndent(od, "", "  ")
+}
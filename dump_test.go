@@ -0,0 +1,77 @@
+package gno
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+func TestDumpRealm(t *testing.T) {
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewMemRealmer())
+	m := NewMachineWithOptions(MachineOptions{
+		Package: pv,
+		Output:  ioutil.Discard,
+	})
+	m.RunFiles(MustParseFile("main.go", `package test
+type Leaf struct {
+	Name string
+}
+type Node struct {
+	Name string
+	Left *Leaf
+	Also *Leaf
+}
+var count int
+var root *Node
+func main() {
+	count = 7
+	root = &Node{Name: "root", Left: &Leaf{Name: "l"}}
+	root.Also = root.Left
+}`))
+	m.RunMain()
+	rlm := pv.GetRealm()
+	rootv := pv.Block.Values[pn.GetPathForName("root").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	leftv := rootv.Fields[1].V.(PointerValue).TypedValue.V.(*StructValue)
+
+	od, err := DumpRealm(rlm)
+	assert.Nil(t, err)
+	assert.Equal(t, od.Kind, "block")
+	assert.Equal(t, od.ID, dumpObjectID(pv.Block.GetObjectID()))
+	elems := make(map[string]ElemDump)
+	for _, ed := range od.Elems {
+		elems[ed.Name] = ed
+	}
+	assert.Equal(t, elems["count"].Value, "7")
+	rootd := elems["root"].Owned
+	assert.NotNil(t, rootd)
+	assert.Equal(t, rootd.Kind, "struct")
+	assert.Equal(t, rootd.Owner, od.ID)
+	assert.Equal(t, rootd.Elems[0].Name, "Name")
+	assert.Equal(t, rootd.Elems[0].Value, `"root"`)
+	// left is owned, and also borrowed by the same owner.
+	leftd := rootd.Elems[1].Owned
+	assert.NotNil(t, leftd)
+	assert.Equal(t, leftd.RefCount, 2)
+	assert.Equal(t, leftd.Hash, hex.EncodeToString(leftv.Hash[:]))
+	assert.Equal(t, rootd.Elems[2].Ref, dumpObjectID(leftv.GetObjectID()))
+
+	// text.
+	s := od.String()
+	assert.True(t, strings.HasPrefix(s, "block["))
+	assert.Contains(t, s, "\n    root *gno.land/r/test.Node: struct[")
+	assert.Contains(t, s, "\n        Name string: \"root\"")
+	assert.Contains(t, s, "\n        Also *gno.land/r/test.Leaf: borrowed[")
+
+	// json.
+	bz, err := od.JSON()
+	assert.Nil(t, err)
+	od2 := new(ObjectDump)
+	assert.Nil(t, json.Unmarshal(bz, od2))
+	assert.Equal(t, od2, od)
+}
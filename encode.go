@@ -0,0 +1,1311 @@
+package gno
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+//----------------------------------------
+// Encoding
+//
+// Objects and typed values are encoded deterministically into
+// bytes that can be decoded back into an equivalent object or
+// value.  Unlike ValuePreimage, which only commits to a value,
+// the encoding is complete, and child objects are referred to
+// by ObjectID, to be loaded lazily (see RefValue) or eagerly
+// where a Go pointer is required (e.g. the base of a slice).
+// Every encoding starts with the EncodingVersion.
+//
+// `Object := ver,ot,sz(oid),sz(oh),sz(ownerid),rc,size,...`
+// `... := 0x00,n,TypedValue*n` if array.
+// `... := 0x01,sz(data)` if byte-array.
+// `... := Type|0x00,n,TypedValue*n` if struct.
+// `... := 0x00` if nil map.
+// `... := 0x01,n,(TypedValue,TypedValue)*n` if map (list order).
+// `... := NodeLoc,BlockRef(parent),n,TypedValue*n` if block.
+//
+// `TypedValue := 0x00` if undefined.
+// `TypedValue := Type,Value`
+//
+// `Value := 0x00` if typed-nil.
+// `Value := 0x01,sz(pb(.))` if primitive.
+// `Value := 0x02,sz(oid),idx` if ptr into object.
+// `Value := 0x03,ObjectRef` if ptr to object.
+// `Value := 0x04,TypedValue` if other ptr.
+// `Value := 0x05,ObjectRef` if array, struct, or map.
+// `Value := 0x06,sz(oid),off,len,max` if slice.
+// `Value := 0x07,Type,name,filename,NodeLoc,BlockRef` if func.
+// `Value := 0x08,Type` if type.
+// `Value := 0x09,sz(oid),Type` if weakref.
+//
+// `ObjectRef := sz(oid),sz(oh)` where oh is zero if borrowed.
+// `NodeLoc := sz(pkgpath),sz(filename),idx` of a block node.
+// `BlockRef := 0x00` if nil.
+// `BlockRef := 0x01,sz(oid)` if real.
+// `BlockRef := 0x02,sz(pkgpath)` if package block.
+// `BlockRef := 0x03,sz(pkgpath),sz(filename)` if file block.
+//
+// Types are encoded by structure, except declared types, which
+// are referred to by TypeID and must be known to the Resolver.
+// Declared types are defined separately (see EncodeType()):
+//
+// `DeclaredType := ver,0x0B,sz(tid),pkgpath,name,Type(base),n,TypedValue*n`
+//  * the n TypedValues are the methods of the declared type.
+//
+// Strings and names are size-prefixed, and integers and
+// booleans are uvarint encoded.
+//
+// * idx of a block node is its index in the file's nodes, in
+//   depth-first order, the file node itself being 0.
+// * pb(.) of a bigint is its decimal representation.
+
+const EncodingVersion = byte(0x01)
+
+// Provides what is needed to decode objects and values, but is
+// not included in their encoding.
+type Resolver interface {
+	GetObject(oid ObjectID) Object           // nil if not found.
+	GetType(tid TypeID) Type                 // nil if not found.
+	GetPackage(pkgPath string) *PackageValue // nil if not found.
+}
+
+// Object types.
+const (
+	encObjectArray  = byte(0x01)
+	encObjectStruct = byte(0x02)
+	encObjectMap    = byte(0x03)
+	encObjectBlock  = byte(0x04)
+)
+
+// Value types.
+const (
+	encValueNil       = byte(0x00)
+	encValuePrimitive = byte(0x01)
+	encValuePtrInto   = byte(0x02)
+	encValuePtrObject = byte(0x03)
+	encValuePtrOther  = byte(0x04)
+	encValueObject    = byte(0x05)
+	encValueSlice     = byte(0x06)
+	encValueFunc      = byte(0x07)
+	encValueType      = byte(0x08)
+	encValueWeakRef   = byte(0x09)
+)
+
+// Block references.
+const (
+	encBlockNil     = byte(0x00)
+	encBlockReal    = byte(0x01)
+	encBlockPackage = byte(0x02)
+	encBlockFile    = byte(0x03)
+)
+
+// Types.
+const (
+	encTypePrimitive = byte(0x01)
+	encTypePointer   = byte(0x02)
+	encTypeArray     = byte(0x03)
+	encTypeSlice     = byte(0x04)
+	encTypeStruct    = byte(0x05)
+	encTypeFunc      = byte(0x06)
+	encTypeMap       = byte(0x07)
+	encTypeInterface = byte(0x08)
+	encTypeType      = byte(0x09)
+	encTypeWeakRef   = byte(0x0A)
+	encTypeDeclared  = byte(0x0B)
+	encTypePackage   = byte(0x0C)
+)
+
+// Primitive types by their encoding.  Append only.
+var encPrimitiveTypes = []PrimitiveType{
+	InvalidType, // not encoded.
+	UntypedBoolType,
+	BoolType,
+	UntypedStringType,
+	StringType,
+	IntType,
+	Int8Type,
+	Int16Type,
+	UntypedRuneType,
+	Int32Type,
+	Int64Type,
+	UintType,
+	Uint8Type,
+	DataByteType,
+	Uint16Type,
+	Uint32Type,
+	Uint64Type,
+	UntypedBigintType,
+	BigintType,
+}
+
+// Encodes a real object, including its ObjectInfo.
+func EncodeObject(oo Object) (bz []byte, err error) {
+	bz, _, err = encodeObject(oo)
+	return
+}
+
+// Also returns the declared types referred to, such that they
+// can be stored along with the object.
+func encodeObject(oo Object) (bz []byte, dts []*DeclaredType, err error) {
+	defer recoverEncodingError(&err)
+	enc := &encoder{}
+	enc.writeByte(EncodingVersion)
+	enc.writeObject(oo)
+	return enc.buf, enc.dtypes, nil
+}
+
+// Decodes an object encoded with EncodeObject().  Children are
+// RefValues to be bound to a realm (see Realm.GetObject()).
+func DecodeObject(bz []byte, rs Resolver) (oo Object, err error) {
+	defer recoverEncodingError(&err)
+	dec := &decoder{buf: bz, rs: rs}
+	dec.readVersion()
+	oo = dec.readObject()
+	dec.readEnd()
+	return oo, nil
+}
+
+// Decodes the package block of pv, encoded with EncodeObject(),
+// into pv.Block itself, such that pointers into the package
+// block refer to it.
+func decodePackageBlock(bz []byte, rs Resolver, pv *PackageValue) (err error) {
+	defer recoverEncodingError(&err)
+	dec := &decoder{buf: bz, rs: rs, into: &pv.Block}
+	dec.readVersion()
+	if len(dec.buf) == 0 || dec.buf[0] != encObjectBlock {
+		encodingErrorf("expected package block of %s", pv.PkgPath)
+	}
+	dec.readObject()
+	dec.readEnd()
+	if pv.Block.Source != pv.Source {
+		encodingErrorf("expected package block of %s", pv.PkgPath)
+	}
+	return nil
+}
+
+// Encodes a typed value, which may not itself be an object,
+// but may refer to real objects.
+func EncodeTypedValue(tv *TypedValue) (bz []byte, err error) {
+	defer recoverEncodingError(&err)
+	enc := &encoder{}
+	enc.writeByte(EncodingVersion)
+	enc.writeTypedValue(tv)
+	return enc.buf, nil
+}
+
+// Decodes a typed value encoded with EncodeTypedValue().
+func DecodeTypedValue(bz []byte, rs Resolver) (tv TypedValue, err error) {
+	defer recoverEncodingError(&err)
+	dec := &decoder{buf: bz, rs: rs}
+	dec.readVersion()
+	tv = dec.readTypedValue()
+	dec.readEnd()
+	return tv, nil
+}
+
+// Encodes a type.  Unlike within objects and values, declared
+// types are encoded with their definition, including methods.
+func EncodeType(t Type) (bz []byte, err error) {
+	bz, _, err = encodeType(t)
+	return
+}
+
+// Also returns the other declared types referred to.
+func encodeType(t Type) (bz []byte, dts []*DeclaredType, err error) {
+	defer recoverEncodingError(&err)
+	enc := &encoder{}
+	enc.writeByte(EncodingVersion)
+	if dt, ok := t.(*DeclaredType); ok {
+		enc.writeDeclaredType(dt)
+	} else {
+		enc.writeType(t)
+	}
+	return enc.buf, enc.dtypes, nil
+}
+
+// Decodes a type encoded with EncodeType().
+func DecodeType(bz []byte, rs Resolver) (t Type, err error) {
+	return decodeType(bz, rs, nil)
+}
+
+// If the type is a declared type, it is decoded into dt if not
+// nil, which may already be referred to, e.g. by its own
+// methods.
+func decodeType(bz []byte, rs Resolver, dt *DeclaredType) (t Type, err error) {
+	defer recoverEncodingError(&err)
+	dec := &decoder{buf: bz, rs: rs}
+	dec.readVersion()
+	if len(dec.buf) > 0 && dec.buf[0] == encTypeDeclared {
+		if dt == nil {
+			dt = &DeclaredType{}
+		}
+		dec.readDeclaredType(dt)
+		t = dt
+	} else {
+		t = dec.readType()
+	}
+	dec.readEnd()
+	return t, nil
+}
+
+// Internally, encoding errors are panics recovered by the
+// functions above.
+type encodingError struct {
+	error
+}
+
+func encodingErrorf(format string, args ...interface{}) {
+	panic(encodingError{fmt.Errorf(format, args...)})
+}
+
+func recoverEncodingError(err *error) {
+	if r := recover(); r != nil {
+		if ee, ok := r.(encodingError); ok {
+			*err = ee.error
+			return
+		}
+		panic(r)
+	}
+}
+
+//----------------------------------------
+// encoder
+
+type encoder struct {
+	buf    []byte
+	dtypes []*DeclaredType // declared types referred to.
+}
+
+func (enc *encoder) writeByte(b byte) {
+	enc.buf = append(enc.buf, b)
+}
+
+func (enc *encoder) writeUvarint(u uint64) {
+	enc.buf = append(enc.buf, uvarintBytes(u)...)
+}
+
+func (enc *encoder) writeInt(i int) {
+	if i < 0 {
+		panic("should not happen")
+	}
+	enc.writeUvarint(uint64(i))
+}
+
+func (enc *encoder) writeBool(b bool) {
+	if b {
+		enc.writeByte(0x01)
+	} else {
+		enc.writeByte(0x00)
+	}
+}
+
+// NOTE: unlike sizedBytes(), not padded.
+func (enc *encoder) writeBytes(bz []byte) {
+	enc.writeInt(len(bz))
+	enc.buf = append(enc.buf, bz...)
+}
+
+func (enc *encoder) writeString(s string) {
+	enc.writeBytes([]byte(s))
+}
+
+func (enc *encoder) writeObjectID(oid ObjectID) {
+	if oid.IsZero() {
+		enc.writeBytes(nil)
+	} else {
+		enc.writeBytes(oid.Bytes())
+	}
+}
+
+func (enc *encoder) writeObject(oo Object) {
+	oi := oo.GetObjectInfo()
+	if !oi.GetIsReal() {
+		encodingErrorf("cannot encode object that is not real")
+	}
+	switch oo.(type) {
+	case *ArrayValue:
+		enc.writeByte(encObjectArray)
+	case *StructValue:
+		enc.writeByte(encObjectStruct)
+	case *MapValue:
+		enc.writeByte(encObjectMap)
+	case *Block:
+		enc.writeByte(encObjectBlock)
+	default:
+		panic("should not happen")
+	}
+	enc.writeObjectID(oi.ID)
+	enc.writeBytes(oi.Hash[:])
+	if oi.Owner == nil {
+		enc.writeObjectID(oi.ownerID) // zero unless decoded.
+	} else {
+		enc.writeObjectID(oi.Owner.GetObjectID())
+	}
+	enc.writeInt(oi.RefCount)
+	enc.writeInt(int(oi.Size))
+	switch cv := oo.(type) {
+	case *ArrayValue:
+		if cv.Data != nil {
+			enc.writeByte(0x01)
+			enc.writeBytes(cv.Data)
+			return
+		}
+		enc.writeByte(0x00)
+		enc.writeTypedValues(oo, cv.List)
+	case *StructValue:
+		if cv.st == nil {
+			enc.writeByte(0x00)
+		} else {
+			enc.writeType(cv.st)
+		}
+		enc.writeTypedValues(oo, cv.Fields)
+	case *MapValue:
+		if cv.List == nil {
+			enc.writeByte(0x00)
+			return
+		}
+		enc.writeByte(0x01)
+		enc.writeInt(cv.List.Size)
+		for cur := cv.List.Head; cur != nil; cur = cur.Next {
+			enc.writeElem(oo, &cur.Key)
+			enc.writeElem(oo, &cur.Value)
+		}
+	case *Block:
+		enc.writeNodeLoc(cv.Source)
+		enc.writeBlockRef(cv.Parent)
+		enc.writeTypedValues(oo, cv.Values)
+	default:
+		panic("should not happen")
+	}
+}
+
+func (enc *encoder) writeTypedValues(oo Object, tvs []TypedValue) {
+	enc.writeInt(len(tvs))
+	for i := range tvs {
+		enc.writeElem(oo, &tvs[i])
+	}
+}
+
+func (enc *encoder) writeTypedValue(tv *TypedValue) {
+	enc.writeElem(nil, tv)
+}
+
+// Writes tv, an element of parent if any.  Children owned by
+// parent are referred to with their object hash.
+func (enc *encoder) writeElem(parent Object, tv *TypedValue) {
+	if tv.IsUndefined() {
+		enc.writeByte(0x00)
+		return
+	}
+	enc.writeType(tv.T)
+	if tv.V == nil {
+		if _, ok := baseOf(tv.T).(PrimitiveType); !ok {
+			enc.writeByte(encValueNil)
+			return
+		}
+	}
+	switch bt := baseOf(tv.T).(type) {
+	case PrimitiveType:
+		enc.writeByte(encValuePrimitive)
+		if bt.Kind() == BigintKind {
+			enc.writeString(tv.V.(BigintValue).V.Text(10))
+		} else {
+			enc.writeBytes(tv.PrimitiveBytes())
+		}
+	case PointerType:
+		pv := tv.V.(PointerValue)
+		if pv.Base != nil {
+			base := pv.GetBase()
+			if !base.GetIsReal() {
+				encodingErrorf("cannot encode pointer into object that is not real")
+			}
+			if pv.Index < 0 {
+				encodingErrorf("cannot encode pointer into map")
+			}
+			enc.writeByte(encValuePtrInto)
+			enc.writeObjectID(base.GetObjectID())
+			enc.writeInt(pv.Index)
+		} else if pv.TypedValue == nil {
+			enc.writeByte(encValueNil)
+		} else if oo, ok := pv.TypedValue.V.(Object); ok {
+			enc.writeByte(encValuePtrObject)
+			enc.writeObjectRef(parent, oo)
+		} else if rv, ok := pv.TypedValue.V.(RefValue); ok {
+			enc.writeByte(encValuePtrObject)
+			enc.writeRefValue(rv)
+		} else {
+			enc.writeByte(encValuePtrOther)
+			enc.writeElem(parent, pv.TypedValue)
+		}
+	case *ArrayType, *StructType, *MapType:
+		enc.writeByte(encValueObject)
+		if rv, ok := tv.V.(RefValue); ok {
+			enc.writeRefValue(rv)
+		} else {
+			enc.writeObjectRef(parent, tv.V.(Object))
+		}
+	case *SliceType:
+		sv := tv.V.(*SliceValue)
+		if sv.Base == nil {
+			enc.writeByte(encValueNil)
+			return
+		}
+		if !sv.Base.GetIsReal() {
+			encodingErrorf("cannot encode slice of array that is not real")
+		}
+		enc.writeByte(encValueSlice)
+		enc.writeObjectID(sv.Base.GetObjectID())
+		enc.writeInt(sv.Offset)
+		enc.writeInt(sv.Length)
+		enc.writeInt(sv.Maxcap)
+	case *FuncType:
+		fv, ok := tv.V.(*FuncValue)
+		if !ok {
+			encodingErrorf("cannot encode %s value", tv.T.String())
+		}
+		if fv.NativeBody != nil {
+			encodingErrorf("cannot encode native function %s", fv.Name)
+		}
+		enc.writeByte(encValueFunc)
+		enc.writeType(fv.Type)
+		enc.writeBool(fv.IsMethod)
+		enc.writeString(string(fv.Name))
+		enc.writeString(string(fv.FileName))
+		enc.writeNodeLoc(fv.Source)
+		enc.writeBlockRef(fv.Closure)
+	case *TypeType:
+		enc.writeByte(encValueType)
+		enc.writeType(tv.V.(TypeValue).Type)
+	case *WeakRefType:
+		wv := tv.V.(WeakRefValue)
+		enc.writeByte(encValueWeakRef)
+		enc.writeObjectID(wv.GetTargetID())
+		enc.writeType(wv.T)
+	default:
+		encodingErrorf("cannot encode %s value", tv.T.String())
+	}
+}
+
+// Owned objects are referred to with their object hash.  Like
+// elem preimages, objects with a refcount of 1 are considered
+// owned.
+func (enc *encoder) writeObjectRef(parent Object, oo Object) {
+	if !oo.GetIsReal() {
+		encodingErrorf("cannot encode reference to object that is not real")
+	}
+	enc.writeObjectID(oo.GetObjectID())
+	if parent != nil && (oo.GetOwner() == parent || oo.GetRefCount() == 1) {
+		oh := objectHash(nil, oo)
+		enc.writeBytes(oh[:])
+	} else {
+		enc.writeBytes(nil)
+	}
+}
+
+// Unloaded objects are referred to as persisted.
+func (enc *encoder) writeRefValue(rv RefValue) {
+	enc.writeObjectID(rv.ObjectID)
+	if rv.Hash == (ValueHash{}) {
+		enc.writeBytes(nil)
+	} else {
+		enc.writeBytes(rv.Hash[:])
+	}
+}
+
+func (enc *encoder) writeBlockRef(b *Block) {
+	switch {
+	case b == nil:
+		enc.writeByte(encBlockNil)
+	case b.GetIsReal():
+		enc.writeByte(encBlockReal)
+		enc.writeObjectID(b.GetObjectID())
+	default:
+		switch bn := b.Source.(type) {
+		case *PackageNode:
+			enc.writeByte(encBlockPackage)
+			enc.writeString(bn.PkgPath)
+		case *FileNode:
+			enc.writeByte(encBlockFile)
+			enc.writeString(packageOf(bn).PkgPath)
+			enc.writeString(string(bn.Name))
+		default:
+			// closures, see checkClosures().
+			encodingErrorf("cannot encode block that is not real")
+		}
+	}
+}
+
+// Returns true if b may be referred to by an encoded function
+// value, see writeBlockRef().
+func isEncodableBlock(b *Block) bool {
+	if b == nil || b.GetIsReal() {
+		return true
+	}
+	switch b.Source.(type) {
+	case *PackageNode, *FileNode:
+		return true
+	default:
+		return false
+	}
+}
+
+func (enc *encoder) writeNodeLoc(bn BlockNode) {
+	if bn == nil {
+		encodingErrorf("cannot encode nil block node")
+	}
+	if pn, ok := bn.(*PackageNode); ok {
+		enc.writeString(pn.PkgPath)
+		enc.writeString("")
+		enc.writeInt(0)
+		return
+	}
+	fn := fileOf(bn)
+	if fn == nil {
+		encodingErrorf("cannot encode block node outside of file")
+	}
+	enc.writeString(packageOf(fn).PkgPath)
+	enc.writeString(string(fn.Name))
+	idx := -1
+	forEachBlockNode(fn, func(i int, n BlockNode) bool {
+		if n == bn {
+			idx = i
+			return true
+		}
+		return false
+	})
+	if idx < 0 {
+		panic("should not happen")
+	}
+	enc.writeInt(idx)
+}
+
+func (enc *encoder) writeType(t Type) {
+	switch ct := t.(type) {
+	case PrimitiveType:
+		enc.writeByte(encTypePrimitive)
+		for i, pt := range encPrimitiveTypes {
+			if pt == ct && pt != InvalidType {
+				enc.writeInt(i)
+				return
+			}
+		}
+		encodingErrorf("cannot encode primitive type %v", ct)
+	case PointerType:
+		enc.writeByte(encTypePointer)
+		enc.writeType(ct.Elt)
+	case *ArrayType:
+		enc.writeByte(encTypeArray)
+		enc.writeInt(ct.Len)
+		enc.writeBool(ct.Vrd)
+		enc.writeType(ct.Elt)
+	case *SliceType:
+		enc.writeByte(encTypeSlice)
+		enc.writeBool(ct.Vrd)
+		enc.writeType(ct.Elt)
+	case *StructType:
+		enc.writeByte(encTypeStruct)
+		enc.writeString(ct.PkgPath)
+		enc.writeFieldTypes(ct.Fields)
+		enc.writeInt(len(ct.Mapping))
+		for _, flat := range ct.Mapping {
+			enc.writeInt(flat)
+		}
+	case *FuncType:
+		enc.writeByte(encTypeFunc)
+		enc.writeString(ct.PkgPath)
+		enc.writeFieldTypes(ct.Params)
+		enc.writeFieldTypes(ct.Results)
+	case *MapType:
+		enc.writeByte(encTypeMap)
+		enc.writeType(ct.Key)
+		enc.writeType(ct.Value)
+	case *InterfaceType:
+		enc.writeByte(encTypeInterface)
+		enc.writeString(ct.PkgPath)
+		enc.writeFieldTypes(ct.Methods)
+	case *TypeType:
+		enc.writeByte(encTypeType)
+	case *WeakRefType:
+		enc.writeByte(encTypeWeakRef)
+	case *DeclaredType:
+		enc.writeByte(encTypeDeclared)
+		tid := ct.TypeID()
+		enc.writeBytes(tid[:])
+		enc.addDeclaredType(ct)
+	case *PackageType:
+		enc.writeByte(encTypePackage)
+	default:
+		encodingErrorf("cannot encode type %s", t.String())
+	}
+}
+
+func (enc *encoder) addDeclaredType(dt *DeclaredType) {
+	for _, dt2 := range enc.dtypes {
+		if dt2 == dt {
+			return
+		}
+	}
+	enc.dtypes = append(enc.dtypes, dt)
+}
+
+func (enc *encoder) writeDeclaredType(dt *DeclaredType) {
+	enc.writeByte(encTypeDeclared)
+	tid := dt.TypeID()
+	enc.writeBytes(tid[:])
+	enc.writeString(dt.PkgPath)
+	enc.writeString(string(dt.Name))
+	enc.writeType(dt.Base)
+	enc.writeInt(len(dt.Methods))
+	for i := range dt.Methods {
+		enc.writeTypedValue(&dt.Methods[i])
+	}
+}
+
+func (enc *encoder) writeFieldTypes(fts []FieldType) {
+	enc.writeInt(len(fts))
+	for _, ft := range fts {
+		enc.writeString(string(ft.Name))
+		enc.writeType(ft.Type)
+		enc.writeString(string(ft.Embedded))
+		enc.writeString(string(ft.Tag))
+		enc.writeBool(ft.Owned)
+	}
+}
+
+//----------------------------------------
+// decoder
+
+type decoder struct {
+	buf  []byte
+	rs   Resolver
+	self Object // object being decoded, if any.
+	into *Block // block to decode into, if any.
+}
+
+func (dec *decoder) readVersion() {
+	if ver := dec.readByte(); ver != EncodingVersion {
+		encodingErrorf("unsupported encoding version %d", ver)
+	}
+}
+
+func (dec *decoder) readEnd() {
+	if len(dec.buf) != 0 {
+		encodingErrorf("%d unexpected trailing bytes", len(dec.buf))
+	}
+}
+
+func (dec *decoder) readByte() byte {
+	if len(dec.buf) == 0 {
+		encodingErrorf("unexpected end of bytes")
+	}
+	b := dec.buf[0]
+	dec.buf = dec.buf[1:]
+	return b
+}
+
+func (dec *decoder) readUvarint() uint64 {
+	u, n := binary.Uvarint(dec.buf)
+	if n <= 0 {
+		encodingErrorf("invalid uvarint")
+	}
+	dec.buf = dec.buf[n:]
+	return u
+}
+
+func (dec *decoder) readInt() int {
+	u := dec.readUvarint()
+	if u > uint64(maxInt) {
+		encodingErrorf("integer overflow")
+	}
+	return int(u)
+}
+
+func (dec *decoder) readBool() bool {
+	switch b := dec.readByte(); b {
+	case 0x00:
+		return false
+	case 0x01:
+		return true
+	default:
+		encodingErrorf("invalid bool %X", b)
+		return false
+	}
+}
+
+func (dec *decoder) readBytes() []byte {
+	n := dec.readInt()
+	if len(dec.buf) < n {
+		encodingErrorf("unexpected end of bytes")
+	}
+	bz := dec.buf[:n:n]
+	dec.buf = dec.buf[n:]
+	return bz
+}
+
+func (dec *decoder) readString() string {
+	return string(dec.readBytes())
+}
+
+func (dec *decoder) readHash() (vh ValueHash) {
+	bz := dec.readBytes()
+	switch len(bz) {
+	case 0:
+		return
+	case HashSize:
+		copy(vh[:], bz)
+		return
+	default:
+		encodingErrorf("invalid hash length %d", len(bz))
+		return
+	}
+}
+
+func (dec *decoder) readObjectID() (oid ObjectID) {
+	bz := dec.readBytes()
+	switch len(bz) {
+	case 0:
+		return
+	case HashSize + 8:
+		copy(oid.RealmID.Hashlet[:], bz[:HashSize])
+		oid.Ordinal = binary.BigEndian.Uint64(bz[HashSize:])
+		return
+	default:
+		encodingErrorf("invalid object id length %d", len(bz))
+		return
+	}
+}
+
+// Loads a real object that must be known upon decoding, e.g.
+// the base of a slice.
+func (dec *decoder) loadObject(oid ObjectID) Object {
+	if oid.IsZero() {
+		encodingErrorf("missing object id")
+	}
+	// e.g. a pointer into the object being decoded, whose
+	// elements are allocated before they are decoded.
+	// XXX other cycles of such references are not supported.
+	if dec.self != nil && dec.self.GetObjectID() == oid {
+		return dec.self
+	}
+	if dec.rs == nil {
+		encodingErrorf("object %v not found", oid)
+	}
+	oo := dec.rs.GetObject(oid)
+	if oo == nil {
+		encodingErrorf("object %v not found", oid)
+	}
+	return oo
+}
+
+func (dec *decoder) readObject() Object {
+	ot := dec.readByte()
+	oi := ObjectInfo{}
+	oi.ID = dec.readObjectID()
+	oi.Hash = dec.readHash()
+	oi.ownerID = dec.readObjectID()
+	oi.RefCount = dec.readInt()
+	oi.Size = int64(dec.readInt())
+	if oi.ID.IsZero() {
+		encodingErrorf("missing object id")
+	}
+	switch ot {
+	case encObjectArray:
+		av := &ArrayValue{ObjectInfo: oi}
+		dec.self = av
+		if dec.readBool() {
+			av.Data = dec.readBytes()
+		} else {
+			av.List = dec.allocTypedValues()
+			dec.readTypedValuesInto(av.List)
+		}
+		return av
+	case encObjectStruct:
+		sv := &StructValue{ObjectInfo: oi}
+		if t := dec.readTypeOrNil(); t != nil {
+			st, ok := t.(*StructType)
+			if !ok {
+				encodingErrorf("expected struct type but got %s", t.String())
+			}
+			sv.st = st
+		}
+		dec.self = sv
+		sv.Fields = dec.allocTypedValues()
+		dec.readTypedValuesInto(sv.Fields)
+		return sv
+	case encObjectMap:
+		mv := &MapValue{ObjectInfo: oi}
+		if !dec.readBool() {
+			return mv
+		}
+		n := dec.readInt()
+		mv.MakeMap(n)
+		for i := 0; i < n; i++ {
+			key := dec.readTypedValue()
+			mli := mv.List.Append(key)
+			mli.Value = dec.readTypedValue()
+			mv.vmap[key.ComputeMapKey(false)] = mli
+		}
+		return mv
+	case encObjectBlock:
+		b := dec.into
+		if b == nil {
+			b = &Block{}
+		}
+		b.ObjectInfo = oi
+		dec.self = b
+		b.Source = dec.readNodeLoc()
+		b.Parent = dec.readBlockRef()
+		b.Values = dec.allocTypedValues()
+		dec.readTypedValuesInto(b.Values)
+		return b
+	default:
+		encodingErrorf("unknown object type %X", ot)
+		return nil
+	}
+}
+
+func (dec *decoder) allocTypedValues() []TypedValue {
+	n := dec.readInt()
+	if len(dec.buf) < n {
+		encodingErrorf("unexpected end of bytes")
+	}
+	return make([]TypedValue, n)
+}
+
+func (dec *decoder) readTypedValuesInto(tvs []TypedValue) {
+	for i := range tvs {
+		tvs[i] = dec.readTypedValue()
+	}
+}
+
+func (dec *decoder) readTypedValue() (tv TypedValue) {
+	tv.T = dec.readTypeOrNil()
+	if tv.T == nil {
+		return // undefined
+	}
+	vt := dec.readByte()
+	if vt == encValueNil {
+		if _, ok := baseOf(tv.T).(PrimitiveType); ok {
+			encodingErrorf("unexpected nil %s value", tv.T.String())
+		}
+		return
+	}
+	switch bt := baseOf(tv.T).(type) {
+	case PrimitiveType:
+		dec.expect(vt, encValuePrimitive)
+		dec.readPrimitive(&tv, bt)
+	case PointerType:
+		switch vt {
+		case encValuePtrInto:
+			oo := dec.loadObject(dec.readObjectID())
+			idx := dec.readInt()
+			tv.V = pointerInto(oo, idx)
+		case encValuePtrObject:
+			rv := dec.readRefValue()
+			tv.V = PointerValue{
+				TypedValue: &TypedValue{T: bt.Elt, V: rv},
+			}
+		case encValuePtrOther:
+			ptv := dec.readTypedValue()
+			tv.V = PointerValue{TypedValue: &ptv}
+		default:
+			encodingErrorf("unexpected value type %X for pointer", vt)
+		}
+	case *ArrayType, *StructType, *MapType:
+		dec.expect(vt, encValueObject)
+		tv.V = dec.readRefValue()
+	case *SliceType:
+		dec.expect(vt, encValueSlice)
+		oo := dec.loadObject(dec.readObjectID())
+		av, ok := oo.(*ArrayValue)
+		if !ok {
+			encodingErrorf("expected array base of slice")
+		}
+		sv := &SliceValue{Base: av}
+		sv.Offset = dec.readInt()
+		sv.Length = dec.readInt()
+		sv.Maxcap = dec.readInt()
+		if sv.Offset+sv.Maxcap > av.GetCapacity() || sv.Length > sv.Maxcap {
+			encodingErrorf("slice out of range of base")
+		}
+		tv.V = sv
+	case *FuncType:
+		dec.expect(vt, encValueFunc)
+		tv.V = dec.readFuncValue()
+	case *TypeType:
+		dec.expect(vt, encValueType)
+		tv.V = TypeValue{Type: dec.readType()}
+	case *WeakRefType:
+		dec.expect(vt, encValueWeakRef)
+		wv := WeakRefValue{}
+		wv.TargetID = dec.readObjectID()
+		wv.T = dec.readType()
+		tv.V = wv
+	default:
+		encodingErrorf("cannot decode %s value", tv.T.String())
+	}
+	return
+}
+
+func (dec *decoder) expect(vt, expected byte) {
+	if vt != expected {
+		encodingErrorf("unexpected value type %X, expected %X", vt, expected)
+	}
+}
+
+func (dec *decoder) readPrimitive(tv *TypedValue, pt PrimitiveType) {
+	if pt.Kind() == BigintKind {
+		s := dec.readString()
+		bi, ok := big.NewInt(0).SetString(s, 10)
+		if !ok {
+			encodingErrorf("invalid bigint %q", s)
+		}
+		tv.V = BigintValue{V: bi}
+		return
+	}
+	bz := dec.readBytes()
+	if pt.Kind() == StringKind {
+		tv.V = StringValue(string(bz))
+		return
+	}
+	var u uint64
+	switch len(bz) {
+	case 1:
+		u = uint64(bz[0])
+	case 2:
+		u = uint64(binary.BigEndian.Uint16(bz))
+	case 4:
+		u = uint64(binary.BigEndian.Uint32(bz))
+	case 8:
+		u = binary.BigEndian.Uint64(bz)
+	default:
+		encodingErrorf("invalid %s bytes", pt.String())
+	}
+	switch pt.Kind() {
+	case BoolKind:
+		tv.SetBool(u != 0)
+	case IntKind:
+		tv.SetInt(int(u))
+	case Int8Kind:
+		tv.SetInt8(int8(u))
+	case Int16Kind:
+		tv.SetInt16(int16(u))
+	case Int32Kind:
+		tv.SetInt32(int32(u))
+	case Int64Kind:
+		tv.SetInt64(int64(u))
+	case UintKind:
+		tv.SetUint(uint(u))
+	case Uint8Kind:
+		tv.SetUint8(uint8(u))
+	case Uint16Kind:
+		tv.SetUint16(uint16(u))
+	case Uint32Kind:
+		tv.SetUint32(uint32(u))
+	case Uint64Kind:
+		tv.SetUint64(u)
+	default:
+		panic("should not happen")
+	}
+}
+
+func (dec *decoder) readRefValue() RefValue {
+	rv := RefValue{}
+	rv.ObjectID = dec.readObjectID()
+	rv.Hash = dec.readHash()
+	if rv.ObjectID.IsZero() {
+		encodingErrorf("missing object id")
+	}
+	return rv
+}
+
+func (dec *decoder) readFuncValue() *FuncValue {
+	fv := &FuncValue{}
+	ft, ok := dec.readType().(*FuncType)
+	if !ok {
+		encodingErrorf("expected func type")
+	}
+	fv.Type = ft
+	fv.IsMethod = dec.readBool()
+	fv.Name = Name(dec.readString())
+	fv.FileName = Name(dec.readString())
+	fv.Source = dec.readNodeLoc()
+	switch bn := fv.Source.(type) {
+	case *FuncDecl:
+		fv.Body = bn.Body
+	case *FuncLitExpr:
+		fv.Body = bn.Body
+	default:
+		encodingErrorf("unexpected func source %T", bn)
+	}
+	fv.Closure = dec.readBlockRef()
+	fv.pkg = dec.getPackage(packageOf(fv.Source).PkgPath)
+	return fv
+}
+
+// Returns a pointer to the idx'th element of oo.
+func pointerInto(oo Object, idx int) PointerValue {
+	var tvs []TypedValue
+	var base Value
+	switch cv := oo.(type) {
+	case *ArrayValue:
+		tvs, base = cv.List, cv
+	case *StructValue:
+		tvs, base = cv.Fields, cv
+	case *Block:
+		tvs, base = cv.Values, blockValue{cv}
+	default:
+		encodingErrorf("cannot decode pointer into %T", oo)
+	}
+	if idx >= len(tvs) {
+		encodingErrorf("pointer index %d out of range", idx)
+	}
+	return PointerValue{
+		TypedValue: &tvs[idx],
+		Base:       base,
+		Index:      idx,
+	}
+}
+
+func (dec *decoder) getPackage(pkgPath string) *PackageValue {
+	if dec.rs == nil {
+		encodingErrorf("package %s not found", pkgPath)
+	}
+	pv := dec.rs.GetPackage(pkgPath)
+	if pv == nil {
+		encodingErrorf("package %s not found", pkgPath)
+	}
+	return pv
+}
+
+func (dec *decoder) getFileBlock(pkgPath string, fname Name) *Block {
+	fb := dec.getPackage(pkgPath).FBlocks[fname]
+	if fb == nil {
+		encodingErrorf("file %s not found in package %s", fname, pkgPath)
+	}
+	return fb
+}
+
+func (dec *decoder) readBlockRef() *Block {
+	switch br := dec.readByte(); br {
+	case encBlockNil:
+		return nil
+	case encBlockReal:
+		b, ok := dec.loadObject(dec.readObjectID()).(*Block)
+		if !ok {
+			encodingErrorf("expected block")
+		}
+		return b
+	case encBlockPackage:
+		return &dec.getPackage(dec.readString()).Block
+	case encBlockFile:
+		pkgPath := dec.readString()
+		return dec.getFileBlock(pkgPath, Name(dec.readString()))
+	default:
+		encodingErrorf("unknown block reference %X", br)
+		return nil
+	}
+}
+
+func (dec *decoder) readNodeLoc() BlockNode {
+	pkgPath := dec.readString()
+	fname := Name(dec.readString())
+	idx := dec.readInt()
+	if fname == "" {
+		return dec.getPackage(pkgPath).Source
+	}
+	fn, ok := dec.getFileBlock(pkgPath, fname).Source.(*FileNode)
+	if !ok {
+		panic("should not happen")
+	}
+	var found BlockNode
+	forEachBlockNode(fn, func(i int, n BlockNode) bool {
+		if i == idx {
+			found = n
+			return true
+		}
+		return false
+	})
+	if found == nil {
+		encodingErrorf("node %d not found in file %s", idx, fname)
+	}
+	return found
+}
+
+func (dec *decoder) readTypeOrNil() Type {
+	if len(dec.buf) > 0 && dec.buf[0] == 0x00 {
+		dec.buf = dec.buf[1:]
+		return nil
+	}
+	return dec.readType()
+}
+
+func (dec *decoder) readType() Type {
+	switch tt := dec.readByte(); tt {
+	case encTypePrimitive:
+		i := dec.readInt()
+		if i == 0 || len(encPrimitiveTypes) <= i {
+			encodingErrorf("unknown primitive type %d", i)
+		}
+		return encPrimitiveTypes[i]
+	case encTypePointer:
+		return PointerType{Elt: dec.readType()}
+	case encTypeArray:
+		at := &ArrayType{}
+		at.Len = dec.readInt()
+		at.Vrd = dec.readBool()
+		at.Elt = dec.readType()
+		return at
+	case encTypeSlice:
+		st := &SliceType{}
+		st.Vrd = dec.readBool()
+		st.Elt = dec.readType()
+		return st
+	case encTypeStruct:
+		st := &StructType{}
+		st.PkgPath = dec.readString()
+		st.Fields = dec.readFieldTypes()
+		if n := dec.readInt(); n > 0 {
+			if len(dec.buf) < n {
+				encodingErrorf("unexpected end of bytes")
+			}
+			st.Mapping = make([]int, n)
+			for i := range st.Mapping {
+				st.Mapping[i] = dec.readInt()
+			}
+		}
+		return st
+	case encTypeFunc:
+		ft := &FuncType{}
+		ft.PkgPath = dec.readString()
+		ft.Params = dec.readFieldTypes()
+		ft.Results = dec.readFieldTypes()
+		return ft
+	case encTypeMap:
+		mt := &MapType{}
+		mt.Key = dec.readType()
+		mt.Value = dec.readType()
+		return mt
+	case encTypeInterface:
+		it := &InterfaceType{}
+		it.PkgPath = dec.readString()
+		it.Methods = dec.readFieldTypes()
+		return it
+	case encTypeType:
+		return gTypeType
+	case encTypeWeakRef:
+		return gWeakRefType
+	case encTypeDeclared:
+		bz := dec.readBytes()
+		if len(bz) != HashSize {
+			encodingErrorf("invalid type id length %d", len(bz))
+		}
+		var tid TypeID
+		copy(tid[:], bz)
+		if dec.rs == nil {
+			encodingErrorf("declared type %v not found", tid)
+		}
+		dt, ok := dec.rs.GetType(tid).(*DeclaredType)
+		if !ok {
+			encodingErrorf("declared type %v not found", tid)
+		}
+		return dt
+	case encTypePackage:
+		return &PackageType{}
+	default:
+		encodingErrorf("unknown type %X", tt)
+		return nil
+	}
+}
+
+// Reads the definition of a declared type into dt.
+func (dec *decoder) readDeclaredType(dt *DeclaredType) {
+	if tt := dec.readByte(); tt != encTypeDeclared {
+		panic("should not happen")
+	}
+	bz := dec.readBytes()
+	if len(bz) != HashSize {
+		encodingErrorf("invalid type id length %d", len(bz))
+	}
+	dt.PkgPath = dec.readString()
+	dt.Name = Name(dec.readString())
+	dt.Base = dec.readType()
+	if _, ok := dt.Base.(*DeclaredType); ok {
+		encodingErrorf("base of declared type is declared")
+	}
+	n := dec.readInt()
+	if len(dec.buf) < n {
+		encodingErrorf("unexpected end of bytes")
+	}
+	dt.Methods = make([]TypedValue, n)
+	for i := range dt.Methods {
+		dt.Methods[i] = dec.readTypedValue()
+		if _, ok := dt.Methods[i].V.(*FuncValue); !ok {
+			encodingErrorf("expected method of %s", dt.Name)
+		}
+	}
+	if dt.TypeID() != TypeID(hashletOf(bz)) {
+		encodingErrorf("type id mismatch for %s.%s", dt.PkgPath, dt.Name)
+	}
+}
+
+func (dec *decoder) readFieldTypes() []FieldType {
+	n := dec.readInt()
+	if n == 0 {
+		return nil
+	}
+	if len(dec.buf) < n {
+		encodingErrorf("unexpected end of bytes")
+	}
+	fts := make([]FieldType, n)
+	for i := range fts {
+		fts[i].Name = Name(dec.readString())
+		fts[i].Type = dec.readType()
+		fts[i].Embedded = Name(dec.readString())
+		fts[i].Tag = Tag(dec.readString())
+		fts[i].Owned = dec.readBool()
+	}
+	return fts
+}
+
+//----------------------------------------
+// misc
+
+const maxInt = int(^uint(0) >> 1)
+
+func hashletOf(bz []byte) (h Hashlet) {
+	copy(h[:], bz)
+	return
+}
+
+// Returns the file node that bn is declared in, or nil.
+func fileOf(bn BlockNode) *FileNode {
+	for bn != nil {
+		if fn, ok := bn.(*FileNode); ok {
+			return fn
+		}
+		bn = bn.GetParent()
+	}
+	return nil
+}
+
+// Calls fn for each block node of the file in depth-first
+// order, with its index, the file node itself being 0, until
+// fn returns true.
+// XXX this walks the file for each function or block encoded.
+func forEachBlockNode(fn *FileNode, cb func(i int, bn BlockNode) bool) {
+	i := 0
+	Transcribe(fn, func(ns []Node, ftype TransField, index int, n Node, stage TransStage) (Node, TransCtrl) {
+		if stage != TRANS_ENTER {
+			return n, TRANS_CONTINUE
+		}
+		if bn, ok := n.(BlockNode); ok {
+			if cb(i, bn) {
+				return n, TRANS_EXIT
+			}
+			i++
+		}
+		return n, TRANS_CONTINUE
+	})
+}
@@ -0,0 +1,737 @@
+package gno
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"unsafe"
+)
+
+//----------------------------------------
+// Package encoding
+//
+// A preprocessed package node is encoded along with everything
+// it refers to, such as its file nodes, static blocks, static
+// values, and attributes (e.g. ATTR_TYPE_VALUE), such that it
+// can be reloaded without parsing or preprocessing its files
+// again.
+//
+// Unlike objects, nodes are encoded by reflection over their
+// Go structure, including unexported fields.  Go pointers are
+// encoded as references to the allocation they point into, so
+// the node graph is restored with its cycles and shared nodes.
+//
+// `Package := ver,n,sz(typename)*n,Pointer` where the Pointer
+//  is to the *PackageNode, and typenames are the Go types of
+//  allocations and interface values, in sorted order.
+//
+// `Value := uvarint` if bool or uint.
+// `Value := varint` if int.
+// `Value := uvarint(bits)` if float.
+// `Value := sz(s)` if string.
+// `Value := Value*n` if array or struct (but func fields).
+// `Value := 0x00` if nil slice, map, or interface.
+// `Value := 0x01,n,Value*n` if slice.
+// `Value := 0x01,n,(Value,Value)*n` if map, sorted by key.
+// `Value := 0x01,typeidx,Value` if interface.
+// `Value := Pointer` if pointer.
+//
+// `Pointer := 0x00` if nil.
+// `Pointer := 0x01,typeidx,Value,Path` if new allocation.
+// `Pointer := 0x02,allocidx,Path` if already encoded.
+// `Pointer := 0x03,sz(pkgpath)` if to an imported package.
+// `Pointer := 0x04,sz(tid)` if to another package's type.
+// `Pointer := 0x05,uidx` if to a uverse value or type.
+// `Path := n,idx*n` of fields (or array elements) from the
+//  allocation to the pointee.
+//
+// * uidx is 2*i for the value at index i of the uverse block,
+//   or 2*i+1 for the type of that value (a TypeValue).
+// * the closure and package of function values are not
+//   encoded, but set upon PackageNode.NewPackage().
+// * XXX pointers into slices are decoded as copies.
+
+// Pointer types.
+const (
+	encPointerNil      = byte(0x00)
+	encPointerNew      = byte(0x01)
+	encPointerPrev     = byte(0x02)
+	encPointerPackage  = byte(0x03)
+	encPointerDeclared = byte(0x04)
+	encPointerUverse   = byte(0x05)
+)
+
+// Go types that may be allocated or held by interfaces in
+// package nodes, by their name.
+var encNodeTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, x := range []interface{}{
+		// primitives and attributes
+		false, "", int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		Name(""), Word(0), GnoAttribute(0), ChanDir(0), big.Int{},
+		// nodes
+		NameExpr{}, BasicLitExpr{}, BinaryExpr{}, CallExpr{},
+		IndexExpr{}, SelectorExpr{}, SliceExpr{}, StarExpr{},
+		RefExpr{}, TypeAssertExpr{}, UnaryExpr{},
+		CompositeLitExpr{}, KeyValueExpr{}, FuncLitExpr{},
+		constExpr{}, FieldTypeExpr{}, ArrayTypeExpr{},
+		SliceTypeExpr{}, InterfaceTypeExpr{}, ChanTypeExpr{},
+		FuncTypeExpr{}, MapTypeExpr{}, StructTypeExpr{},
+		constTypeExpr{}, AssignStmt{}, BlockStmt{}, BranchStmt{},
+		DeclStmt{}, DeferStmt{}, EmptyStmt{}, ExprStmt{},
+		ForStmt{}, GoStmt{}, IfStmt{}, IncDecStmt{},
+		LabeledStmt{}, RangeStmt{}, ReturnStmt{}, SelectStmt{},
+		SelectCaseStmt{}, SendStmt{}, SwitchStmt{},
+		SwitchCaseStmt{}, loopStmt{}, FuncDecl{}, ImportDecl{},
+		ValueDecl{}, TypeDecl{}, FileSet{}, FileNode{},
+		PackageNode{}, StaticBlock{},
+		// types
+		PrimitiveType(0), FieldType{}, ArrayType{}, SliceType{},
+		PointerType{}, StructType{}, PackageType{},
+		InterfaceType{}, ChanType{}, FuncType{}, MapType{},
+		TypeType{}, WeakRefType{}, DeclaredType{}, blockType{},
+		// values
+		StringValue(""), BigintValue{}, DataByteValue{},
+		PointerValue{}, ArrayValue{}, SliceValue{},
+		StructValue{}, FuncValue{}, BoundMethodValue{},
+		MapValue{}, MapList{}, MapListItem{}, TypeValue{},
+		blockValue{}, RefValue{}, WeakRefValue{}, TypedValue{},
+		Block{}, PackageValue{}, // referred to by path.
+	} {
+		t := reflect.TypeOf(x)
+		encNodeTypes[t.String()] = t
+		pt := reflect.PtrTo(t)
+		encNodeTypes[pt.String()] = pt
+	}
+}
+
+// Fields not encoded, by Go type, besides func fields.
+var encTransientFields = map[reflect.Type]map[string]bool{
+	reflect.TypeOf(FuncValue{}): {"Closure": true, "pkg": true},
+}
+
+func isTransientField(t reflect.Type, i int) bool {
+	f := t.Field(i)
+	if f.Type.Kind() == reflect.Func {
+		return true
+	}
+	return encTransientFields[t][f.Name]
+}
+
+// Encodes a preprocessed package node, see Store.SetPackage().
+// Imported packages, the declared types of other packages, and
+// uverse values are referred to rather than encoded.
+func EncodePackageNode(pn *PackageNode) (bz []byte, err error) {
+	defer recoverEncodingError(&err)
+	enc := &nodeEncoder{
+		encoder: &encoder{},
+		pn:      pn,
+		uverse:  uverseRefs(),
+		allocs:  make(map[nodeAddr]*nodeAlloc),
+		types:   make(map[reflect.Type]struct{}),
+	}
+	rv := reflect.ValueOf(pn)
+	enc.discover(rv)
+	enc.prepare()
+	enc.writeByte(EncodingVersion)
+	enc.writeInt(len(enc.tnames))
+	for _, tname := range enc.tnames {
+		enc.writeString(tname)
+	}
+	enc.writeValue(rv)
+	return enc.buf, nil
+}
+
+// Decodes a package node encoded with EncodePackageNode().
+// Imported packages and types are resolved with rs.
+func DecodePackageNode(bz []byte, rs Resolver) (pn *PackageNode, err error) {
+	defer recoverEncodingError(&err)
+	dec := &nodeDecoder{decoder: &decoder{buf: bz, rs: rs}}
+	dec.readVersion()
+	n := dec.readInt()
+	if len(dec.buf) < n {
+		encodingErrorf("unexpected end of bytes")
+	}
+	dec.types = make([]reflect.Type, n)
+	for i := range dec.types {
+		tname := dec.readString()
+		t, ok := encNodeTypes[tname]
+		if !ok {
+			encodingErrorf("unknown node type %s", tname)
+		}
+		dec.types[i] = t
+	}
+	rv := reflect.New(reflect.TypeOf(pn)).Elem()
+	dec.readValue(rv)
+	dec.readEnd()
+	pn = rv.Interface().(*PackageNode)
+	if pn == nil {
+		encodingErrorf("missing package node")
+	}
+	return pn, nil
+}
+
+//----------------------------------------
+// nodeEncoder
+
+// Identifies what a Go pointer points to.
+type nodeAddr struct {
+	t reflect.Type // of pointee.
+	p uintptr
+}
+
+// Memory pointed to by some Go pointer.  Allocations may contain
+// others, e.g. the static block of a node.
+type nodeAlloc struct {
+	ptr   reflect.Value
+	start uintptr
+	end   uintptr
+	root  *nodeAlloc // outermost containing allocation.
+	idx   int        // of root, once encoded, or -1.
+}
+
+type nodeEncoder struct {
+	*encoder
+	pn     *PackageNode
+	uverse map[nodeAddr]int
+	allocs map[nodeAddr]*nodeAlloc
+	types  map[reflect.Type]struct{}
+	tnames []string
+	tidxs  map[reflect.Type]int
+	nroots int
+}
+
+// Returns the uidx of the uverse values and types that are
+// referred to by pointer.
+func uverseRefs() map[nodeAddr]int {
+	refs := make(map[nodeAddr]int)
+	for i, tv := range UverseNode().Values {
+		if rv := reflect.ValueOf(tv.V); rv.Kind() == reflect.Ptr {
+			refs[nodeAddr{rv.Type().Elem(), rv.Pointer()}] = 2 * i
+		}
+		if tt, ok := tv.V.(TypeValue); ok {
+			if rt := reflect.ValueOf(tt.Type); rt.Kind() == reflect.Ptr {
+				refs[nodeAddr{rt.Type().Elem(), rt.Pointer()}] = 2*i + 1
+			}
+		}
+	}
+	return refs
+}
+
+// Returns true if the pointer rv is encoded by reference.
+func (enc *nodeEncoder) isExternal(rv reflect.Value) bool {
+	if _, ok := enc.uverse[nodeAddr{rv.Type().Elem(), rv.Pointer()}]; ok {
+		return true
+	}
+	switch x := unsafe.Pointer(rv.Pointer()); rv.Type() {
+	case reflect.TypeOf(&PackageValue{}):
+		pv := (*PackageValue)(x)
+		if pv.PkgPath == enc.pn.PkgPath {
+			encodingErrorf("unexpected reference to package %s", pv.PkgPath)
+		}
+		return true
+	case reflect.TypeOf(&PackageNode{}):
+		if (*PackageNode)(x) != enc.pn {
+			encodingErrorf("unexpected package node %s",
+				(*PackageNode)(x).PkgPath)
+		}
+	case reflect.TypeOf(&DeclaredType{}):
+		return (*DeclaredType)(x).PkgPath != enc.pn.PkgPath
+	}
+	return false
+}
+
+func (enc *nodeEncoder) addType(t reflect.Type) {
+	if encNodeTypes[t.String()] != t {
+		encodingErrorf("cannot encode node type %v", t)
+	}
+	enc.types[t] = struct{}{}
+}
+
+// Finds the allocations pointed to from rv, and the types to be
+// named.
+func (enc *nodeEncoder) discover(rv reflect.Value) {
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() || enc.isExternal(rv) {
+			return
+		}
+		t := rv.Type().Elem()
+		addr := nodeAddr{t, rv.Pointer()}
+		if _, ok := enc.allocs[addr]; ok {
+			return
+		}
+		enc.allocs[addr] = &nodeAlloc{
+			ptr:   rv,
+			start: addr.p,
+			end:   addr.p + t.Size(),
+			idx:   -1,
+		}
+		enc.discover(rv.Elem())
+	case reflect.Interface:
+		if !rv.IsNil() {
+			enc.addType(rv.Elem().Type())
+			enc.discover(rv.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if !isTransientField(rv.Type(), i) {
+				enc.discover(rv.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			enc.discover(rv.Index(i))
+		}
+	case reflect.Map:
+		for _, key := range rv.MapKeys() {
+			enc.discover(key)
+			enc.discover(rv.MapIndex(key))
+		}
+	}
+}
+
+// Finds the root of each allocation, and sorts the type names.
+func (enc *nodeEncoder) prepare() {
+	allocs := make([]*nodeAlloc, 0, len(enc.allocs))
+	for _, na := range enc.allocs {
+		allocs = append(allocs, na)
+	}
+	sort.Slice(allocs, func(i, j int) bool {
+		ai, aj := allocs[i], allocs[j]
+		if ai.start != aj.start {
+			return ai.start < aj.start
+		}
+		if ai.end != aj.end {
+			return ai.end > aj.end
+		}
+		// e.g. a struct and its only field.
+		_, ok := fieldPath(ai.ptr.Type().Elem(), 0, aj.ptr.Type().Elem())
+		return ok && ai.ptr.Type() != aj.ptr.Type()
+	})
+	var root *nodeAlloc
+	for _, na := range allocs {
+		if root != nil && na.start < root.end {
+			if na.end > root.end {
+				encodingErrorf("overlapping allocations")
+			}
+			na.root = root
+		} else {
+			root = na
+			root.root = root
+			enc.addType(root.ptr.Type().Elem())
+		}
+	}
+	enc.tidxs = make(map[reflect.Type]int, len(enc.types))
+	for t := range enc.types {
+		enc.tnames = append(enc.tnames, t.String())
+	}
+	sort.Strings(enc.tnames)
+	for i, tname := range enc.tnames {
+		enc.tidxs[encNodeTypes[tname]] = i
+	}
+}
+
+func (enc *nodeEncoder) writeVarint(i int64) {
+	bz := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(bz, i)
+	enc.buf = append(enc.buf, bz[:n]...)
+}
+
+func (enc *nodeEncoder) writeTypeIdx(t reflect.Type) {
+	idx, ok := enc.tidxs[t]
+	if !ok {
+		panic("should not happen")
+	}
+	enc.writeInt(idx)
+}
+
+func (enc *nodeEncoder) writeValue(rv reflect.Value) {
+	switch rv.Kind() {
+	case reflect.Bool:
+		enc.writeBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16,
+		reflect.Int32, reflect.Int64:
+		enc.writeVarint(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		enc.writeUvarint(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		enc.writeUvarint(math.Float64bits(rv.Float()))
+	case reflect.String:
+		enc.writeString(rv.String())
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			enc.writeValue(rv.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if isTransientField(rv.Type(), i) {
+				if f := rv.Field(i); f.Kind() == reflect.Func && !f.IsNil() {
+					encodingErrorf("cannot encode native function")
+				}
+				continue
+			}
+			enc.writeValue(rv.Field(i))
+		}
+	case reflect.Slice:
+		if rv.IsNil() {
+			enc.writeByte(0x00)
+			return
+		}
+		enc.writeByte(0x01)
+		enc.writeInt(rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			enc.writeValue(rv.Index(i))
+		}
+	case reflect.Map:
+		if rv.IsNil() {
+			enc.writeByte(0x00)
+			return
+		}
+		enc.writeByte(0x01)
+		enc.writeMap(rv)
+	case reflect.Interface:
+		if rv.IsNil() {
+			enc.writeByte(0x00)
+			return
+		}
+		enc.writeByte(0x01)
+		enc.writeTypeIdx(rv.Elem().Type())
+		enc.writeValue(rv.Elem())
+	case reflect.Ptr:
+		enc.writePointer(rv)
+	default:
+		encodingErrorf("cannot encode %v", rv.Type())
+	}
+}
+
+// Map entries are sorted by the encoding of their keys, which
+// must not contain pointers.
+func (enc *nodeEncoder) writeMap(rv reflect.Value) {
+	type entry struct {
+		kbz []byte
+		key reflect.Value
+	}
+	entries := make([]entry, 0, rv.Len())
+	buf := enc.buf
+	for _, key := range rv.MapKeys() {
+		enc.buf = nil
+		enc.writeKey(key)
+		entries = append(entries, entry{enc.buf, key})
+	}
+	enc.buf = buf
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].kbz, entries[j].kbz) < 0
+	})
+	enc.writeInt(len(entries))
+	for _, e := range entries {
+		enc.buf = append(enc.buf, e.kbz...)
+		enc.writeValue(rv.MapIndex(e.key))
+	}
+}
+
+func (enc *nodeEncoder) writeKey(key reflect.Value) {
+	switch key.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		encodingErrorf("cannot encode map key %v", key.Type())
+	case reflect.Interface:
+		if !key.IsNil() {
+			enc.writeByte(0x01)
+			enc.writeTypeIdx(key.Elem().Type())
+			enc.writeKey(key.Elem())
+			return
+		}
+	case reflect.Struct:
+		for i := 0; i < key.NumField(); i++ {
+			enc.writeKey(key.Field(i))
+		}
+		return
+	}
+	enc.writeValue(key)
+}
+
+func (enc *nodeEncoder) writePointer(rv reflect.Value) {
+	if rv.IsNil() {
+		enc.writeByte(encPointerNil)
+		return
+	}
+	addr := nodeAddr{rv.Type().Elem(), rv.Pointer()}
+	if uidx, ok := enc.uverse[addr]; ok {
+		enc.writeByte(encPointerUverse)
+		enc.writeInt(uidx)
+		return
+	}
+	if enc.isExternal(rv) {
+		switch x := unsafe.Pointer(rv.Pointer()); rv.Type() {
+		case reflect.TypeOf(&PackageValue{}):
+			enc.writeByte(encPointerPackage)
+			enc.writeString((*PackageValue)(x).PkgPath)
+		case reflect.TypeOf(&DeclaredType{}):
+			enc.writeByte(encPointerDeclared)
+			enc.writeBytes((*DeclaredType)(x).TypeID().Bytes())
+		default:
+			panic("should not happen")
+		}
+		return
+	}
+	na := enc.allocs[addr]
+	root := na.root
+	if root.idx < 0 {
+		root.idx = enc.nroots
+		enc.nroots++
+		enc.writeByte(encPointerNew)
+		enc.writeTypeIdx(root.ptr.Type().Elem())
+		enc.writeValue(root.ptr.Elem())
+	} else {
+		enc.writeByte(encPointerPrev)
+		enc.writeInt(root.idx)
+	}
+	path, ok := fieldPath(root.ptr.Type().Elem(), na.start-root.start, addr.t)
+	if !ok {
+		encodingErrorf("cannot encode pointer to %v in %v",
+			addr.t, root.ptr.Type().Elem())
+	}
+	enc.writeInt(len(path))
+	for _, idx := range path {
+		enc.writeInt(idx)
+	}
+}
+
+// Returns the indices of the fields or array elements of t
+// at offset off of type target.
+func fieldPath(t reflect.Type, off uintptr, target reflect.Type) (path []int, ok bool) {
+	for {
+		if off == 0 && t == target {
+			return path, true
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			found := false
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				if f.Offset <= off && off < f.Offset+f.Type.Size() {
+					path = append(path, i)
+					off -= f.Offset
+					t = f.Type
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case reflect.Array:
+			esz := t.Elem().Size()
+			if esz == 0 {
+				return nil, false
+			}
+			i := off / esz
+			path = append(path, int(i))
+			off -= i * esz
+			t = t.Elem()
+		default:
+			return nil, false
+		}
+	}
+}
+
+//----------------------------------------
+// nodeDecoder
+
+type nodeDecoder struct {
+	*decoder
+	types  []reflect.Type
+	allocs []reflect.Value
+}
+
+func (dec *nodeDecoder) readVarint() int64 {
+	i, n := binary.Varint(dec.buf)
+	if n <= 0 {
+		encodingErrorf("invalid varint")
+	}
+	dec.buf = dec.buf[n:]
+	return i
+}
+
+func (dec *nodeDecoder) readTypeIdx() reflect.Type {
+	idx := dec.readInt()
+	if idx >= len(dec.types) {
+		encodingErrorf("type index %d out of range", idx)
+	}
+	return dec.types[idx]
+}
+
+// Returns a settable rv, which may be an unexported field.
+func settable(rv reflect.Value) reflect.Value {
+	if rv.CanSet() {
+		return rv
+	}
+	return reflect.NewAt(rv.Type(), unsafe.Pointer(rv.UnsafeAddr())).Elem()
+}
+
+// Decodes into rv, which must be settable.
+func (dec *nodeDecoder) readValue(rv reflect.Value) {
+	switch t := rv.Type(); rv.Kind() {
+	case reflect.Bool:
+		rv.SetBool(dec.readBool())
+	case reflect.Int, reflect.Int8, reflect.Int16,
+		reflect.Int32, reflect.Int64:
+		i := dec.readVarint()
+		if rv.OverflowInt(i) {
+			encodingErrorf("%v overflow", t)
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := dec.readUvarint()
+		if rv.OverflowUint(u) {
+			encodingErrorf("%v overflow", t)
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		rv.SetFloat(math.Float64frombits(dec.readUvarint()))
+	case reflect.String:
+		rv.SetString(dec.readString())
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			dec.readValue(rv.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if !isTransientField(t, i) {
+				dec.readValue(settable(rv.Field(i)))
+			}
+		}
+	case reflect.Slice:
+		if !dec.readBool() {
+			return
+		}
+		n := dec.readInt()
+		if len(dec.buf) < n && t.Elem().Size() > 0 {
+			encodingErrorf("unexpected end of bytes")
+		}
+		sv := reflect.MakeSlice(t, n, n)
+		for i := 0; i < n; i++ {
+			dec.readValue(sv.Index(i))
+		}
+		rv.Set(sv)
+	case reflect.Map:
+		if !dec.readBool() {
+			return
+		}
+		n := dec.readInt()
+		if len(dec.buf) < n {
+			encodingErrorf("unexpected end of bytes")
+		}
+		mv := reflect.MakeMapWithSize(t, n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			dec.readValue(key)
+			val := reflect.New(t.Elem()).Elem()
+			dec.readValue(val)
+			mv.SetMapIndex(key, val)
+		}
+		rv.Set(mv)
+	case reflect.Interface:
+		if !dec.readBool() {
+			return
+		}
+		ct := dec.readTypeIdx()
+		if !ct.AssignableTo(t) {
+			encodingErrorf("%v is not a %v", ct, t)
+		}
+		cv := reflect.New(ct).Elem()
+		dec.readValue(cv)
+		rv.Set(cv)
+	case reflect.Ptr:
+		rv.Set(dec.readPointer(t))
+	default:
+		encodingErrorf("cannot decode %v", t)
+	}
+}
+
+func (dec *nodeDecoder) readPointer(pt reflect.Type) reflect.Value {
+	var ptr reflect.Value
+	switch pk := dec.readByte(); pk {
+	case encPointerNil:
+		return reflect.Zero(pt)
+	case encPointerNew:
+		ptr = reflect.New(dec.readTypeIdx())
+		dec.allocs = append(dec.allocs, ptr)
+		dec.readValue(ptr.Elem())
+		ptr = dec.readPath(ptr)
+	case encPointerPrev:
+		idx := dec.readInt()
+		if idx >= len(dec.allocs) {
+			encodingErrorf("allocation index %d out of range", idx)
+		}
+		ptr = dec.readPath(dec.allocs[idx])
+	case encPointerPackage:
+		ptr = reflect.ValueOf(dec.getPackage(dec.readString()))
+	case encPointerDeclared:
+		bz := dec.readBytes()
+		if len(bz) != HashSize {
+			encodingErrorf("invalid type id length %d", len(bz))
+		}
+		tid := TypeID(hashletOf(bz))
+		var t Type
+		if dec.rs != nil {
+			t = dec.rs.GetType(tid)
+		}
+		if t == nil {
+			encodingErrorf("type %v not found", tid)
+		}
+		ptr = reflect.ValueOf(t)
+	case encPointerUverse:
+		uidx := dec.readInt()
+		uvs := UverseNode().Values
+		if uidx/2 >= len(uvs) {
+			encodingErrorf("uverse index %d out of range", uidx)
+		}
+		tv := uvs[uidx/2]
+		if uidx%2 == 0 {
+			ptr = reflect.ValueOf(tv.V)
+		} else if tt, ok := tv.V.(TypeValue); ok {
+			ptr = reflect.ValueOf(tt.Type)
+		}
+	default:
+		encodingErrorf("unknown pointer type %X", pk)
+	}
+	if !ptr.IsValid() || ptr.Type() != pt {
+		encodingErrorf("expected %v", pt)
+	}
+	return ptr
+}
+
+// Returns a pointer into the allocation ptr, by path.
+func (dec *nodeDecoder) readPath(ptr reflect.Value) reflect.Value {
+	n := dec.readInt()
+	if n == 0 {
+		return ptr
+	}
+	rv := ptr.Elem()
+	for i := 0; i < n; i++ {
+		idx := dec.readInt()
+		switch rv.Kind() {
+		case reflect.Struct:
+			if idx >= rv.NumField() {
+				encodingErrorf("field index %d out of range", idx)
+			}
+			rv = rv.Field(idx)
+		case reflect.Array:
+			if idx >= rv.Len() {
+				encodingErrorf("array index %d out of range", idx)
+			}
+			rv = rv.Index(idx)
+		default:
+			encodingErrorf("cannot decode pointer into %v", rv.Type())
+		}
+	}
+	return reflect.NewAt(rv.Type(), unsafe.Pointer(rv.UnsafeAddr()))
+}
@@ -0,0 +1,135 @@
+package gno
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+// Resolves the declared types and package of pv.
+type testResolver struct {
+	pv *PackageValue
+}
+
+func (tr testResolver) GetObject(oid ObjectID) Object {
+	return nil
+}
+
+func (tr testResolver) GetType(tid TypeID) Type {
+	for _, tv := range tr.pv.Values {
+		if tv.T != nil && tv.T.Kind() == TypeKind {
+			if t := tv.GetType(); t.TypeID() == tid {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+func (tr testResolver) GetPackage(pkgPath string) *PackageValue {
+	if pkgPath == tr.pv.PkgPath {
+		return tr.pv
+	}
+	return nil
+}
+
+func TestEncodeObjects(t *testing.T) {
+	db := NewMemDB()
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(NewKVStore(db)))
+	_, r := runMain(MachineOptions{Package: pv}, `package test
+type Item struct {
+	Name  string
+	Tags  []string
+	Attrs map[string]int
+}
+var count int
+var first *Item
+var pcount = &count
+var handler = func() int {
+	return count
+}
+func main() {
+	count = 7
+	first = &Item{
+		Name:  "a",
+		Tags:  []string{"x", "y"},
+		Attrs: map[string]int{"z": 26, "b": 2},
+	}
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+
+	// decoding and re-encoding yields the same bytes.
+	store := NewKVStore(db)
+	store.SetResolver(testResolver{pv})
+	n := 0
+	for key, bz := range db.kvs {
+		if !strings.HasPrefix(key, "oid:") {
+			continue
+		}
+		oo, err := DecodeObject(bz, kvResolver{store})
+		assert.Nil(t, err)
+		bz2, err := EncodeObject(oo)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(bz, bz2))
+		n++
+	}
+	assert.Equal(t, n, 4) // block, item, tags, and attrs.
+
+	// a fresh store decodes objects, loaded lazily by the realm.
+	rlm2 := NewRealm(rlm.Path)
+	rlm2.SetStore(store)
+	pb := rlm2.GetObject(pv.Block.GetObjectID()).(*Block)
+	assert.Equal(t, pb.Source, BlockNode(pv.Block.Source))
+	assert.Equal(t, pb.Hash, pv.Block.Hash)
+	tv := &pb.Values[pv.Source.GetPathForName("count").Index]
+	assert.Equal(t, tv.GetInt(), 7)
+	tv = &pb.Values[pv.Source.GetPathForName("pcount").Index]
+	assert.Equal(t, tv.V.(PointerValue).Base, Value(blockValue{pb}))
+	assert.Equal(t, tv.V.(PointerValue).TypedValue.GetInt(), 7)
+	tv = &pb.Values[pv.Source.GetPathForName("handler").Index]
+	fv := tv.V.(*FuncValue)
+	assert.IsType(t, &FuncLitExpr{}, fv.Source)
+	assert.Equal(t, fv.GetPackage(), pv)
+	tv = &pb.Values[pv.Source.GetPathForName("first").Index]
+	sv := fillValue(tv.V.(PointerValue).TypedValue).V.(*StructValue)
+	assert.Equal(t, sv.GetOwner(), Object(pb))
+	assert.Equal(t, string(sv.Fields[0].GetString()), "a")
+	tags := sv.Fields[1].V.(*SliceValue)
+	assert.Equal(t, tags.Length, 2)
+	assert.Equal(t, string(tags.Base.List[1].GetString()), "y")
+	mv := fillValue(&sv.Fields[2]).V.(*MapValue)
+	assert.Equal(t, mv.GetOwner(), Object(sv))
+	key := TypedValue{T: StringType, V: StringValue("b")}
+	val, ok := mv.GetValueForKey(&key)
+	assert.True(t, ok)
+	assert.Equal(t, val.GetInt(), 2)
+	// insertion order is preserved.
+	assert.Equal(t, string(mv.List.Head.Key.GetString()), "z")
+	assert.Equal(t, objectHash(rlm2, mv), mv.Hash)
+	assert.Equal(t, objectHash(rlm2, sv), sv.Hash)
+}
+
+func TestEncodeErrors(t *testing.T) {
+	// objects must be real.
+	_, err := EncodeObject(&StructValue{})
+	assert.NotNil(t, err)
+	// unsupported versions are rejected.
+	tv := TypedValue{T: IntType}
+	tv.SetInt(42)
+	bz, err := EncodeTypedValue(&tv)
+	assert.Nil(t, err)
+	tv2, err := DecodeTypedValue(bz, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, tv2.GetInt(), 42)
+	bz[0] = EncodingVersion + 1
+	_, err = DecodeTypedValue(bz, nil)
+	assert.Contains(t, err.Error(), "unsupported encoding version")
+	// truncated bytes are rejected.
+	bz[0] = EncodingVersion
+	_, err = DecodeTypedValue(bz[:len(bz)-1], nil)
+	assert.NotNil(t, err)
+}
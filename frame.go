@@ -15,7 +15,7 @@ type Frame struct {
 	NumExprs  int  // number of exprs in stack
 	NumStmts  int  // number of statements in stack
 	NumBlocks int  // number of blocks in stack
-	BodyIndex int  // for call and for stmts
+	BodyIndex int  // for call, for, and switch stmts
 
 	// call frame
 	Func        *FuncValue    // function value
@@ -23,6 +23,7 @@ type Frame struct {
 	Receiver    Value         // if bound method
 	NumArgs     int           // number of arguments in call
 	IsVarg      bool          // is form fncall(???, vargs...)
+	IsDefer     bool          // is a deferred call
 	Defers      []Defer       // deferred calls
 	LastPackage *PackageValue // previous package context
 	LastRealm   *Realm        // previous realm context
@@ -66,7 +67,24 @@ func (fr Frame) String() string {
 // Defer
 
 type Defer struct {
-	Func   *FuncValue   // function value
-	GoFunc *nativeValue // go function value
-	Args   []TypedValue // arguments
+	Func     *FuncValue   // function value
+	GoFunc   *nativeValue // go function value
+	Receiver Value        // if bound method
+	Args     []TypedValue // arguments
+	Source   *DeferStmt   // source of defer
+}
+
+//----------------------------------------
+// Exception
+
+// A Gno-level panic, as by the uverse panic(), which unwinds
+// the frames of the machine until recovered.  If unrecovered,
+// the machine panics with the exception in Go.
+type Exception struct {
+	Value TypedValue // panicked value
+	Frame int        // index of frame running defers for it
+}
+
+func (ex Exception) Error() string {
+	return printString(&ex.Value)
 }
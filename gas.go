@@ -0,0 +1,146 @@
+package gno
+
+import (
+	"fmt"
+	"math"
+)
+
+//----------------------------------------
+// Gas
+//
+// A machine with a GasMeter is charged for each op it runs by
+// the op's cost in the meter's GasTable, and for operations
+// whose cost depends on size, such as allocation, copying, and
+// hashing upon realm finalization.  Size-dependent charges are
+// made before the operation.  Once the meter's limit would be
+// exceeded, the machine panics with an OutOfGasError, which
+// unwinds like any other panic, rolling back realms since
+// their last finalization (see Machine.commitOrRollback()).
+
+type Gas int64
+
+// Costs of ops, and of size-dependent operations per byte.
+type GasTable struct {
+	Ops       [256]Gas // by Op.
+	AllocByte Gas      // per byte allocated.
+	CopyByte  Gas      // per byte copied.
+	HashByte  Gas      // per byte hashed.
+}
+
+// Returns a table where each op costs 1, and size-dependent
+// operations cost 1 per byte.
+// XXX calibrate with benchmarks.
+func DefaultGasTable() *GasTable {
+	gt := &GasTable{
+		AllocByte: 1,
+		CopyByte:  1,
+		HashByte:  1,
+	}
+	for i := range gt.Ops {
+		gt.Ops[i] = 1
+	}
+	return gt
+}
+
+// Fixed sizes in bytes for size-dependent charges, which must
+// not depend on the platform for determinism.
+const (
+	sizeTypedValue = 40
+)
+
+type GasMeter struct {
+	Limit    Gas
+	Consumed Gas
+	Table    *GasTable
+}
+
+// If table is nil, the DefaultGasTable() is used.
+func NewGasMeter(limit Gas, table *GasTable) *GasMeter {
+	if table == nil {
+		table = DefaultGasTable()
+	}
+	return &GasMeter{
+		Limit: limit,
+		Table: table,
+	}
+}
+
+func (gm *GasMeter) Remaining() Gas {
+	return gm.Limit - gm.Consumed
+}
+
+// Panics with an OutOfGasError if the limit would be exceeded,
+// in which case no gas is consumed.
+func (gm *GasMeter) ConsumeGas(amount Gas, descriptor string) {
+	if amount < 0 {
+		panic("should not happen")
+	}
+	if amount > gm.Remaining() {
+		panic(OutOfGasError{
+			Descriptor: descriptor,
+			Limit:      gm.Limit,
+			Consumed:   gm.Consumed,
+			Amount:     amount,
+		})
+	}
+	gm.Consumed += amount
+}
+
+// Consumes perByte for each of size bytes.
+func (gm *GasMeter) consumeBytes(perByte Gas, size int64, descriptor string) {
+	if size < 0 {
+		panic("should not happen")
+	}
+	if perByte != 0 && Gas(size) > math.MaxInt64/perByte {
+		gm.ConsumeGas(math.MaxInt64, descriptor) // unless unlimited.
+		return
+	}
+	gm.ConsumeGas(perByte*Gas(size), descriptor)
+}
+
+type OutOfGasError struct {
+	Descriptor string
+	Limit      Gas
+	Consumed   Gas
+	Amount     Gas
+}
+
+func (oog OutOfGasError) Error() string {
+	return fmt.Sprintf("out of gas in %s: %d + %d exceeds limit %d",
+		oog.Descriptor, oog.Consumed, oog.Amount, oog.Limit)
+}
+
+//----------------------------------------
+// Machine gas charges
+//
+// All are no-ops if the machine has no gas meter.
+
+func (m *Machine) incrOpGas(op Op) {
+	if m.GasMeter != nil {
+		m.GasMeter.ConsumeGas(m.GasMeter.Table.Ops[op], op.String())
+	}
+}
+
+func (m *Machine) incrAllocGas(size int64) {
+	if m.GasMeter != nil {
+		m.GasMeter.consumeBytes(m.GasMeter.Table.AllocByte, size, "alloc")
+	}
+}
+
+func (m *Machine) incrCopyGas(size int64) {
+	if m.GasMeter != nil {
+		m.GasMeter.consumeBytes(m.GasMeter.Table.CopyByte, size, "copy")
+	}
+}
+
+// Returns the size of a list of n elements of type et, for
+// allocation and copying charges.
+func listSize(et Type, n int) int64 {
+	if et != nil && et.Kind() == Uint8Kind {
+		return int64(n) // data bytes.
+	}
+	if int64(n) > math.MaxInt64/sizeTypedValue {
+		return math.MaxInt64 // exceeds any limit.
+	}
+	return int64(n) * sizeTypedValue
+}
@@ -0,0 +1,128 @@
+package gno
+
+import (
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+func TestGasOutOfGas(t *testing.T) {
+	gm := NewGasMeter(1000, nil)
+	_, r := runMain(MachineOptions{GasMeter: gm}, `package main
+func main() {
+	for x := 1; x > 0; x++ {
+	}
+}`)
+	oog, ok := r.(OutOfGasError)
+	assert.True(t, ok)
+	assert.Equal(t, oog.Limit, Gas(1000))
+	assert.Equal(t, gm.Consumed, Gas(1000))
+	assert.Contains(t, oog.Error(), "out of gas")
+}
+
+func TestGasTable(t *testing.T) {
+	code := `package main
+func main() {
+	x := 0
+	for i := 0; i < 10; i++ {
+		x += i
+	}
+	println(x)
+}`
+	gt1 := &GasTable{}
+	gt2 := &GasTable{}
+	for i := range gt1.Ops {
+		gt1.Ops[i] = 1
+		gt2.Ops[i] = 2
+	}
+	gm1 := NewGasMeter(1000000, gt1)
+	_, r := runMain(MachineOptions{GasMeter: gm1}, code)
+	assert.Nil(t, r)
+	gm2 := NewGasMeter(1000000, gt2)
+	_, r = runMain(MachineOptions{GasMeter: gm2}, code)
+	assert.Nil(t, r)
+	assert.True(t, gm1.Consumed > 0)
+	assert.Equal(t, gm2.Consumed, 2*gm1.Consumed)
+	// gas is deterministic.
+	gm3 := NewGasMeter(1000000, gt1)
+	runMain(MachineOptions{GasMeter: gm3}, code)
+	assert.Equal(t, gm3.Consumed, gm1.Consumed)
+}
+
+func TestGasAllocation(t *testing.T) {
+	// charged before allocating.
+	gm := NewGasMeter(1000000, nil)
+	_, r := runMain(MachineOptions{GasMeter: gm}, `package main
+func main() {
+	n := 1099511627776
+	x := make([]int, n)
+	println(len(x))
+}`)
+	oog, ok := r.(OutOfGasError)
+	assert.True(t, ok)
+	assert.Equal(t, oog.Descriptor, "alloc")
+	assert.True(t, gm.Consumed < gm.Limit)
+
+	// strings are charged by size.
+	gt := &GasTable{AllocByte: 1}
+	_, r = runMain(MachineOptions{GasMeter: NewGasMeter(100, gt)}, `package main
+func main() {
+	s := "0123456789"
+	for i := 0; i < 4; i++ {
+		s += s
+	}
+}`)
+	_, ok = r.(OutOfGasError)
+	assert.True(t, ok)
+}
+
+func TestGasRealmRollback(t *testing.T) {
+	store := NewMemStore()
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+var count int
+var names []string
+func main() {
+	count = 1
+}
+func spin() {
+	count = 2
+	for count > 0 {
+	}
+}
+func grow() {
+	count = 3
+	names = []string{"a", "b", "c"}
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+	hash := rlm.GetHash()
+	idx := pn.GetPathForName("count").Index
+
+	// out of gas while running.
+	m.GasMeter = NewGasMeter(1000, nil)
+	_, ok := catchPanic(func() {
+		m.RunStatement(S(Call(X("spin"))))
+	}).(OutOfGasError)
+	assert.True(t, ok)
+	assert.Equal(t, pv.Block.Values[idx].GetInt(), 1)
+	assert.Equal(t, rlm.GetHash(), hash)
+
+	// out of gas while hashing upon finalization.
+	gt := &GasTable{HashByte: 1}
+	m.GasMeter = NewGasMeter(10, gt)
+	_, ok = catchPanic(func() {
+		m.RunStatement(S(Call(X("grow"))))
+	}).(OutOfGasError)
+	assert.True(t, ok)
+	assert.Equal(t, pv.Block.Values[idx].GetInt(), 1)
+	assert.Equal(t, rlm.GetHash(), hash)
+
+	// with enough gas, hashing is charged.
+	m.GasMeter = NewGasMeter(1000000, gt)
+	m.RunStatement(S(Call(X("grow"))))
+	assert.Equal(t, pv.Block.Values[idx].GetInt(), 3)
+	assert.True(t, m.GasMeter.Consumed > 10)
+	assert.NotEqual(t, rlm.GetHash(), hash)
+}
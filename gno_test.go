@@ -3,6 +3,7 @@ package gno
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"reflect"
 	"testing"
 	"unsafe"
@@ -63,6 +64,31 @@ func assertOutput(t *testing.T, input string, output string) {
 	assert.Nil(t, err)
 }
 
+// Runs main() of the file code in a new machine with opts,
+// whose output is discarded unless set.  Returns the machine,
+// e.g. to run further statements, and what was panicked, if
+// anything.
+func runMain(opts MachineOptions, code string) (m *Machine, r interface{}) {
+	if opts.Output == nil {
+		opts.Output = ioutil.Discard
+	}
+	m = NewMachineWithOptions(opts)
+	r = catchPanic(func() {
+		m.RunFiles(MustParseFile("main.go", code))
+		m.RunStatement(S(Call(X("main"))))
+	})
+	return m, r
+}
+
+// Returns what f panicked, if anything.
+func catchPanic(f func()) (r interface{}) {
+	defer func() {
+		r = recover()
+	}()
+	f()
+	return nil
+}
+
 func TestRunMakeStruct(t *testing.T) {
 	assertOutput(t, `package test
 type Outfit struct {
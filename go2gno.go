@@ -163,6 +163,32 @@ func Go2Gno(gon ast.Node) (n Node) {
 			Body: toStmts(gon.Body.List),
 			Else: ess,
 		}
+	case *ast.SwitchStmt:
+		return &SwitchStmt{
+			Init:  toSimp(gon.Init),
+			X:     toExpr(gon.Tag),
+			Cases: toClauses(gon.Body.List),
+		}
+	case *ast.TypeSwitchStmt:
+		// one of `x.(type)` or `v := x.(type)`.
+		var ax ast.Expr
+		var varName Name
+		switch as := gon.Assign.(type) {
+		case *ast.ExprStmt:
+			ax = as.X
+		case *ast.AssignStmt:
+			ax = as.Rhs[0]
+			varName = toName(as.Lhs[0].(*ast.Ident))
+		default:
+			panic("should not happen")
+		}
+		return &SwitchStmt{
+			Init:         toSimp(gon.Init),
+			X:            toExpr(ax.(*ast.TypeAssertExpr).X),
+			IsTypeSwitch: true,
+			Cases:        toClauses(gon.Body.List),
+			VarName:      varName,
+		}
 	case *ast.UnaryExpr:
 		if gon.Op == token.AND {
 			return &RefExpr{
@@ -208,6 +234,11 @@ func Go2Gno(gon ast.Node) (n Node) {
 		return &ExprStmt{
 			X: toExpr(gon.X),
 		}
+	case *ast.DeferStmt:
+		cx := toExpr(gon.Call).(*CallExpr)
+		return &DeferStmt{
+			Call: *cx,
+		}
 	case *ast.CallExpr:
 		return &CallExpr{
 			Func: toExpr(gon.Fun),
@@ -264,6 +295,14 @@ func Go2Gno(gon ast.Node) (n Node) {
 			Op:    toWord(gon.Tok),
 			Label: toName(gon.Label),
 		}
+	case *ast.LabeledStmt:
+		// the label is an attribute of the statement,
+		// as read by frames for break and continue.
+		s := toStmt(gon.Stmt)
+		s.SetAttribute(ATTR_LABEL, toName(gon.Label))
+		return s
+	case *ast.EmptyStmt:
+		return &EmptyStmt{}
 	case *ast.DeclStmt:
 		return &DeclStmt{
 			Decls: toSimpleDecls(gon.Decl.(*ast.GenDecl)),
@@ -428,6 +467,21 @@ func toBody(body *ast.BlockStmt) Stmts {
 	return toStmts(body.List)
 }
 
+func toClauses(goss []ast.Stmt) (gnocs []SwitchCaseStmt) {
+	gnocs = make([]SwitchCaseStmt, len(goss))
+	for i, x := range goss {
+		cc := x.(*ast.CaseClause)
+		gnocs[i] = SwitchCaseStmt{
+			Body: toStmts(cc.Body),
+		}
+		// nil means default case.
+		if cc.List != nil {
+			gnocs[i].Cases = toExprs(cc.List)
+		}
+	}
+	return
+}
+
 func toSimp(gos ast.Stmt) Stmt {
 	gnos := Go2Gno(gos)
 	if gnos == nil {
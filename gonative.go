@@ -423,6 +423,17 @@ func go2GnoValue2(rv reflect.Value) (tv TypedValue) {
 		tv.SetUint64(uint64(rv.Uint()))
 	case reflect.Array:
 		rvl := rv.Len()
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			// byte arrays are backed by data, see defaultValue().
+			data := make([]byte, rvl)
+			for i := 0; i < rvl; i++ {
+				data[i] = uint8(rv.Index(i).Uint())
+			}
+			tv.V = &ArrayValue{
+				Data: data,
+			}
+			break
+		}
 		list := make([]TypedValue, rvl)
 		for i := 0; i < rvl; i++ {
 			list[i] = go2GnoValue(rv.Index(i))
@@ -707,14 +718,7 @@ func gno2GoValue(tv *TypedValue, rv reflect.Value) reflect.Value {
 				gno2GoValue(etv, rv.Index(i))
 			}
 		} else {
-			panic("not yet implemented")
-			/*
-				el := av.GetLength()
-				ec := av.GetCapacity()
-				data := make([]byte, el, ec)
-				copy(data, av.Data)
-				rv = reflect.ValueOf(data)
-			*/
+			reflect.Copy(rv, reflect.ValueOf(av.Data))
 		}
 	case *SliceType:
 		st := gno2GoType(ct)
@@ -895,9 +899,16 @@ func (m *Machine) doOpCallGoNative() {
 	ptvs := m.PopValues(fr.NumArgs)
 	prvs := make([]reflect.Value, len(ptvs))
 	for i := 0; i < fr.NumArgs; i++ {
+		ptv := &ptvs[i]
+		if ptv.IsUndefined() {
+			// e.g. nil interface, as zero of param type.
+			pt := goParamType(fv.Value.Type(), i, fr.IsVarg)
+			prvs[i] = reflect.New(pt).Elem()
+			continue
+		}
 		// TODO consider when declared types can be
 		// converted, e.g. fmt.Println. See GoValue.
-		prvs[i] = gno2GoValue(&ptvs[i], reflect.Value{})
+		prvs[i] = gno2GoValue(ptv, reflect.Value{})
 	}
 	// call and get results.
 	rrvs := fv.Value.Call(prvs)
@@ -917,6 +928,16 @@ func (m *Machine) doOpCallGoNative() {
 	m.PopFrame()
 }
 
+// Returns the type of the i'th argument for a call of Go
+// function type ft, whose variadic args are passed as a slice
+// if isVarg.
+func goParamType(ft reflect.Type, i int, isVarg bool) reflect.Type {
+	if ft.IsVariadic() && ft.NumIn()-1 <= i && !isVarg {
+		return ft.In(ft.NumIn() - 1).Elem()
+	}
+	return ft.In(i)
+}
+
 //----------------------------------------
 // GoValue
 //
@@ -41,7 +41,7 @@ func innerHash(h1, h2 Hashlet) (res Hashlet) {
 // The ValueHash of a typed value is a unique deterministic
 // accountable fingerprint of that typed value, and can be used
 // to prove the value or any part of its value which is
-// accessible from the Gno language.
+// accessible from the Gno language (see ProveValueAt()).
 //
 // For example, the ValueHash of a primitive value is simply
 // the "leaf hash" of its TypedValuePreimage. The ValueHash of
@@ -57,10 +57,13 @@ func innerHash(h1, h2 Hashlet) (res Hashlet) {
 //
 // `ValuePreimage := 0x00` if typed-nil.
 // `ValuePreimage := 0x01,sz(pb(.))` if primitive.
-// `ValuePreimage := 0x02,sz(vh(*ptr))` if ptr.
+// `ValuePreimage := 0x02,sz(oid,[idx],[oh(.)])` if ptr to object.
+// `ValuePreimage := 0x02,sz(vh(*ptr))` if other ptr.
 // `ValuePreimage := 0x03,sz(data)` if byte-array.
 // `ValuePreimage := 0x04,sz(ElemsHash)` if non-nil object.
 // `ValuePreimage := 0x05,sz(vp(base)),off,len,max if slice.
+// `ValuePreimage := 0x05,sz(oid,[oh(.)]),off,len,max if real base.
+// `ValuePreimage := 0x06,sz(oid,tid)` if weakref.
 //
 // `ElemsHash := lh(TypedElemPreimage)` if object w/ 1 elem.
 // `ElemsHash := ih(eh(Left),eh(Right))` if object w/ 2+ elems.
@@ -71,12 +74,18 @@ func innerHash(h1, h2 Hashlet) (res Hashlet) {
 // `ElemPreimage := ...` (of each array/struct/block element)
 // `ElemPreimage := 0x10` if typed-nil.
 // `ElemPreimage := 0x11,sz(ObjectID)` if borrowed.
-// `ElemPreimage := 0x12,sz(ObjectID),sz(vh(.))` if owned.
-// `ElemPreimage := 0x13,sz(nil),sz(vh(.))` prim/ptr/slice.
+// `ElemPreimage := 0x12,sz(ObjectID),sz(oh(.))` if owned.
+// `ElemPreimage := 0x13,sz(nil),sz(vh(.))` prim/ptr/slice/weakref.
 //  * ownership passed through for pointers/slices/arrays.
 //
 // * sz() means (uvarint) size-prefixed bytes.
 // * vh() means .ValueHash().
+// * oh() means object hash := lh(vp(.)), cached in .Hash.
+// * oid() means ObjectID bytes; zero if not yet real.
+// * tid means the TypeID of the pointer type of a weakref.
+// * idx is present if ptr is into (rather than to) an object.
+// * oh(.) of the object is present if owned or refcount=1,
+//   unless ptr is into the object.
 // * eh() are inner ElemsHashs.
 // * lh() means leafHash(x) := hash(0x00,x)
 // * ih() means innerHash(x,y) := hash(0x01,x,y)
@@ -96,12 +105,12 @@ func innerHash(h1, h2 Hashlet) (res Hashlet) {
 // from its base object, but implementations may choose to
 // inline the serialization of "run-time" owned objects anyway.
 //
-// If an object is owned, the value hash of elements is
-// included, otherwise, the value hash of elements is not
-// included except for objects with refcount=1.  If owned but
-// any of the elements are already owned, or if not owned but
-// any of the elements have refcount>1, preimage derivation
-// panics.
+// If an object is owned, the object hash of elements is
+// included, otherwise, the object hash of elements is not
+// included except for objects with refcount=1.  Object hashes
+// of real objects are cached in ObjectInfo.Hash and recomputed
+// bottom-up upon realm finalization, so only the dirty path up
+// to the package block is ever rehashed.
 
 type ValueHash Hashlet
 
@@ -114,8 +123,8 @@ type TypedValuePreimage struct {
 }
 
 type ValuePreimage struct {
-	ValType        // 0:nil,1:prim,2:ptr,3:data,4:obj,5:slice
-	Data    []byte // if ValType=prim,ptr,data,obj,slice
+	ValType        // 0:nil,1:prim,2:ptr,3:data,4:obj,5:slice,6:weakref
+	Data    []byte // if ValType=prim,ptr,data,obj,slice,weakref
 	Offset  int    // if ValType=slice
 	Length  int    // if ValType=slice
 	Maxcap  int    // if ValType=slice
@@ -132,6 +141,7 @@ const (
 	ValTypeData      = ValType(0x03)
 	ValTypeObject    = ValType(0x04)
 	ValTypeSlice     = ValType(0x05)
+	ValTypeWeakRef   = ValType(0x06)
 )
 
 func (tvp *TypedValuePreimage) Bytes() []byte {
@@ -162,6 +172,8 @@ func (vp *ValuePreimage) Bytes() []byte {
 	case ValTypeData:
 		fallthrough
 	case ValTypeObject:
+		fallthrough
+	case ValTypeWeakRef:
 		buf = append(buf, sizedBytes(vp.Data)...)
 		return buf
 	case ValTypeSlice:
@@ -259,8 +271,8 @@ func (tep *TypedElemPreimage) LeafHash() Hashlet {
 }
 
 func ElemsHashFromElements(tepz []TypedElemPreimage) Hashlet {
-	// special case if nil
-	if tepz == nil {
+	// special case if nil or empty (e.g. empty map)
+	if len(tepz) == 0 {
 		return Hashlet{}
 	}
 	// translate to leaf hashes
@@ -338,6 +350,22 @@ func (sv *SliceValue) ValuePreimage(
 	if sv.Base == nil {
 		return ValuePreimage{} // nil slice
 	}
+	if sv.Base.GetIsReal() {
+		// `ValuePreimage := 0x05,sz(oid,[oh(.)]),off,len,max
+		//   if real base.
+		data := sv.Base.GetObjectID().Bytes()
+		if owned || sv.Base.GetRefCount() == 1 {
+			oh := objectHash(rlm, sv.Base)
+			data = append(data, oh[:]...)
+		}
+		return ValuePreimage{
+			ValType: ValTypeSlice,
+			Data:    data,
+			Offset:  sv.Offset,
+			Length:  sv.Length,
+			Maxcap:  sv.Maxcap,
+		}
+	}
 	// If (self, base) is:
 	//  - (owned, already-owned):
 	//    * panic (ownership conflict)
@@ -408,7 +436,8 @@ func (sv *StructValue) TypedElemPreimages(
 	tvpz := make([]TypedElemPreimage, nf)
 	for i := 0; i < nf; i++ {
 		fv := &sv.Fields[i] // ref
-		tvpz[i] = fv.TypedElemPreimage(rlm, owned)
+		tvpz[i] = fv.TypedElemPreimage(rlm,
+			owned || sv.IsOwnedField(i))
 	}
 	return tvpz
 }
@@ -486,6 +515,8 @@ func (b *Block) TypedElemPreimages(
 			// do nothing
 		case *FuncType:
 			// do nothing
+		case *TypeType:
+			// do nothing
 		default:
 			tvpz[i] = tv.TypedElemPreimage(rlm, owned)
 		}
@@ -493,6 +524,18 @@ func (b *Block) TypedElemPreimages(
 	return tvpz
 }
 
+// Returns the object hash of oo, which is cached for real
+// objects that are not dirty.
+func objectHash(rlm *Realm, oo Object) ValueHash {
+	oi := oo.GetObjectInfo()
+	if oi.GetIsReal() && !oi.GetIsDirty() && oi.Hash != (ValueHash{}) {
+		return oi.Hash
+	}
+	// `oh(.) := lh(vp(.))`
+	vp := oo.ValuePreimage(rlm, false)
+	return ValueHash(leafHash(vp.Bytes()))
+}
+
 //----------------------------------------
 // *TypedValue.ValueHash
 
@@ -517,8 +560,8 @@ func (tv *TypedValue) TypedValuePreimage(rlm *Realm, owned bool) TypedValuePreim
 		panic("undefined value has no TypedValuePreimage")
 	}
 	tid := tv.T.TypeID()
-	if tv.V == nil { // primitive or nil
-		if _, ok := baseOf(tv.T).(PrimitiveType); ok {
+	if _, isPrim := baseOf(tv.T).(PrimitiveType); isPrim || tv.V == nil {
+		if isPrim {
 			// `ValuePreimage := 0x01,sz(pb(.))` if primitive.
 			pbz := tv.PrimitiveBytes()
 			return TypedValuePreimage{
@@ -551,9 +594,29 @@ func (tv *TypedValue) TypedValuePreimage(rlm *Realm, owned bool) TypedValuePreim
 						Data:    nil,
 					},
 				}
+			} else if oo := tv.GetFirstObject(); oo != nil && oo.GetIsReal() {
+				// `ValuePreimage := 0x02,sz(oid,[idx],[oh(.)])`
+				//   if ptr to or into object.
+				data := oo.GetObjectID().Bytes()
+				if pv.Base != nil {
+					data = append(data, uvarintBytes(uint64(pv.Index))...)
+				}
+				// a pointer into an object does not own it,
+				// and may be an element of the object itself.
+				if pv.Base == nil && (owned || oo.GetRefCount() == 1) {
+					oh := objectHash(rlm, oo)
+					data = append(data, oh[:]...)
+				}
+				return TypedValuePreimage{
+					TypeID: tid,
+					ValuePreimage: ValuePreimage{
+						ValType: ValTypePointer,
+						Data:    data,
+					},
+				}
 			} else {
-				// `ValuePreimage := 0x02,sz(vh(*ptr))` if ptr.
-				vh := tv.ValueHash(rlm, owned)
+				// `ValuePreimage := 0x02,sz(vh(*ptr))` if other ptr.
+				vh := pv.TypedValue.ValueHash(rlm, owned)
 				return TypedValuePreimage{
 					TypeID: tid,
 					ValuePreimage: ValuePreimage{
@@ -588,12 +651,25 @@ func (tv *TypedValue) TypedValuePreimage(rlm *Realm, owned bool) TypedValuePreim
 				ValuePreimage: sv.ValuePreimage(rlm, owned),
 			}
 		case *MapType:
-			mv := tv.V.(*StructValue)
+			mv := tv.V.(*MapValue)
 			// `ValuePreimage := 0x04,sz(ElemsHash)` if object.
 			return TypedValuePreimage{
 				TypeID:        tid,
 				ValuePreimage: mv.ValuePreimage(rlm, owned),
 			}
+		case *WeakRefType:
+			wv := tv.V.(WeakRefValue)
+			// `ValuePreimage := 0x06,sz(oid,tid)` if weakref.
+			// The target is never owned.
+			data := wv.GetTargetID().Bytes()
+			data = append(data, wv.T.TypeID().Bytes()...)
+			return TypedValuePreimage{
+				TypeID: tid,
+				ValuePreimage: ValuePreimage{
+					ValType: ValTypeWeakRef,
+					Data:    data,
+				},
+			}
 		default:
 			panic(fmt.Sprintf(
 				"unexpected type for TypedValuePreimage(): %s",
@@ -607,13 +683,18 @@ func (tv *TypedValue) TypedElemPreimage(rlm *Realm, owned bool) TypedElemPreimag
 		// `TypedElemPreimage := nil` if nil interface.
 		return TypedElemPreimage{} // nil
 	} else if tv.T.Kind() == InterfaceKind {
+		// zero interface variables are declared with
+		// their interface type but no value.
+		if tv.V == nil {
+			return TypedElemPreimage{} // nil
+		}
 		if debug {
 			panic("should not happen")
 		}
 	}
 	tid := tv.T.TypeID()
-	if tv.V == nil {
-		if _, ok := baseOf(tv.T).(PrimitiveType); ok {
+	if _, isPrim := baseOf(tv.T).(PrimitiveType); isPrim || tv.V == nil {
+		if isPrim {
 			// `ElemPreimage := 0x13,sz(nil),sz(vh(.))` prim/ptr/slice.
 			// `ValueHash := lh(TypedValuePreimage)` ...
 			tvp := tv.TypedValuePreimage(rlm, owned)
@@ -632,9 +713,9 @@ func (tv *TypedValue) TypedElemPreimage(rlm *Realm, owned bool) TypedElemPreimag
 		}
 	} else {
 		switch baseOf(tv.T).(type) {
-		case PointerType, *SliceType:
+		case PointerType, *SliceType, *WeakRefType:
 			// `ElemPreimage := 0x13,sz(nil),sz(vh(.))`
-			// 	 if prim/ptr/slice.
+			// 	 if prim/ptr/slice/weakref.
 			// `ValueHash := lh(TypedValuePreimage)`
 			tvp := tv.TypedValuePreimage(rlm, owned)
 			vh := tvp.ValueHash()
@@ -644,6 +725,23 @@ func (tv *TypedValue) TypedElemPreimage(rlm *Realm, owned bool) TypedElemPreimag
 				ValueHash: vh,
 			}
 		case *ArrayType, *StructType, *MapType:
+			if rv, ok := tv.V.(RefValue); ok {
+				// unloaded objects preimage as persisted.
+				if rv.Hash == (ValueHash{}) {
+					return TypedElemPreimage{
+						TypeID:   tid,
+						ElemType: ElemTypeBorrowed,
+						ObjectID: rv.ObjectID,
+					}
+				} else {
+					return TypedElemPreimage{
+						TypeID:    tid,
+						ElemType:  ElemTypeOwned,
+						ObjectID:  rv.ObjectID,
+						ValueHash: rv.Hash,
+					}
+				}
+			}
 			var obj Object = tv.V.(Object)
 			if !owned {
 				rc := obj.GetRefCount()
@@ -658,9 +756,8 @@ func (tv *TypedValue) TypedElemPreimage(rlm *Realm, owned bool) TypedElemPreimag
 			}
 			oid := obj.GetObjectID()
 			if owned {
-				// `ElemPreimage := 0x12,sz(ObjectID),sz(vh(.))` if owned.
-				tvp := tv.TypedValuePreimage(rlm, owned)
-				vh := tvp.ValueHash()
+				// `ElemPreimage := 0x12,sz(ObjectID),sz(oh(.))` if owned.
+				vh := objectHash(rlm, obj)
 				return TypedElemPreimage{
 					TypeID:    tid,
 					ElemType:  ElemTypeOwned,
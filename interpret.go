@@ -16,24 +16,32 @@ type Importer func(pkgPath string) *PackageValue
 type Machine struct {
 
 	// State
-	Ops       []Op // main operations
-	NumOps    int
-	Values    []TypedValue  // buffer of values to be operated on
-	NumValues int           // number of values
-	Exprs     []Expr        // pending expressions
-	Stmts     []Stmt        // pending statements
-	Blocks    []*Block      // block (scope) stack
-	Frames    []Frame       // func call stack
-	Package   *PackageValue // active package
-	Realm     *Realm        // active realm
+	Ops        []Op // main operations
+	NumOps     int
+	Values     []TypedValue  // buffer of values to be operated on
+	NumValues  int           // number of values
+	Exprs      []Expr        // pending expressions
+	Stmts      []Stmt        // pending statements
+	Blocks     []*Block      // block (scope) stack
+	Frames     []Frame       // func call stack
+	Exceptions []Exception   // panics being handled, last is current
+	Package    *PackageValue // active package
+	Realm      *Realm        // active realm
 
 	// Volatile State
 	NumResults int // number of results returned
 
+	// Realms finalized during the outermost Run* method, which
+	// are only committed once it returns, see commitOrRollback().
+	runs      int // nesting of Run* methods.
+	finalized []*Realm
+
 	// Configuration
 	CheckTypes bool
 	Output     io.Writer
 	Importer   Importer
+	GasMeter   *GasMeter  // or nil if unmetered.
+	Alloc      *Allocator // or nil if unlimited.
 }
 
 // Machine with new package of given path.
@@ -57,6 +65,8 @@ type MachineOptions struct {
 	CheckTypes bool
 	Output     io.Writer
 	Importer   Importer
+	GasMeter   *GasMeter  // or nil if unmetered.
+	Alloc      *Allocator // or nil if unlimited.
 }
 
 func NewMachineWithOptions(opts MachineOptions) *Machine {
@@ -71,10 +81,8 @@ func NewMachineWithOptions(opts MachineOptions) *Machine {
 	if output == nil {
 		output = os.Stdout
 	}
-	importer := opts.Importer
-	if importer == nil {
-		// bare machine, no stdlibs.
-	}
+	// a bare machine imports std only.
+	importer := withStd(opts.Importer)
 	blocks := []*Block{
 		&pkg.Block,
 	}
@@ -89,6 +97,8 @@ func NewMachineWithOptions(opts MachineOptions) *Machine {
 		CheckTypes: checkTypes,
 		Output:     output,
 		Importer:   importer,
+		GasMeter:   opts.GasMeter,
+		Alloc:      opts.Alloc,
 	}
 }
 
@@ -97,6 +107,7 @@ func NewMachineWithOptions(opts MachineOptions) *Machine {
 
 // Add files to the package's *FileSet and run them.
 func (m *Machine) RunFiles(fns ...*FileNode) {
+	defer m.commitOrRollback(m.checkpoint())
 	// Files' package names must match the machine's active one.
 	// if there is one.
 	for _, fn := range fns {
@@ -141,6 +152,25 @@ func (m *Machine) RunFiles(fns ...*FileNode) {
 	}
 }
 
+// Runs the declarations of the package's files, which must
+// already be preprocessed and have file blocks, e.g. for a
+// package loaded from a store.
+func (m *Machine) runFileDeclarations() {
+	defer m.commitOrRollback(m.checkpoint())
+	pv := m.Package
+	pn := pv.Source.(*PackageNode)
+	if pn.FileSet == nil {
+		return
+	}
+	for _, fn := range pn.FileSet.Files {
+		m.PushBlock(pv.FBlocks[fn.Name])
+		for _, d := range fn.Body {
+			m.runDeclaration(d)
+		}
+		m.PopBlock()
+	}
+}
+
 func (m *Machine) RunMain() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -152,6 +182,107 @@ func (m *Machine) RunMain() {
 	m.RunStatement(S(Call(X("main"))))
 }
 
+// Machine state to be restored upon panic.
+type checkpoint struct {
+	numOps    int
+	numValues int
+	numExprs  int
+	numStmts  int
+	numBlocks int
+	numFrames int
+	numExcs   int
+	pkg       *PackageValue
+	rlm       *Realm
+}
+
+// Called upon entering a top level Run* method.
+func (m *Machine) checkpoint() checkpoint {
+	m.runs++
+	return checkpoint{
+		numOps:    m.NumOps,
+		numValues: m.NumValues,
+		numExprs:  len(m.Exprs),
+		numStmts:  len(m.Stmts),
+		numBlocks: len(m.Blocks),
+		numFrames: len(m.Frames),
+		numExcs:   len(m.Exceptions),
+		pkg:       m.Package,
+		rlm:       m.Realm,
+	}
+}
+
+// Deferred by the top level Run* methods.  Once the outermost
+// returns, the transactions of all realms finalized meanwhile
+// are committed, e.g. those of realms called by the realm of
+// the machine.  Upon panic (e.g. a panicking realm function,
+// or an invalid ownership tree) all realms entered since the
+// last finalization are rolled back, and so are those finalized
+// since the outermost Run* method was entered, such that
+// nothing is persisted.  The machine is then reset to cp before
+// re-panicking.
+func (m *Machine) commitOrRollback(cp checkpoint) {
+	m.runs--
+	r := recover()
+	if r == nil {
+		if m.runs == 0 {
+			m.commitRealms()
+		}
+		return
+	}
+	rlms := []*Realm{m.Realm}
+	for _, fr := range m.Frames {
+		rlms = append(rlms, fr.LastRealm)
+	}
+	for _, rlm := range rlms {
+		if rlm != nil {
+			rlm.Rollback() // no-op if already rolled back.
+		}
+	}
+	if m.runs == 0 {
+		m.discardRealms()
+	}
+	m.NumOps = cp.numOps
+	m.NumValues = cp.numValues
+	m.Exprs = m.Exprs[:cp.numExprs]
+	m.Stmts = m.Stmts[:cp.numStmts]
+	m.Blocks = m.Blocks[:cp.numBlocks]
+	m.Frames = m.Frames[:cp.numFrames]
+	m.Exceptions = m.Exceptions[:cp.numExcs]
+	m.Package = cp.pkg
+	m.Realm = cp.rlm
+	panic(r)
+}
+
+// Finalizes the transaction of rlm, to be committed along with
+// the other realms finalized during the outermost Run* method.
+func (m *Machine) finalizeRealm(rlm *Realm) {
+	if err := rlm.finalizeTransaction(m.GasMeter); err != nil {
+		panic(err)
+	}
+	for _, frlm := range m.finalized {
+		if frlm == rlm {
+			return
+		}
+	}
+	m.finalized = append(m.finalized, rlm)
+}
+
+func (m *Machine) commitRealms() {
+	for _, rlm := range m.finalized {
+		rlm.Commit()
+	}
+	m.finalized = nil
+}
+
+// Realms are discarded in the reverse order of their first
+// finalization.
+func (m *Machine) discardRealms() {
+	for i := len(m.finalized) - 1; 0 <= i; i-- {
+		m.finalized[i].Discard()
+	}
+	m.finalized = nil
+}
+
 // Evaluate throwaway expression in new block scope.
 // If x is a function call, it must return 1 result.
 // This function is mainly for debugging and testing,
@@ -159,6 +290,7 @@ func (m *Machine) RunMain() {
 // Input must not have been preprocessed, that is,
 // it should not be the child of any parent.
 func (m *Machine) Eval(x Expr) TypedValue {
+	defer m.commitOrRollback(m.checkpoint())
 	if debug {
 		m.Printf("Machine.Eval(%v)\n", x)
 	}
@@ -258,6 +390,7 @@ func (m *Machine) StaticEvalTypeOf(last BlockNode, x Expr) Type {
 }
 
 func (m *Machine) RunStatement(s Stmt) {
+	defer m.commitOrRollback(m.checkpoint())
 	// Preprocess input using package block.  There should only
 	// be one block right now, and it's a *PackageNode.
 	pn := m.LastBlock().Source.(*PackageNode)
@@ -271,6 +404,7 @@ func (m *Machine) RunStatement(s Stmt) {
 // Runs a declaration after preprocessing d.  If d was already
 // preprocessed, call runDeclaration() instead.
 func (m *Machine) RunDeclaration(d Decl) {
+	defer m.commitOrRollback(m.checkpoint())
 	// Preprocess input using package block.  There should only
 	// be one block right now, and it's a *PackageNode.
 	pn := m.LastBlock().Source.(*PackageNode)
@@ -344,10 +478,11 @@ func (m *Machine) runDeclaration(d Decl) {
 				}
 			}
 		} else { // initialize zero .Value.
+			m.allocateDefault(t)
 			tv.T = t
 			tv.V = defaultValue(t)
 		}
-		last.GetValueRefAtForAssign2(m.Realm, d.Path).Assign(tv)
+		last.GetPointerTo(d.Path).Assign2(m.Realm, tv)
 	case *TypeDecl:
 		var t Type
 		if false {
@@ -399,26 +534,27 @@ type Op uint8
 const (
 
 	/* Control operators */
-	OpInvalid         Op = 0x00 // invalid
-	OpHalt            Op = 0x01 // halt (e.g. last statement)
-	OpNoop            Op = 0x02 // no-op
-	OpExec            Op = 0x03 // exec next statement
-	OpPrecall         Op = 0x04 // sets X (func) to frame
-	OpCall            Op = 0x05 // call(Frame.Func, [...])
-	OpCallNativeBody  Op = 0x06 // call body is native
-	OpReturn          Op = 0x07 // return ...
-	OpReturnFromBlock Op = 0x08 // return results (after defers)
-	OpReturnToBlock   Op = 0x09 // copy results to block (before defer)
-	OpDefer           Op = 0x0A // defer call(X, [...])
-	OpGo              Op = 0x0B // go call(X, [...])
-	OpSelectCase      Op = 0x0C // exec next select case
-	OpSwitchCase      Op = 0x0D // exec next switch case
-	OpTypeSwitchCase  Op = 0x0E // exec next type switch case
-	OpForLoop1        Op = 0x0F // body and post if X, else break
-	OpIfCond          Op = 0x10 // body if X, else else
-	OpPopValue        Op = 0x11 // pop X
-	OpPopResults      Op = 0x12 // pop n call results
-	OpPopBlock        Op = 0x13 // pop block NOTE breaks certain invariants.
+	OpInvalid          Op = 0x00 // invalid
+	OpHalt             Op = 0x01 // halt (e.g. last statement)
+	OpNoop             Op = 0x02 // no-op
+	OpExec             Op = 0x03 // exec next statement
+	OpPrecall          Op = 0x04 // sets X (func) to frame
+	OpCall             Op = 0x05 // call(Frame.Func, [...])
+	OpCallNativeBody   Op = 0x06 // call body is native
+	OpReturn           Op = 0x07 // return ...
+	OpReturnFromBlock  Op = 0x08 // return results (after defers)
+	OpReturnToBlock    Op = 0x09 // copy results to block (before defer)
+	OpDefer            Op = 0x0A // defer call(X, [...])
+	OpGo               Op = 0x0B // go call(X, [...])
+	OpSelectCase       Op = 0x0C // exec next select case
+	OpSwitchCase       Op = 0x0D // exec next switch case
+	OpTypeSwitchCase   Op = 0x0E // exec next type switch case
+	OpForLoop1         Op = 0x0F // body and post if X, else break
+	OpIfCond           Op = 0x10 // body if X, else else
+	OpPopValue         Op = 0x11 // pop X
+	OpPopResults       Op = 0x12 // pop n call results
+	OpPopBlock         Op = 0x13 // pop block NOTE breaks certain invariants.
+	OpPopFrameAndReset Op = 0x14 // pop frame and reset.
 
 	/* Unary & binary operators */
 	OpUpos  Op = 0x20 // + (unary)
@@ -514,6 +650,7 @@ const (
 func (m *Machine) Run() {
 	for {
 		op := m.PopOp()
+		m.incrOpGas(op)
 		// TODO: this can be optimized manually, even into tiers.
 		switch op {
 		/* Control operators */
@@ -536,15 +673,15 @@ func (m *Machine) Run() {
 		case OpReturnToBlock:
 			m.doOpReturnToBlock()
 		case OpDefer:
-			panic("not yet implemented")
+			m.doOpDefer()
 		case OpGo:
 			panic("not yet implemented")
 		case OpSelectCase:
 			panic("not yet implemented")
 		case OpSwitchCase:
-			panic("not yet implemented")
+			m.doOpSwitchCase()
 		case OpTypeSwitchCase:
-			panic("not yet implemented")
+			m.doOpTypeSwitchCase()
 		case OpForLoop1:
 			m.doOpForLoop1()
 		case OpIfCond:
@@ -555,6 +692,8 @@ func (m *Machine) Run() {
 			m.PopResults()
 		case OpPopBlock:
 			m.PopBlock()
+		case OpPopFrameAndReset:
+			m.PopFrameAndReset()
 		/* Unary operators */
 		case OpUpos:
 			m.doOpUpos()
@@ -948,17 +1087,8 @@ func (m *Machine) PushFrameCall(cx *CallExpr, fv *FuncValue, recv Value) {
 		m.Printf("+F %#v\n", fr)
 	}
 	m.Frames = append(m.Frames, fr)
-	pkg := fv.GetPackage()
-	if debug {
-		if pkg == nil {
-			panic("should not happen")
-		}
-	}
-	m.Package = pkg
-	rlm := pkg.GetRealm()
-	if rlm != nil && m.Realm != rlm {
-		m.Realm = rlm // enter new realm
-	}
+	// the package and realm are entered upon OpCall, as
+	// args are evaluated in those of the caller.
 }
 
 func (m *Machine) PushFrameGoNative(cx *CallExpr, fv *nativeValue) {
@@ -1015,20 +1145,160 @@ func (m *Machine) PopFrameAndReturn() {
 	m.Exprs = m.Exprs[:fr.NumExprs]
 	m.Stmts = m.Stmts[:fr.NumStmts]
 	m.Blocks = m.Blocks[:fr.NumBlocks]
+	// move results atop the frame's values, as values of
+	// enclosing for/range/switch statements may be between.
+	copy(m.Values[fr.NumValues:], m.Values[m.NumValues-numRes:m.NumValues])
+	m.NumValues = fr.NumValues + numRes
 	// convert results to typed-nil if undefined
 	// and not func result type isn't interface kind.
 	for i := 0; i < numRes; i++ {
-		rtv := &m.Values[m.NumValues+i]
+		rtv := &m.Values[fr.NumValues+i]
 		if rtv.IsUndefined() && rtypes[i].Type.Kind() != InterfaceKind {
 			rtv.T = rtypes[i].Type
 		}
 	}
-	m.NumValues = fr.NumValues + numRes
 	m.Package = fr.LastPackage
+	m.Realm = fr.LastRealm
 }
 
+// Returns the frame of the call into the active realm from
+// another realm or package, or nil if the active realm was
+// not entered by a call.
+func (m *Machine) lastRealmFrame() *Frame {
+	for i := len(m.Frames) - 1; 0 <= i; i-- {
+		fr := &m.Frames[i]
+		if fr.Func == nil && fr.GoFunc == nil {
+			continue // not a call frame.
+		}
+		if fr.LastRealm != m.Realm {
+			return fr
+		}
+	}
+	return nil
+}
+
+// Returns the realm that called into the active realm, or nil
+// if called from outside any realm (e.g. from a non-realm
+// package) or if the active realm was not entered by a call.
+func (m *Machine) GetCallerRealm() *Realm {
+	if fr := m.lastRealmFrame(); fr != nil {
+		return fr.LastRealm
+	}
+	return nil
+}
+
+// Returns the package that called into the active realm, or
+// nil if the active realm was not entered by a call.
+func (m *Machine) GetCallerPackage() *PackageValue {
+	if fr := m.lastRealmFrame(); fr != nil {
+		return fr.LastPackage
+	}
+	return nil
+}
+
+// Pops a frame being unwound by a panic, restoring the
+// previous package and realm.  The realm being exited, if any,
+// is rolled back, for its function did not return.
 func (m *Machine) PopFrameForPanic() {
-	panic("not yet implemented")
+	fr := m.PopFrame()
+	if fr.Func == nil && fr.GoFunc == nil {
+		return // not a call frame.
+	}
+	if m.Realm != nil && m.Realm != fr.LastRealm {
+		m.Realm.Rollback()
+	}
+	m.Package = fr.LastPackage
+	m.Realm = fr.LastRealm
+}
+
+// Panics with ex in Gno, as by the uverse panic().
+func (m *Machine) Panic(ex TypedValue) {
+	m.Exceptions = append(m.Exceptions, Exception{
+		Value: ex,
+	})
+	m.unwindPanic()
+}
+
+// Unwinds frames for the current exception until that of a
+// function with deferred calls, which are then run by
+// OpReturnCallDefers.  If there is none, the exception is
+// panicked in Go.
+func (m *Machine) unwindPanic() {
+	ex := &m.Exceptions[len(m.Exceptions)-1]
+	for 0 < len(m.Frames) {
+		fr := m.LastFrame()
+		if fr.Func == nil || len(fr.Defers) == 0 {
+			m.PopFrameForPanic()
+			continue
+		}
+		ex.Frame = len(m.Frames) - 1
+		// Exceptions of unwound frames are superseded.
+		for 1 < len(m.Exceptions) {
+			pex := m.Exceptions[len(m.Exceptions)-2]
+			if pex.Frame < ex.Frame {
+				break
+			}
+			m.Exceptions[len(m.Exceptions)-2] = *ex
+			m.Exceptions = m.Exceptions[:len(m.Exceptions)-1]
+			ex = &m.Exceptions[len(m.Exceptions)-1]
+		}
+		// Reset to the function's block, which remains for
+		// named results and closures.
+		m.NumOps = fr.NumOps
+		m.NumValues = fr.NumValues
+		m.Exprs = m.Exprs[:fr.NumExprs]
+		m.Stmts = m.Stmts[:fr.NumStmts]
+		m.Blocks = m.Blocks[:fr.NumBlocks+1]
+		// If recovered, the function returns.
+		m.PushOp(OpReturnFromBlock)
+		m.PushOp(OpReturnCallDefers) // sticky
+		return
+	}
+	exc := *ex
+	m.Exceptions = m.Exceptions[:len(m.Exceptions)-1]
+	panic(exc)
+}
+
+// Returns true if the frame at index is running deferred
+// calls for the current exception.
+func (m *Machine) isPanicking(index int) bool {
+	numExcs := len(m.Exceptions)
+	return 0 < numExcs && m.Exceptions[numExcs-1].Frame == index
+}
+
+// Returns the current exception, which is then recovered,
+// if called directly by a deferred call of the function
+// that is panicking, as by the uverse recover().
+func (m *Machine) Recover() (ex TypedValue, ok bool) {
+	// Find the frame of the caller of recover, skipping
+	// those of loops and of calls whose arguments are being
+	// evaluated, e.g. println(recover()), whose blocks are
+	// not yet pushed.
+	numFrames := len(m.Frames)
+	caller := -1
+	for i := numFrames - 2; 0 <= i; i-- {
+		fr := &m.Frames[i]
+		if fr.Func == nil && fr.GoFunc == nil {
+			continue // not a call frame.
+		}
+		if fr.NumBlocks == m.Frames[i+1].NumBlocks {
+			continue // body not yet started.
+		}
+		caller = i
+		break
+	}
+	// The deferred call is right above the panicking
+	// function's frame.
+	if caller < 1 || !m.Frames[caller].IsDefer {
+		return
+	}
+	if !m.isPanicking(caller - 1) {
+		return
+	}
+	numExcs := len(m.Exceptions)
+	ex = m.Exceptions[numExcs-1].Value
+	m.Exceptions = m.Exceptions[:numExcs-1]
+	return ex, true
 }
 
 func (m *Machine) PeekFrameAndContinueFor() {
@@ -1053,6 +1323,68 @@ func (m *Machine) PeekFrameAndContinueRange() {
 	ls.BodyIndex = ls.BodyLen
 }
 
+// Resets to the body of the last frame, whose last block is
+// that of the body, to continue from the idx'th statement.
+func (m *Machine) PeekFrameAndGoto(idx int) {
+	fr := m.LastFrame()
+	var body Stmts
+	switch ss := fr.Source.(type) {
+	case *ForStmt:
+		m.PeekFrameAndContinueFor()
+		m.PeekStmt(1).(*loopStmt).BodyIndex = idx
+		return
+	case *RangeStmt:
+		m.PeekFrameAndContinueRange()
+		m.PeekStmt(1).(*loopStmt).BodyIndex = idx
+		return
+	case *SwitchStmt:
+		// the clause continuation, tag, and blocks.
+		m.NumOps = fr.NumOps + 1
+		m.NumValues = fr.NumValues
+		if ss.X != nil {
+			m.NumValues++
+		}
+		m.Blocks = m.Blocks[:fr.NumBlocks+2]
+		body = m.LastBlock().Source.(*SwitchCaseStmt).Body
+	case *IfStmt:
+		// the final continuation, and the block.
+		m.NumOps = fr.NumOps + 1
+		m.NumValues = fr.NumValues
+		m.Blocks = m.Blocks[:fr.NumBlocks+1]
+		// idx counts the body, then the else body.
+		if idx < len(ss.Body) {
+			body = ss.Body
+		} else {
+			idx -= len(ss.Body)
+			body = ss.Else
+		}
+	case *BlockStmt:
+		// the final continuation, and the block.
+		m.NumOps = fr.NumOps + 1
+		m.NumValues = fr.NumValues
+		m.Blocks = m.Blocks[:fr.NumBlocks+1]
+		body = ss.Body
+	default:
+		if fr.Func == nil {
+			panic("should not happen")
+		}
+		// the function block, and OpReturn if pushed.
+		m.NumOps = fr.NumOps
+		if len(fr.Func.Type.Results) == 0 {
+			m.NumOps++
+		}
+		m.NumValues = fr.NumValues
+		m.Blocks = m.Blocks[:fr.NumBlocks+1]
+		body = fr.Func.Body
+	}
+	m.Exprs = m.Exprs[:fr.NumExprs]
+	m.Stmts = m.Stmts[:fr.NumStmts]
+	for i := len(body) - 1; idx <= i; i-- {
+		m.PushStmt(body[i])
+		m.PushOp(OpExec)
+	}
+}
+
 func (m *Machine) NumFrames() int {
 	return len(m.Frames)
 }
@@ -1061,6 +1393,18 @@ func (m *Machine) LastFrame() *Frame {
 	return &m.Frames[len(m.Frames)-1]
 }
 
+// Returns the last frame of a function call, skipping those
+// of loops.
+func (m *Machine) LastCallFrame() *Frame {
+	for i := len(m.Frames) - 1; 0 <= i; i-- {
+		fr := &m.Frames[i]
+		if fr.Func != nil || fr.GoFunc != nil {
+			return fr
+		}
+	}
+	panic("should not happen")
+}
+
 func (m *Machine) PushForAssign(lx Expr) {
 	switch lx := lx.(type) {
 	case *NameExpr:
@@ -1091,7 +1435,9 @@ func (m *Machine) PushForAssign(lx Expr) {
 	}
 }
 
-func (m *Machine) PopForAssign(lx Expr) *TypedValue {
+// Returns a pointer to the value to be assigned, along with
+// its base object if any, for realm bookkeeping.
+func (m *Machine) PopForAssign(lx Expr) PointerValue {
 	switch lx := lx.(type) {
 	case *NameExpr:
 		if lx.Path.IsZero() {
@@ -1102,31 +1448,62 @@ func (m *Machine) PopForAssign(lx Expr) *TypedValue {
 						lx.Name))
 				}
 			}
-			return m.LastBlock().GetBlankRef()
+			return PointerValue{
+				TypedValue: m.LastBlock().GetBlankRef(),
+			}
 		} else {
-			return m.LastBlock().GetValueRefAt(lx.Path)
+			pv := m.LastBlock().GetPointerTo(lx.Path)
+			m.willUpdate(pv.GetBase())
+			return pv
 		}
 	case *IndexExpr:
 		iv := m.PopValue()
 		xv := m.PopValue()
+		if mv, ok := xv.V.(*MapValue); ok {
+			// save before the slot is created.
+			m.willUpdate(mv)
+			if _, ok := mv.GetValueForKey(iv); !ok {
+				m.allocate(listSize(nil, 2)) // key and value.
+			}
+		}
 		// NOTE: cannot get reference &x[key];
 		// for maps, an empty slot is created.
-		return xv.GetValueRefAtIndexForAssign(iv)
+		pv := xv.GetPointerAtIndex(iv)
+		m.willUpdate(pv.GetBase())
+		return pv
 	case *SelectorExpr:
 		xv := m.PopValue()
-		return xv.GetValueRefAtForAssign(lx.Path)
+		pv := xv.GetPointerTo(lx.Path)
+		m.willUpdate(pv.GetBase())
+		return pv
 	case *StarExpr:
 		ptr := m.PopValue().V.(PointerValue)
-		return ptr.TypedValue
+		if ptr.Base == nil {
+			// e.g. `*p = x` where p points to an object.
+			m.willUpdate(ptr.getTarget())
+		} else {
+			m.willUpdate(ptr.GetBase())
+		}
+		return ptr
 	case *CompositeLitExpr: // for *RefExpr
 		tv := m.PopValue()
 		tv2 := *tv
-		return &tv2 // heap alloc
+		return PointerValue{
+			TypedValue: &tv2, // heap alloc
+		}
 	default:
 		panic("should not happen")
 	}
 }
 
+// Called before the elements of oo are modified by the
+// machine.  Panics if oo belongs to a realm other than the
+// active one.
+func (m *Machine) willUpdate(oo Object) {
+	checkRealmAccess(m.Realm, oo)
+	m.Realm.WillUpdate(oo)
+}
+
 // for testing.
 func (m *Machine) CheckEmpty() error {
 	found := ""
@@ -1149,6 +1526,8 @@ func (m *Machine) CheckEmpty() error {
 		}
 	} else if len(m.Frames) > 0 {
 		found = "frame"
+	} else if len(m.Exceptions) > 0 {
+		found = "exception"
 	} else if m.NumResults > 0 {
 		found = ".NumResults != 0"
 	}
@@ -290,14 +290,20 @@ func (x *SendStmt) Copy() Node {
 
 func (x *SwitchStmt) Copy() Node {
 	return &SwitchStmt{
-		Init:    copyStmt(x.Init),
-		X:       x.X.Copy().(Expr),
-		Cases:   copySwitchCases(x.Cases),
-		VarName: x.VarName,
+		Init:         copyStmt(x.Init),
+		X:            copyExpr(x.X),
+		IsTypeSwitch: x.IsTypeSwitch,
+		Cases:        copySwitchCases(x.Cases),
+		VarName:      x.VarName,
 	}
 }
 
 func (x *SwitchCaseStmt) Copy() Node {
+	if x.Cases == nil { // default case
+		return &SwitchCaseStmt{
+			Body: copyStmts(x.Body),
+		}
+	}
 	return &SwitchCaseStmt{
 		Cases: copyExprs(x.Cases),
 		Body:  copyStmts(x.Body),
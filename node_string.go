@@ -332,6 +332,13 @@ func (n SwitchStmt) String() string {
 	if n.VarName != "" {
 		varName = string(n.VarName) + ":="
 	}
+	x := ""
+	if n.X != nil {
+		x = n.X.String()
+		if n.IsTypeSwitch {
+			x += ".(type)"
+		}
+	}
 	cases := ""
 	for i, s := range n.Cases {
 		if i == 0 {
@@ -341,10 +348,13 @@ func (n SwitchStmt) String() string {
 		}
 	}
 	return fmt.Sprintf("switch %s%s%s { %s }",
-		init, varName, n.X.String(), cases)
+		init, varName, x, cases)
 }
 
 func (n SwitchCaseStmt) String() string {
+	if n.Cases == nil {
+		return fmt.Sprintf("default: %s", n.Body.String())
+	}
 	return fmt.Sprintf("case %v: %s", n.Cases, n.Body.String())
 }
 
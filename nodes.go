@@ -590,8 +590,10 @@ type BlockStmt struct {
 
 type BranchStmt struct {
 	Attributes
-	Op    Word // keyword word (BREAK, CONTINUE, GOTO, FALLTHROUGH)
-	Label Name // label name; or empty
+	Op        Word // keyword word (BREAK, CONTINUE, GOTO, FALLTHROUGH)
+	Label     Name // label name; or empty
+	Depth     int  // blocks to label's block, for GOTO
+	BodyIndex int  // index of labeled stmt in body, for GOTO
 }
 
 type DeclStmt struct {
@@ -691,10 +693,11 @@ type SendStmt struct {
 type SwitchStmt struct {
 	Attributes
 	StaticBlock
-	Init    Stmt             // initialization (simple) statement; or nil.
-	X       Expr             // tag or _.(type) expression; or nil.
-	Cases   []SwitchCaseStmt // cases
-	VarName Name             // tag or type-switched value.
+	Init         Stmt             // initialization (simple) statement; or nil.
+	X            Expr             // tag or _.(type) expression; or nil.
+	IsTypeSwitch bool             // true iff X is _.(type) expression.
+	Cases        []SwitchCaseStmt // cases
+	VarName      Name             // tag or type-switched value.
 }
 
 type SwitchCaseStmt struct {
@@ -915,6 +918,16 @@ func (pn *PackageNode) NewPackage(rlmr Realmer) *PackageValue {
 		pv.SetRealm(rlm)
 		rlm.pkg = pv // TODO
 	}
+	// Make blocks for files already preprocessed, e.g. if pn
+	// was loaded from a store.
+	if pn.FileSet != nil {
+		for _, fn := range pn.FileSet.Files {
+			fb := NewBlock(fn, &pv.Block)
+			fb.Values = make([]TypedValue, len(fn.StaticBlock.Values))
+			copy(fb.Values, fn.StaticBlock.Values)
+			pv.AddFileBlock(fn.Name, fb)
+		}
+	}
 	pn.UpdatePackage(pv)
 	return pv
 }
@@ -992,6 +1005,31 @@ func (pn *PackageNode) UpdatePackage(pv *PackageValue) {
 	}
 }
 
+// Defines a function whose body is implemented natively, with
+// access to the machine (e.g. to query the calling realm).
+// Params and results are read from and pushed onto the machine
+// as with uverse functions.
+func (pn *PackageNode) DefineNative(n Name, ps, rs FieldTypeExprs, native func(*Machine)) {
+	if debug {
+		debug.Printf("*PackageNode.DefineNative(%s)\n", n)
+	}
+	fd := FuncD(n, ps, rs, nil)
+	// Preprocess sets v.Source.Name on .Source.StaticBlock.
+	fd = Preprocess(nil, pn, fd).(*FuncDecl)
+	ft := evalType(pn, &fd.Type).(*FuncType)
+	if debug {
+		if ft == nil {
+			panic("should not happen")
+		}
+	}
+	// Set the native override function,
+	// which doesn't get interpeted as it
+	// doesn't exist in the declaration node.
+	fv := pn.GetValueRef(n).V.(*FuncValue)
+	fv.NativeBody = native
+	// fv.Closure, fv.pkg set during .NewPackage().
+}
+
 //----------------------------------------
 // BlockNode
 
@@ -1312,4 +1350,5 @@ const (
 	ATTR_TYPEOF_VALUE
 	ATTR_LABEL
 	ATTR_IOTA
+	ATTR_GOTO_TARGETS // if/block stmt with labels of gotos in body
 )
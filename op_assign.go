@@ -6,8 +6,10 @@ func (m *Machine) doOpDefine() {
 	// define in LastBlock according to Lhs.
 	// NOTE: PopValues() returns a slice in
 	// forward order, not the usual reverse.
-	rvs := m.PopValues(len(s.Rhs))
-	for i := 0; i < len(s.Rhs); i++ {
+	// NOTE: there are more Lhs than Rhs for
+	// `x, y := f()`.
+	rvs := m.PopValues(len(s.Lhs))
+	for i := 0; i < len(s.Lhs); i++ {
 		// Get name and value of i'th term.
 		nx := s.Lhs[i].(*NameExpr)
 		rv := rvs[i]
@@ -38,8 +40,10 @@ func (m *Machine) doOpAssign() {
 	// assign in LastBlock according to Lhs.
 	// NOTE: PopValues() returns a slice in
 	// forward order, not the usual reverse.
-	rvs := m.PopValues(len(s.Rhs))
-	for i := len(s.Rhs) - 1; 0 <= i; i-- {
+	// NOTE: there are more Lhs than Rhs for
+	// `x, y = f()`.
+	rvs := m.PopValues(len(s.Lhs))
+	for i := len(s.Lhs) - 1; 0 <= i; i-- {
 		rv := rvs[i]
 		// Pop lhs value and desired type.
 		lv := m.PopForAssign(s.Lhs[i])
@@ -59,7 +63,7 @@ func (m *Machine) doOpAssign() {
 			}
 		}
 		// Finally, assign.
-		lv.Assign(rv)
+		lv.Assign2(m.Realm, rv)
 	}
 }
 
@@ -67,108 +71,111 @@ func (m *Machine) doOpAddAssign() {
 	s := m.PopStmt().(*AssignStmt)
 	rv := m.PopValue() // only one.
 	lv := m.PopForAssign(s.Lhs[0])
-	if debug {
-		assertTypes(lv.T, rv.T)
-	}
 
 	// add rv to lv.
-	addAssign(lv, rv)
+	m.allocateConcat(lv.TypedValue, rv)
+	opAssign(lv.TypedValue, rv, addAssign)
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
 
 func (m *Machine) doOpSubAssign() {
 	s := m.PopStmt().(*AssignStmt)
 	rv := m.PopValue() // only one.
 	lv := m.PopForAssign(s.Lhs[0])
-	if debug {
-		assertTypes(lv.T, rv.T)
-	}
 
 	// sub rv from lv.
-	subAssign(lv, rv)
+	opAssign(lv.TypedValue, rv, subAssign)
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
 
 func (m *Machine) doOpMulAssign() {
 	s := m.PopStmt().(*AssignStmt)
 	rv := m.PopValue() // only one.
 	lv := m.PopForAssign(s.Lhs[0])
-	if debug {
-		assertTypes(lv.T, rv.T)
-	}
 
 	// lv *= rv
-	mulAssign(lv, rv)
+	opAssign(lv.TypedValue, rv, mulAssign)
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
 
 func (m *Machine) doOpQuoAssign() {
 	s := m.PopStmt().(*AssignStmt)
 	rv := m.PopValue() // only one.
 	lv := m.PopForAssign(s.Lhs[0])
-	if debug {
-		assertTypes(lv.T, rv.T)
-	}
 
 	// lv /= rv
-	quoAssign(lv, rv)
+	opAssign(lv.TypedValue, rv, quoAssign)
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
 
 func (m *Machine) doOpRemAssign() {
 	s := m.PopStmt().(*AssignStmt)
 	rv := m.PopValue() // only one.
 	lv := m.PopForAssign(s.Lhs[0])
-	if debug {
-		assertTypes(lv.T, rv.T)
-	}
 
 	// lv %= rv
-	remAssign(lv, rv)
+	opAssign(lv.TypedValue, rv, remAssign)
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
 
 func (m *Machine) doOpBandAssign() {
 	s := m.PopStmt().(*AssignStmt)
 	rv := m.PopValue() // only one.
 	lv := m.PopForAssign(s.Lhs[0])
-	if debug {
-		assertTypes(lv.T, rv.T)
-	}
 
 	// lv &= rv
-	bandAssign(lv, rv)
+	opAssign(lv.TypedValue, rv, bandAssign)
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
 
 func (m *Machine) doOpBandnAssign() {
 	s := m.PopStmt().(*AssignStmt)
 	rv := m.PopValue() // only one.
 	lv := m.PopForAssign(s.Lhs[0])
-	if debug {
-		assertTypes(lv.T, rv.T)
-	}
 
 	// lv &^= rv
-	bandnAssign(lv, rv)
+	opAssign(lv.TypedValue, rv, bandnAssign)
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
 
 func (m *Machine) doOpBorAssign() {
 	s := m.PopStmt().(*AssignStmt)
 	rv := m.PopValue() // only one.
 	lv := m.PopForAssign(s.Lhs[0])
-	if debug {
-		assertTypes(lv.T, rv.T)
-	}
 
 	// lv |= rv
-	borAssign(lv, rv)
+	opAssign(lv.TypedValue, rv, borAssign)
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
 
 func (m *Machine) doOpXorAssign() {
 	s := m.PopStmt().(*AssignStmt)
 	rv := m.PopValue() // only one.
 	lv := m.PopForAssign(s.Lhs[0])
-	if debug {
-		assertTypes(lv.T, rv.T)
-	}
 
 	// lv ^= rv
-	xorAssign(lv, rv)
+	opAssign(lv.TypedValue, rv, xorAssign)
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
+}
+
+// Applies the assignment op fn to lv, which may be a data byte,
+// in which case fn is applied to a uint8 copy that is written
+// back.
+func opAssign(lv, rv *TypedValue, fn func(lv, rv *TypedValue)) {
+	if lv.T != DataByteType {
+		if debug {
+			assertTypes(lv.T, rv.T)
+		}
+		fn(lv, rv)
+		return
+	}
+	tv := TypedValue{T: Uint8Type}
+	tv.SetUint8(lv.GetDataByte())
+	if debug {
+		assertTypes(tv.T, rv.T)
+	}
+	fn(&tv, rv)
+	lv.SetDataByte(tv.GetUint8())
 }
 
 func (m *Machine) doOpShlAssign() {
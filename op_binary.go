@@ -1,6 +1,7 @@
 package gno
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 )
@@ -71,7 +72,9 @@ func (m *Machine) doOpEql() {
 	rv := m.PopValue()
 	lv := m.PeekValue(1) // also the result
 	if debug {
-		assertTypes(lv.T, rv.T)
+		if !isNilInterface(lv) && !isNilInterface(rv) { // unless nil.
+			assertTypes(lv.T, rv.T)
+		}
 	}
 
 	// set result in lv.
@@ -88,7 +91,9 @@ func (m *Machine) doOpNeq() {
 	rv := m.PopValue()
 	lv := m.PeekValue(1) // also the result
 	if debug {
-		assertTypes(lv.T, rv.T)
+		if !isNilInterface(lv) && !isNilInterface(rv) { // unless nil.
+			assertTypes(lv.T, rv.T)
+		}
 	}
 
 	// set result in lv.
@@ -177,6 +182,7 @@ func (m *Machine) doOpAdd() {
 	}
 
 	// add rv to lv.
+	m.allocateConcat(lv, rv)
 	addAssign(lv, rv)
 }
 
@@ -351,6 +357,19 @@ func assertTypes(lt, rt Type) {
 
 // TODO: can be much faster.
 func isEql(lv, rv *TypedValue) bool {
+	if lv.T == nil && rv.T == nil {
+		// nil interfaces, or untyped nil.
+		return true
+	} else if isNilInterface(lv) && lv.T != nil {
+		// typed nil interface, see convertIfNil().
+		return isNilInterface(rv)
+	} else if isNilInterface(rv) && rv.T != nil {
+		return isNilInterface(lv)
+	} else if lv.T == nil {
+		return isNil(rv)
+	} else if rv.T == nil {
+		return isNil(lv)
+	}
 	switch lv.T.Kind() {
 	case BoolKind:
 		return (lv.GetBool() == rv.GetBool())
@@ -381,8 +400,13 @@ func isEql(lv, rv *TypedValue) bool {
 		rb := rv.V.(BigintValue).V
 		return lb.Cmp(rb) == 0
 	case ArrayKind:
-		la := lv.V.(*ArrayValue).List
-		ra := rv.V.(*ArrayValue).List
+		lav, rav := lv.V.(*ArrayValue), rv.V.(*ArrayValue)
+		if lav.Data != nil && rav.Data != nil {
+			// byte arrays.
+			return bytes.Equal(lav.Data, rav.Data)
+		}
+		la := lav.List
+		ra := rav.List
 		if debug {
 			if len(la) != len(ra) {
 				panic("comparison on arrays of unequal length")
@@ -406,6 +430,27 @@ func isEql(lv, rv *TypedValue) bool {
 	}
 }
 
+// Returns true if tv is a nil value of a kind that may be nil,
+// for comparison with an undefined value (e.g. nil).  Values
+// of other kinds, e.g. held by a non-nil interface, are not nil.
+// Values held by interfaces are compared with nils typed with
+// the interface instead, see convertIfNil().
+func isNil(tv *TypedValue) bool {
+	switch tv.T.Kind() {
+	case SliceKind:
+		// zero slices have no base.
+		if sv, ok := tv.V.(*SliceValue); ok {
+			return sv.Base == nil
+		}
+		return tv.V == nil
+	case PointerKind, MapKind, FuncKind, ChanKind,
+		InterfaceKind:
+		return tv.V == nil
+	default:
+		return false
+	}
+}
+
 // TODO: can be much faster.
 func isLss(lv, rv *TypedValue) bool {
 	switch baseOf(lv.T) {
@@ -65,6 +65,18 @@ func (m *Machine) doOpCall() {
 	pts := ft.Params
 	numParams := len(pts)
 	isMethod := 0 // 1 if true
+	// Enter the package and realm of the function.
+	pkg := fv.GetPackage()
+	if debug {
+		if pkg == nil {
+			panic("should not happen")
+		}
+	}
+	m.Package = pkg
+	rlm := pkg.GetRealm()
+	if rlm != nil && m.Realm != rlm {
+		m.Realm = rlm // enter new realm
+	}
 	// continuation
 	if fv.NativeBody == nil {
 		// If a function has return values, this is not necessary.
@@ -89,6 +101,7 @@ func (m *Machine) doOpCall() {
 	}
 	// Create new block scope
 	b := NewBlock(fr.Func.Source, fr.Func.Closure)
+	m.allocateBlock(len(b.Values))
 	m.PushBlock(b)
 	// Assign receiver as first parameter, if any.
 	if fr.Receiver != nil {
@@ -149,8 +162,23 @@ func (m *Machine) doOpCallNativeBody() {
 
 // Assumes that result values are pushed onto the Values stack.
 func (m *Machine) doOpReturn() {
+	if fr := m.LastFrame(); 0 < len(fr.Defers) {
+		// Implicit return of a function without results,
+		// so there are no results to copy to the block.
+		m.PushOp(OpReturnFromBlock)
+		m.PushOp(OpReturnCallDefers) // sticky
+		return
+	}
+	m.finalizeRealmOnReturn()
+	// finalize
+	m.PopFrameAndReturn()
+}
+
+// See if we are exiting a realm boundary, and if so, finalize
+// the active realm's transaction.  The previous realm is
+// restored by PopFrameAndReturn().
+func (m *Machine) finalizeRealmOnReturn() {
 	fr := m.LastFrame()
-	// See if we are exiting a realm boundary.
 	crlm := m.Realm
 	if crlm != nil {
 		lrlm := fr.LastRealm
@@ -165,11 +193,9 @@ func (m *Machine) doOpReturn() {
 		if finalize {
 			// Finalize realm updates!
 			// NOTE: This is a resource intensive undertaking.
-			crlm.FinalizeRealmTransaction()
+			m.finalizeRealm(crlm)
 		}
 	}
-	// finalize
-	m.PopFrameAndReturn()
 }
 
 // Like doOpReturn after pushing results to values stack.
@@ -183,6 +209,7 @@ func (m *Machine) doOpReturnFromBlock() {
 		rtv := fblock.Values[i+numParams]
 		m.PushValue(rtv)
 	}
+	m.finalizeRealmOnReturn()
 	// finalize
 	m.PopFrameAndReturn()
 }
@@ -202,8 +229,78 @@ func (m *Machine) doOpReturnToBlock() {
 	}
 }
 
+// Calls the last deferred call of the frame, which is popped.
+// Sticky, so it is called again after each deferred call
+// returns, until none are left.  Then if the frame's function
+// is panicking (and wasn't recovered), unwinding continues,
+// and otherwise the function returns.
 func (m *Machine) doOpReturnCallDefers() {
-	panic("not yet implemented")
-	// TODO sticky, so force pop once
-	// deferred statements are gone.
+	cfr := m.LastFrame()
+	numDefers := len(cfr.Defers)
+	if numDefers == 0 {
+		m.ForcePopOp()
+		if m.isPanicking(len(m.Frames) - 1) {
+			m.unwindPanic()
+		}
+		return
+	}
+	dfr := cfr.Defers[numDefers-1]
+	cfr.Defers = cfr.Defers[:numDefers-1]
+	// Results of deferred calls are discarded.
+	m.PushOp(OpPopResults)
+	if dfr.Func != nil {
+		m.PushFrameCall(&dfr.Source.Call, dfr.Func, dfr.Receiver)
+		m.LastFrame().IsDefer = true
+		m.PushOp(OpCall)
+	} else if dfr.GoFunc != nil {
+		m.PushFrameGoNative(&dfr.Source.Call, dfr.GoFunc)
+		m.LastFrame().IsDefer = true
+		m.PushOp(OpCallGoNative)
+	} else {
+		panic("should not happen")
+	}
+	// Arguments were evaluated upon defer.
+	for _, arg := range dfr.Args {
+		m.PushValue(arg)
+	}
+}
+
+// Evaluated function and arguments are deferred to the
+// frame of the enclosing function call.
+func (m *Machine) doOpDefer() {
+	ds := m.PopStmt().(*DeferStmt)
+	cfr := m.LastCallFrame()
+	numArgs := len(ds.Call.Args)
+	// Arguments and receivers are copied, for they may be
+	// mutated before the deferred call.
+	args := make([]TypedValue, numArgs)
+	for i, arg := range m.PopValues(numArgs) {
+		args[i] = arg.Copy()
+	}
+	ftv := m.PopValue()
+	switch fv := ftv.V.(type) {
+	case *FuncValue:
+		cfr.Defers = append(cfr.Defers, Defer{
+			Func:   fv,
+			Args:   args,
+			Source: ds,
+		})
+	case BoundMethodValue:
+		cfr.Defers = append(cfr.Defers, Defer{
+			Func:     fv.Func,
+			Receiver: TypedValue{V: fv.Receiver}.Copy().V,
+			Args:     args,
+			Source:   ds,
+		})
+	case *nativeValue:
+		cfr.Defers = append(cfr.Defers, Defer{
+			GoFunc: fv,
+			Args:   args,
+			Source: ds,
+		})
+	default:
+		panic(fmt.Sprintf(
+			"unexpected deferred function value %s",
+			ftv.String()))
+	}
 }
@@ -7,6 +7,8 @@ these except IfStmt to support break/continue branch
 statements. Omitting frames requires more complex logic
 during break/continue and results in brittle code, so we
 choose to use frames for all but IfStmt block nodes.
+IfStmt and BlockStmt nodes with labels of gotos in their
+bodies also use frames, to reset to upon goto.
 
 CallExpr ->
   OpPrecall->
@@ -24,10 +26,16 @@ RangeStmt ->
 
 IfStmt ->
   OpIfCond -> +block
-    OpPopBlock
+    OpPopBlock (or OpPopFrameAndReset)
+
+BlockStmt -> +block
+  OpPopBlock (or OpPopFrameAndReset)
 
 SwitchStmt -> +block
-  OpSwitchCase
+  OpSwitchCase -> +block
+    OpSwitchCase
+  OpTypeSwitchCase -> +block
+    OpTypeSwitchCase
 
 SelectStmt ->
   OpSelectCase +block
@@ -65,9 +73,19 @@ func (m *Machine) doOpForLoop1() {
 }
 
 func (m *Machine) doOpIfCond() {
-	is := m.PopStmt().(*IfStmt)
+	s := m.PeekStmt(1)
+	if ls, ok := s.(*loopStmt); ok {
+		s = ls.Active
+	}
+	is := s.(*IfStmt)
 	// final continuation
-	m.PushOp(OpPopBlock)
+	if is.GetAttribute(ATTR_GOTO_TARGETS) != nil {
+		// the frame pops the statement.
+		m.PushOp(OpPopFrameAndReset)
+	} else {
+		m.PopStmt()
+		m.PushOp(OpPopBlock)
+	}
 	// Test cond and Run the body or else.
 	cond := m.PopValue()
 	if cond.GetBool() {
@@ -86,3 +104,219 @@ func (m *Machine) doOpIfCond() {
 		}
 	}
 }
+
+// The switch frame's BodyIndex is the index of the next case
+// expression to test, counting those of all clauses in order,
+// or -1 once the body of a clause is running.
+func (m *Machine) doOpSwitchCase() {
+	fr := m.LastFrame()
+	ss := fr.Source.(*SwitchStmt)
+	if fr.BodyIndex == -1 {
+		// done with clause body.
+		m.PopFrameAndReset()
+		return
+	}
+	// tag value, or nil if tagless.
+	var tv *TypedValue
+	numValues := fr.NumValues
+	if ss.X != nil {
+		tv = &m.Values[numValues]
+		numValues++
+	}
+	// compare the case value last evaluated, if any.
+	if numValues < m.NumValues {
+		cv := m.PopValue()
+		if isSwitchMatch(tv, cv) {
+			ci, _ := switchCaseAt(ss, fr.BodyIndex)
+			m.runSwitchClause(ss, ci)
+			return
+		}
+		fr.BodyIndex++
+	}
+	// test case expressions in order until one matches.
+	for {
+		ci, xi := switchCaseAt(ss, fr.BodyIndex)
+		if ci == -1 {
+			break
+		}
+		cx := ss.Cases[ci].Cases[xi]
+		if cx, ok := cx.(*constExpr); ok {
+			if isSwitchMatch(tv, &cx.TypedValue) {
+				m.runSwitchClause(ss, ci)
+				return
+			}
+			fr.BodyIndex++
+			continue
+		}
+		// evaluate case expression in the clause block.
+		m.pushSwitchClauseBlock(ss, ci)
+		m.PushOp(OpSwitchCase)
+		m.PushExpr(cx)
+		m.PushOp(OpEval)
+		return
+	}
+	// no case matched.
+	if ci := switchDefaultOf(ss); ci != -1 {
+		m.runSwitchClause(ss, ci)
+		return
+	}
+	m.PopFrameAndReset()
+}
+
+func (m *Machine) doOpTypeSwitchCase() {
+	fr := m.LastFrame()
+	ss := fr.Source.(*SwitchStmt)
+	if fr.BodyIndex == -1 {
+		// done with clause body.
+		m.PopFrameAndReset()
+		return
+	}
+	xv := m.PeekValue(1)
+	match := typeSwitchCaseOf(ss, xv)
+	if match == -1 {
+		// no case matched, and no default.
+		m.PopFrameAndReset()
+		return
+	}
+	b := m.runSwitchClause(ss, match)
+	// bind the type-switched value.
+	if ss.VarName != "" {
+		cs := &ss.Cases[match]
+		idx, _ := cs.GetLocalIndex(ss.VarName)
+		if isNilInterface(xv) {
+			// nil of the type of the switch expression.
+			vt := cs.GetStaticBlock().Block.Values[idx].T
+			b.Values[idx] = anyValue(vt)
+		} else {
+			b.Values[idx] = *xv
+		}
+	}
+}
+
+// Pushes the block of clause ci, unless already pushed, and
+// runs its body.
+func (m *Machine) runSwitchClause(ss *SwitchStmt, ci int) *Block {
+	b := m.pushSwitchClauseBlock(ss, ci)
+	m.LastFrame().BodyIndex = -1
+	// continuation
+	if ss.IsTypeSwitch {
+		m.PushOp(OpTypeSwitchCase)
+	} else {
+		m.PushOp(OpSwitchCase)
+	}
+	// Run the body.
+	body := ss.Cases[ci].Body
+	for i := len(body) - 1; 0 <= i; i-- {
+		m.PushStmt(body[i])
+		m.PushOp(OpExec)
+	}
+	return b
+}
+
+// Clause blocks replace each other atop the switch block, and
+// are initialized with the values of the switch block, which
+// the preprocessor defines first in each clause.
+func (m *Machine) pushSwitchClauseBlock(ss *SwitchStmt, ci int) *Block {
+	fr := m.LastFrame()
+	cs := &ss.Cases[ci]
+	if fr.NumBlocks+1 < len(m.Blocks) {
+		lb := m.LastBlock()
+		if lb.Source == BlockNode(cs) {
+			return lb
+		}
+		// copy back values of the switch block, as
+		// updated before falling through.
+		m.PopBlock()
+		copy(m.LastBlock().Values, lb.Values)
+	}
+	sb := m.LastBlock()
+	b := NewBlock(cs, sb)
+	m.allocateBlock(len(b.Values))
+	copy(b.Values, sb.Values)
+	m.PushBlock(b)
+	return b
+}
+
+// Returns the clause index of the i'th case expression of ss,
+// and the index of the expression within that clause, or -1,
+// -1 if there are not as many.
+func switchCaseAt(ss *SwitchStmt, i int) (ci, xi int) {
+	for ci := range ss.Cases {
+		n := len(ss.Cases[ci].Cases)
+		if i < n {
+			return ci, i
+		}
+		i -= n
+	}
+	return -1, -1
+}
+
+// Returns the clause index of the clause of block b.
+func switchClauseOf(ss *SwitchStmt, b *Block) int {
+	for ci := range ss.Cases {
+		if b.Source == BlockNode(&ss.Cases[ci]) {
+			return ci
+		}
+	}
+	panic("should not happen")
+}
+
+// Returns the clause index of the default case of ss, or -1.
+func switchDefaultOf(ss *SwitchStmt) int {
+	for ci := range ss.Cases {
+		if ss.Cases[ci].Cases == nil {
+			return ci
+		}
+	}
+	return -1
+}
+
+// Returns the clause index of the first case type matching
+// xv, or of the default case, or -1.
+func typeSwitchCaseOf(ss *SwitchStmt, xv *TypedValue) int {
+	for ci := range ss.Cases {
+		for _, cx := range ss.Cases[ci].Cases {
+			if isTypeSwitchMatch(xv, cx) {
+				return ci
+			}
+		}
+	}
+	return switchDefaultOf(ss)
+}
+
+// Returns true if tv is a nil interface value, which may be
+// typed with the interface type.
+func isNilInterface(tv *TypedValue) bool {
+	return tv.T == nil ||
+		tv.T.Kind() == InterfaceKind && tv.V == nil
+}
+
+// Values of different types, as of interface tags, never match.
+// Untyped nil cases match nil values of any type.
+func isSwitchMatch(tv, cv *TypedValue) bool {
+	if tv == nil { // tagless switch
+		return cv.GetBool()
+	}
+	if tv.T != nil && cv.T != nil && tv.T.TypeID() != cv.T.TypeID() {
+		return false
+	}
+	return isEql(tv, cv)
+}
+
+// cx is a *constTypeExpr, or an undefined *constExpr for nil.
+func isTypeSwitchMatch(xv *TypedValue, cx Expr) bool {
+	switch cx := cx.(type) {
+	case *constExpr:
+		return isNilInterface(xv)
+	case *constTypeExpr:
+		if isNilInterface(xv) {
+			return false
+		}
+		if it, ok := baseOf(cx.Type).(*InterfaceType); ok {
+			return implementsInterface(xv.T, it)
+		}
+		return xv.T.TypeID() == cx.Type.TypeID()
+	default:
+		panic("should not happen")
+	}
+}
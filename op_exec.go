@@ -74,6 +74,7 @@ func (m *Machine) doOpExec(op Op) {
 		case -2: // init.
 			ls.ListLen = xv.GetLength()
 			b := NewBlock(ls.RangeStmt, m.LastBlock())
+			m.allocateBlock(len(b.Values))
 			m.PushBlock(b)
 			ls.BodyIndex++
 			fallthrough
@@ -84,7 +85,7 @@ func (m *Machine) doOpExec(op Op) {
 				if ls.ListIndex == 0 {
 					switch rs.Op {
 					case ASSIGN:
-						m.PopForAssign(rs.Key).Assign(iv)
+						m.PopForAssign(rs.Key).Assign2(m.Realm, iv)
 					case DEFINE:
 						knxp := rs.Key.(*NameExpr).Path
 						*m.LastBlock().GetValueRefAt(knxp) = iv
@@ -93,7 +94,7 @@ func (m *Machine) doOpExec(op Op) {
 					}
 				} else {
 					// Already defined, use assign.
-					m.PopForAssign(rs.Key).Assign(iv)
+					m.PopForAssign(rs.Key).Assign2(m.Realm, iv)
 				}
 			}
 			if rs.Value != nil {
@@ -103,7 +104,7 @@ func (m *Machine) doOpExec(op Op) {
 				if ls.ListIndex == 0 {
 					switch rs.Op {
 					case ASSIGN:
-						m.PopForAssign(rs.Value).Assign(ev)
+						m.PopForAssign(rs.Value).Assign2(m.Realm, ev)
 					case DEFINE:
 						vnxp := rs.Value.(*NameExpr).Path
 						*m.LastBlock().GetValueRefAt(vnxp) = ev
@@ -112,7 +113,7 @@ func (m *Machine) doOpExec(op Op) {
 					}
 				} else {
 					// Already defined, use assign.
-					m.PopForAssign(rs.Value).Assign(ev)
+					m.PopForAssign(rs.Value).Assign2(m.Realm, ev)
 				}
 			}
 			ls.BodyIndex++
@@ -213,6 +214,7 @@ EXEC_SWITCH:
 	case *ForStmt:
 		m.PushFrameBasic(cs)
 		b := NewBlock(cs, m.LastBlock())
+		m.allocateBlock(len(b.Values))
 		m.PushBlock(b)
 		// continuation (persistent)
 		m.PushOp(OpForLoop2)
@@ -232,7 +234,11 @@ EXEC_SWITCH:
 			m.PushOp(OpExec)
 		}
 	case *IfStmt:
+		if cs.GetAttribute(ATTR_GOTO_TARGETS) != nil {
+			m.PushFrameBasic(cs)
+		}
 		b := NewBlock(cs, m.LastBlock())
+		m.allocateBlock(len(b.Values))
 		m.PushBlock(b)
 		// continuation
 		m.PushOp(OpIfCond)
@@ -244,6 +250,27 @@ EXEC_SWITCH:
 			m.PushStmt(cs.Init)
 			m.PushOp(OpExec)
 		}
+	case *SwitchStmt:
+		m.PushFrameBasic(cs)
+		b := NewBlock(cs, m.LastBlock())
+		m.allocateBlock(len(b.Values))
+		m.PushBlock(b)
+		// continuation
+		if cs.IsTypeSwitch {
+			m.PushOp(OpTypeSwitchCase)
+		} else {
+			m.PushOp(OpSwitchCase)
+		}
+		// evaluate tag, if any.
+		if cs.X != nil {
+			m.PushExpr(cs.X)
+			m.PushOp(OpEval)
+		}
+		// exec init statement
+		if cs.Init != nil {
+			m.PushStmt(cs.Init)
+			m.PushOp(OpExec)
+		}
 	case *IncDecStmt:
 		switch cs.Op {
 		case INC:
@@ -259,7 +286,13 @@ EXEC_SWITCH:
 		m.PushForAssign(cs.X)
 	case *ReturnStmt:
 		m.PopStmt()
+		// Pop any frames of enclosing for/range/switch
+		// statements, which are reset upon return.
 		fr := m.LastFrame()
+		for fr.Func == nil {
+			m.PopFrame()
+			fr = m.LastFrame()
+		}
 		hasDefers := 0 < len(fr.Defers)
 		hasResults := 0 < len(fr.Func.Type.Results)
 		// If has defers, return from the block stack.
@@ -269,14 +302,14 @@ EXEC_SWITCH:
 			// present in the func block.
 			m.PushOp(OpReturnFromBlock)
 			m.PushOp(OpReturnCallDefers) // sticky
-			if cs.Results == nil {
+			if len(cs.Results) == 0 {
 				// results already in block, if any.
 			} else if hasResults {
 				// copy return results to block.
 				m.PushOp(OpReturnToBlock)
 			}
 		} else {
-			if cs.Results == nil {
+			if len(cs.Results) == 0 {
 				m.PushOp(OpReturnFromBlock)
 			} else {
 				m.PushOp(OpReturn)
@@ -320,13 +353,13 @@ EXEC_SWITCH:
 	case *BranchStmt:
 		switch cs.Op {
 		case BREAK:
-			// Pop frames until for/range
+			// Pop frames until for/range/switch
 			// statement (which matches
 			// label, if labeled), and reset.
 			for {
 				fr := m.LastFrame()
 				switch fr.Source.(type) {
-				case *ForStmt, *RangeStmt:
+				case *ForStmt, *RangeStmt, *SwitchStmt:
 					if cs.Label != "" && cs.Label != fr.Label {
 						m.PopFrame()
 					} else {
@@ -361,12 +394,58 @@ EXEC_SWITCH:
 				}
 			}
 		case GOTO:
-			panic("not yet implemented")
+			// Pop frames above that of the labeled
+			// statement's block, and continue from
+			// the labeled statement.
+			bi := len(m.Blocks) - 1 - cs.Depth
+			for m.LastFrame().NumBlocks > bi {
+				m.PopFrame()
+			}
+			m.PeekFrameAndGoto(cs.BodyIndex)
 		case FALLTHROUGH:
-			panic("not yet implemented")
+			// checked by the preprocessor to be the last
+			// statement of a clause body, so the clause
+			// block is last.
+			ss := m.LastFrame().Source.(*SwitchStmt)
+			ci := switchClauseOf(ss, m.LastBlock())
+			m.PopStmt()
+			m.PopOp() // clause continuation
+			m.runSwitchClause(ss, ci+1)
 		default:
 			panic("unknown branch op")
 		}
+	case *EmptyStmt:
+		m.PopStmt()
+	case *BlockStmt:
+		// final continuation
+		if cs.GetAttribute(ATTR_GOTO_TARGETS) != nil {
+			// the frame pops the statement.
+			m.PushFrameBasic(cs)
+			m.PushOp(OpPopFrameAndReset)
+		} else {
+			m.PopStmt()
+			m.PushOp(OpPopBlock)
+		}
+		b := NewBlock(cs, m.LastBlock())
+		m.allocateBlock(len(b.Values))
+		m.PushBlock(b)
+		// Run the body.
+		for i := len(cs.Body) - 1; 0 <= i; i-- {
+			m.PushStmt(cs.Body[i])
+			m.PushOp(OpExec)
+		}
+	case *DeferStmt:
+		// continuation
+		m.PushOp(OpDefer)
+		// evaluate args, which are saved upon defer.
+		args := cs.Call.Args
+		for i := len(args) - 1; 0 <= i; i-- {
+			m.PushExpr(args[i])
+			m.PushOp(OpEval)
+		}
+		// evaluate func
+		m.PushExpr(cs.Call.Func)
+		m.PushOp(OpEval)
 	case *DeclStmt:
 		m.PopStmt()
 		for _, d := range cs.Decls {
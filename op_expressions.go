@@ -88,7 +88,7 @@ func (m *Machine) doOpStar() {
 				refv := TypedValue{T: bt.Elt}
 				m.PushValue(refv)
 			} else {
-				m.PushValue(*pv.TypedValue)
+				m.PushValue(*fillValue(pv.TypedValue))
 			}
 		}
 	case *TypeType:
@@ -120,7 +120,7 @@ func (m *Machine) doOpRef() {
 	}
 	m.PushValue(TypedValue{
 		T: PointerType{Elt: xv.T},
-		V: PointerValue{TypedValue: xv},
+		V: xv,
 	})
 }
 
@@ -134,15 +134,7 @@ func (m *Machine) doOpTypeAssert1() {
 
 	if it, ok := t.(*InterfaceType); ok { // is interface assert
 		// assert that x implements type.
-		impl := false
-		switch cxt := xt.(type) {
-		case *InterfaceType:
-			impl = cxt.Implements(it)
-		case *DeclaredType:
-			impl = cxt.Implements(it)
-		default:
-			impl = it.IsEmptyInterface()
-		}
+		impl := implementsInterface(xt, it)
 		if !impl {
 			panic(fmt.Sprintf(
 				"%s doesn't implement %s",
@@ -179,15 +171,7 @@ func (m *Machine) doOpTypeAssert2() {
 
 	if it, ok := t.(*InterfaceType); ok { // is interface assert
 		// assert that x implements type.
-		impl := false
-		switch cxt := xt.(type) {
-		case *InterfaceType:
-			impl = cxt.Implements(it)
-		case *DeclaredType:
-			impl = cxt.Implements(it)
-		default:
-			impl = it.IsEmptyInterface()
-		}
+		impl := implementsInterface(xt, it)
 		if impl {
 			// *xv = *xv
 			*tv = untypedBool(true)
@@ -211,6 +195,17 @@ func (m *Machine) doOpTypeAssert2() {
 	}
 }
 
+func implementsInterface(xt Type, it *InterfaceType) bool {
+	switch cxt := xt.(type) {
+	case *InterfaceType:
+		return cxt.Implements(it)
+	case *DeclaredType:
+		return cxt.Implements(it)
+	default:
+		return it.IsEmptyInterface()
+	}
+}
+
 // NOTE: While struct fields are flattened, each composite
 // literal does result in field allocation, and embedded
 // composite literals thus result in the copying of fields.
@@ -286,21 +281,24 @@ func (m *Machine) doOpArrayLit() {
 	at := m.PeekValue(1 + ne).V.(TypeValue).Type
 	// bt := baseOf(at).(*ArrayType)
 	// construct array value.
+	m.allocateDefault(at)
 	av := defaultValue(at).(*ArrayValue)
 	if 0 < ne {
 		al := av.List
+		ad := av.Data
 		vs := m.PopValues(ne)
 		idx := 0
 		for i, v := range vs {
 			if kx := x.Elts[i].Key; kx != nil {
 				// XXX why convert?
-				k := kx.(*constExpr).ConvertGetInt()
-				al[k] = v
-				idx = k + 1
-			} else {
+				idx = kx.(*constExpr).ConvertGetInt()
+			}
+			if ad == nil {
 				al[idx] = v
-				idx++
+			} else {
+				ad[idx] = v.GetUint8()
 			}
+			idx++
 		}
 	}
 	// pop array type.
@@ -325,6 +323,7 @@ func (m *Machine) doOpSliceLit() {
 	// peek array type.
 	st := m.PeekValue(1 + el).V.(TypeValue).Type
 	// construct element buf slice.
+	m.allocate(listSize(nil, el))
 	es := make([]TypedValue, el)
 	for i := el - 1; 0 <= i; i-- {
 		es[i] = *m.PopValue()
@@ -351,6 +350,7 @@ func (m *Machine) doOpMapLit() {
 	mt := m.PeekValue(1 + ne*2).V.(TypeValue).Type
 	// bt := baseOf(at).(*MapType)
 	// construct new map value.
+	m.allocate(listSize(nil, ne*2))
 	mv := &MapValue{}
 	mv.MakeMap(0)
 	if 0 < ne {
@@ -387,6 +387,7 @@ func (m *Machine) doOpStructLit() {
 	xt := m.PeekValue(1 + el).V.(TypeValue).Type
 	st := baseOf(xt).(*StructType)
 	nf := len(st.Mapping)
+	m.allocateDefault(xt)
 	fs := []TypedValue(nil)
 	// NOTE includes embedded fields.
 	if el == 0 {
@@ -462,6 +463,7 @@ func (m *Machine) doOpStructLit() {
 	m.PopValue() // baseOf() is st
 	sv := &StructValue{
 		Fields: fs,
+		st:     st,
 	}
 	m.PushValue(TypedValue{
 		T: xt,
@@ -14,7 +14,7 @@ func (m *Machine) doOpInc() {
 	// as the type should be the same, and thus .V is
 	// expected to be nil.
 	if debug {
-		if lv.V != nil {
+		if lv.V != nil && lv.T != DataByteType {
 			panic("expected lv.V to be nil for primitive type for OpInc")
 		}
 	}
@@ -44,6 +44,7 @@ func (m *Machine) doOpInc() {
 	default:
 		panic("unexpected type in in operation")
 	}
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
 
 func (m *Machine) doOpDec() {
@@ -60,7 +61,7 @@ func (m *Machine) doOpDec() {
 	// as the type should be the same, and thus .V is
 	// expected to be nil.
 	if debug {
-		if lv.V != nil {
+		if lv.V != nil && lv.T != DataByteType {
 			panic("expected lv.V to be nil for primitive type for OpDec")
 		}
 	}
@@ -90,4 +91,5 @@ func (m *Machine) doOpDec() {
 	default:
 		panic("unexpected type in in operation")
 	}
+	m.Realm.DidUpdate(lv.GetBase(), nil, nil)
 }
@@ -28,6 +28,7 @@ func _() {
 	_ = x[OpPopValue-17]
 	_ = x[OpPopResults-18]
 	_ = x[OpPopBlock-19]
+	_ = x[OpPopFrameAndReset-20]
 	_ = x[OpUpos-32]
 	_ = x[OpUneg-33]
 	_ = x[OpUnot-34]
@@ -104,7 +105,7 @@ func _() {
 }
 
 const (
-	_Op_name_0 = "OpInvalidOpHaltOpNoopOpExecOpPrecallOpCallOpCallNativeBodyOpReturnOpReturnFromBlockOpReturnToBlockOpDeferOpGoOpSelectCaseOpSwitchCaseOpTypeSwitchCaseOpForLoop1OpIfCondOpPopValueOpPopResultsOpPopBlock"
+	_Op_name_0 = "OpInvalidOpHaltOpNoopOpExecOpPrecallOpCallOpCallNativeBodyOpReturnOpReturnFromBlockOpReturnToBlockOpDeferOpGoOpSelectCaseOpSwitchCaseOpTypeSwitchCaseOpForLoop1OpIfCondOpPopValueOpPopResultsOpPopBlockOpPopFrameAndReset"
 	_Op_name_1 = "OpUposOpUnegOpUnotOpUxorOpUstarOpUrecvOpLorOpLandOpEqlOpNeqOpLssOpLeqOpGtrOpGeqOpAddOpSubOpBorOpXorOpMulOpQuoOpRemOpShlOpShrOpBandOpBandn"
 	_Op_name_2 = "OpEvalOpBinary1OpIndexOpSelectorOpSliceOpStarOpRefOpTypeAssert1OpTypeAssert2OpTypeOfOpCompositeLitOpArrayLitOpSliceLitOpMapLitOpStructLitOpFuncLitOpConvert"
 	_Op_name_3 = "OpStructLitGoNativeOpCallGoNative"
@@ -115,7 +116,7 @@ const (
 )
 
 var (
-	_Op_index_0 = [...]uint8{0, 9, 15, 21, 27, 36, 42, 58, 66, 83, 98, 105, 109, 121, 133, 149, 159, 167, 177, 189, 199}
+	_Op_index_0 = [...]uint8{0, 9, 15, 21, 27, 36, 42, 58, 66, 83, 98, 105, 109, 121, 133, 149, 159, 167, 177, 189, 199, 217}
 	_Op_index_1 = [...]uint8{0, 6, 12, 18, 24, 31, 38, 43, 49, 54, 59, 64, 69, 74, 79, 84, 89, 94, 99, 104, 109, 114, 119, 124, 130, 137}
 	_Op_index_2 = [...]uint8{0, 6, 15, 22, 32, 39, 45, 50, 63, 76, 84, 98, 108, 118, 126, 137, 146, 155}
 	_Op_index_3 = [...]uint8{0, 19, 33}
@@ -126,7 +127,7 @@ var (
 
 func (i Op) String() string {
 	switch {
-	case 0 <= i && i <= 19:
+	case 0 <= i && i <= 20:
 		return _Op_name_0[_Op_index_0[i]:_Op_index_0[i+1]]
 	case 32 <= i && i <= 56:
 		i -= 32
@@ -13,10 +13,15 @@ func (m *Machine) doOpFieldType() {
 	if x.Tag != nil {
 		tag = Tag(m.PopValue().GetString())
 	}
+	owned, err := tag.ParseGnoTag()
+	if err != nil {
+		panic(err) // should have been caught by preprocessor.
+	}
 	ft := FieldType{
-		Name: n,
-		Type: t,
-		Tag:  tag,
+		Name:  n,
+		Type:  t,
+		Tag:   tag,
+		Owned: owned,
 	}
 	m.PushValue(TypedValue{
 		T: gTypeType,
@@ -59,6 +59,11 @@ type ObjectInfo struct {
 	IsNewReal bool      // if new and owner is real.
 	IsDirty   bool      // if real but modified; hash is outdated if true.
 	IsDeleted bool      // if real but no longer referenced.
+	Size      int64     // persisted size in bytes, see objectSize().
+
+	xrefs    int      // refs from other realms; not persisted.
+	released bool     // if released by its realm, see AIR-OT.
+	ownerID  ObjectID // if decoded, until owner is loaded.
 }
 
 func (oi *ObjectInfo) GetObjectInfo() *ObjectInfo {
@@ -128,3 +133,65 @@ func (oi *ObjectInfo) GetIsDeleted() bool {
 func (oi *ObjectInfo) SetIsDeleted(x bool) {
 	oi.IsDeleted = x
 }
+
+// Calls fn with a reference to each element of oo, in order.
+// Map keys are visited before their values.
+func forEachElem(oo Object, fn func(tv *TypedValue)) {
+	switch cv := oo.(type) {
+	case *ArrayValue:
+		for i := range cv.List {
+			fn(&cv.List[i])
+		}
+	case *StructValue:
+		for i := range cv.Fields {
+			fn(&cv.Fields[i])
+		}
+	case *MapValue:
+		if cv.List == nil {
+			return
+		}
+		for cur := cv.List.Head; cur != nil; cur = cur.Next {
+			fn(&cur.Key)
+			fn(&cur.Value)
+		}
+	case *Block:
+		for i := range cv.Values {
+			fn(&cv.Values[i])
+		}
+	default:
+		panic("should not happen")
+	}
+}
+
+// Calls fn for each loaded object referred to by a field of
+// oo tagged gno:"owned", if oo is a struct.
+func forEachOwnedChild(oo Object, fn func(ch Object)) {
+	sv, ok := oo.(*StructValue)
+	if !ok {
+		return
+	}
+	for i := range sv.Fields {
+		if !sv.IsOwnedField(i) {
+			continue
+		}
+		fv := &sv.Fields[i]
+		if _, ok := fv.V.(RefValue); ok {
+			continue
+		}
+		if ch := fv.GetFirstObject(); ch != nil {
+			fn(ch)
+		}
+	}
+}
+
+// Calls fn for each object directly referred to by the
+// elements of oo.  Unloaded objects are loaded first.
+// Closures of function values are not crawled, as they are
+// not persisted, see checkClosures().
+func forEachChild(oo Object, fn func(ch Object)) {
+	forEachElem(oo, func(tv *TypedValue) {
+		if ch := tv.GetFirstObject(); ch != nil {
+			fn(ch)
+		}
+	})
+}
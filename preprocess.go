@@ -162,13 +162,18 @@ func Preprocess(imp Importer, ctx BlockNode, n Node) Node {
 				}
 				// maybe type-switch def.
 				if 0 < len(ss.VarName) {
-					// if there is only 1 case, the define applies.  if
-					// there are multiple, the definition is void(?),
-					// TODO TestSwitchDefine
-					if len(n.Cases) == 1 {
-						ct := evalType(last, n.Cases[0])
-						last.Define(ss.VarName, anyValue(ct))
+					// if there is only 1 case other than nil, the
+					// var is of that type, otherwise it is of the
+					// type of the switch expression.
+					var vt Type
+					if len(n.Cases) == 1 && !isNilName(n.Cases[0]) {
+						// preprocess the case before the body.
+						n.Cases[0] = Preprocess(imp, last, n.Cases[0]).(Expr)
+						vt = evalCaseType(last, n.Cases[0])
+					} else {
+						vt = evalTypeOf(ss, ss.X)
 					}
+					last.Define(ss.VarName, anyValue(vt))
 				}
 
 			// TRANS_BLOCK -----------------------
@@ -367,6 +372,11 @@ func Preprocess(imp Importer, ctx BlockNode, n Node) Node {
 
 			// TRANS_LEAVE -----------------------
 			case *BinaryExpr:
+				// nil compared with an interface is typed with it.
+				if n.Op == EQL || n.Op == NEQ {
+					convertIfNil(last, n.Left, evalTypeOf(last, n.Right))
+					convertIfNil(last, n.Right, evalTypeOf(last, n.Left))
+				}
 				// Replace with *constExpr if const operands.
 				isShift := n.Op == SHL || n.Op == SHR
 				rt := evalTypeOf(last, n.Right)
@@ -822,6 +832,15 @@ func Preprocess(imp Importer, ctx BlockNode, n Node) Node {
 			case *FieldTypeExpr:
 				// Replace const Tag with default *constExpr.
 				convertIfConst(last, n.Tag, nil)
+				// Validate gno tag options.
+				if cx, ok := n.Tag.(*constExpr); ok {
+					tag := Tag(cx.GetString())
+					if _, err := tag.ParseGnoTag(); err != nil {
+						panic(fmt.Sprintf(
+							"invalid tag for field %s: %v",
+							n.Name, err))
+					}
+				}
 
 			// TRANS_LEAVE -----------------------
 			case *ArrayTypeExpr:
@@ -934,6 +953,15 @@ func Preprocess(imp Importer, ctx BlockNode, n Node) Node {
 					}
 				}
 
+			// TRANS_LEAVE -----------------------
+			case *BranchStmt:
+				switch n.Op {
+				case GOTO:
+					resolveGoto(ns, last, n)
+				case FALLTHROUGH:
+					checkFallthrough(ns, ftype, index)
+				}
+
 			// TRANS_LEAVE -----------------------
 			case *ForStmt:
 				// Cond consts become bool *constExprs.
@@ -983,6 +1011,39 @@ func Preprocess(imp Importer, ctx BlockNode, n Node) Node {
 				//     handled by case *AssignStmt.
 				// }
 
+			// TRANS_LEAVE -----------------------
+			case *SwitchCaseStmt:
+				ss := ns[len(ns)-1].(*SwitchStmt)
+				if ss.IsTypeSwitch {
+					// Cases become *constTypeExprs, except nil.
+					for i, cx := range n.Cases {
+						if cx, ok := cx.(*constExpr); ok && cx.IsUndefined() {
+							continue // nil
+						}
+						n.Cases[i] = constType(cx, evalCaseType(last, cx))
+					}
+				} else {
+					// Case consts become *constExprs of the
+					// type of the tag, or bool if none, except
+					// nil, which is matched with isNil() unless
+					// the tag is an interface.
+					xt := Type(BoolType)
+					if ss.X != nil {
+						xt = evalTypeOf(ss, ss.X)
+						if isUntyped(xt) {
+							convertIfConst(ss, ss.X, nil)
+							xt = evalTypeOf(ss, ss.X)
+						}
+					}
+					for _, cx := range n.Cases {
+						if cx, ok := cx.(*constExpr); ok && cx.IsUndefined() {
+							convertIfNil(last, cx, xt)
+							continue
+						}
+						convertIfConst(last, cx, xt)
+					}
+				}
+
 			// TRANS_LEAVE -----------------------
 			case *ValueDecl:
 				// evaluate value if const expr.
@@ -1008,8 +1069,10 @@ func Preprocess(imp Importer, ctx BlockNode, n Node) Node {
 				}
 				// evaluate typed value for static definition.
 				var tv TypedValue
-				if cx, ok := n.Value.(*constExpr); ok {
-					// if value is const expr; const and var decls.
+				if cx, ok := n.Value.(*constExpr); ok &&
+					t.Kind() != InterfaceKind {
+					// if value is const expr; const and var decls,
+					// except vars of interface type.
 					tv = cx.TypedValue
 				} else {
 					// for var decls of non-const expr.
@@ -1113,6 +1176,18 @@ func evalType(last BlockNode, x Expr) Type {
 	return t
 }
 
+// Like evalType, but for type switch cases, which may not
+// be types.
+func evalCaseType(last BlockNode, x Expr) Type {
+	tv := evalConst(last, x)
+	if tv.T != gTypeType {
+		panic(fmt.Sprintf(
+			"%s is not a type",
+			x.String()))
+	}
+	return tv.GetType()
+}
+
 // If t is a native type, returns the gno type.
 func gnoTypeOf(t Type) Type {
 	if nt, ok := t.(*nativeType); ok {
@@ -1204,6 +1279,140 @@ func lastDecl(ns []Node) Decl {
 	return nil
 }
 
+// Sets the depth of the block of the labeled statement of
+// goto n relative to last, and its index in the body.
+// Labels are scoped to the function, so the labeled
+// statement may follow n.
+func resolveGoto(ns []Node, last BlockNode, n *BranchStmt) {
+	fnode, _ := funcNodeOf(last)
+	// find the labeled statement and its parent.
+	var owner Node
+	var lidx int
+	var lftype TransField
+	Transcribe(fnode, func(ns []Node, ftype TransField, index int, n2 Node, stage TransStage) (Node, TransCtrl) {
+		if stage != TRANS_ENTER {
+			return n2, TRANS_CONTINUE
+		}
+		if _, ok := n2.(*FuncLitExpr); ok && n2 != fnode {
+			return n2, TRANS_BREAK
+		}
+		if s, ok := n2.(Stmt); ok && s.GetAttribute(ATTR_LABEL) == n.Label {
+			owner, lidx, lftype = ns[len(ns)-1], index, ftype
+			return n2, TRANS_EXIT
+		}
+		return n2, TRANS_CONTINUE
+	})
+	if owner == nil {
+		panic(fmt.Sprintf("label %s not defined", n.Label))
+	}
+	oi := -1
+	for i, pn := range ns {
+		if pn == owner {
+			oi = i
+		}
+	}
+	if oi == -1 {
+		panic(fmt.Sprintf("goto %s jumps into block", n.Label))
+	}
+	var body Stmts
+	switch owner := owner.(type) {
+	case *FuncDecl:
+		body = owner.Body
+	case *FuncLitExpr:
+		body = owner.Body
+	case *ForStmt:
+		body = owner.Body
+	case *RangeStmt:
+		body = owner.Body
+	case *SwitchCaseStmt:
+		body = owner.Body
+	case *IfStmt:
+		body = owner.Body
+		if lftype == TRANS_IF_ELSE {
+			body = owner.Else
+		}
+	case *BlockStmt:
+		body = owner.Body
+	default:
+		panic(fmt.Sprintf(
+			"goto %s: unexpected label in %v",
+			n.Label, reflect.TypeOf(owner)))
+	}
+	// the statement of body that is or contains n.
+	var gs Stmt = n
+	if oi+1 < len(ns) {
+		gs = ns[oi+1].(Stmt)
+	}
+	gidx := -1
+	for i, s := range body {
+		if s == gs {
+			gidx = i
+		}
+	}
+	if gidx == -1 {
+		// from the other branch of an if statement.
+		panic(fmt.Sprintf("goto %s jumps into block", n.Label))
+	}
+	// variables must not come into scope.
+	if gidx < lidx {
+		for _, s := range body[gidx+1 : lidx] {
+			if isVarDecl(s) {
+				panic(fmt.Sprintf(
+					"goto %s jumps over variable declaration",
+					n.Label))
+			}
+		}
+	}
+	depth := 0
+	for _, pn := range ns[oi+1:] {
+		if _, ok := pn.(BlockNode); ok {
+			depth++
+		}
+	}
+	n.Depth = depth
+	n.BodyIndex = lidx
+	switch owner := owner.(type) {
+	case *IfStmt:
+		// indices of the else body follow those of the body.
+		if lftype == TRANS_IF_ELSE {
+			n.BodyIndex += len(owner.Body)
+		}
+		owner.SetAttribute(ATTR_GOTO_TARGETS, true)
+	case *BlockStmt:
+		owner.SetAttribute(ATTR_GOTO_TARGETS, true)
+	}
+}
+
+func isVarDecl(s Stmt) bool {
+	switch s := s.(type) {
+	case *AssignStmt:
+		return s.Op == DEFINE
+	case *DeclStmt:
+		for _, d := range s.Decls {
+			if vd, ok := d.(*ValueDecl); ok && !vd.Const {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A fallthrough statement must be the last statement of a
+// clause of an expression switch, other than the final clause.
+func checkFallthrough(ns []Node, ftype TransField, index int) {
+	cs, ok := ns[len(ns)-1].(*SwitchCaseStmt)
+	if !ok || ftype != TRANS_SWITCHCASE_BODY || index != len(cs.Body)-1 {
+		panic("fallthrough statement out of place")
+	}
+	ss := ns[len(ns)-2].(*SwitchStmt)
+	if ss.IsTypeSwitch {
+		panic("cannot fallthrough in type switch")
+	}
+	if cs == &ss.Cases[len(ss.Cases)-1] {
+		panic("cannot fallthrough final case in switch")
+	}
+}
+
 func asValue(t Type) TypedValue {
 	return TypedValue{
 		T: gTypeType,
@@ -1218,6 +1427,12 @@ func anyValue(t Type) TypedValue {
 	}
 }
 
+// Returns true if x is the (unprocessed) name "nil".
+func isNilName(x Expr) bool {
+	nx, ok := x.(*NameExpr)
+	return ok && nx.Name == "nil"
+}
+
 func isConst(x Expr) bool {
 	_, ok := x.(*constExpr)
 	return ok
@@ -1229,7 +1444,8 @@ func convertIfConst(last BlockNode, x Expr, t Type) {
 	}
 	if t != nil && t.Kind() == InterfaceKind {
 		// TODO type check?
-		return
+		// untyped consts are converted to their default type.
+		t = nil
 	}
 	if cx, ok := x.(*constExpr); ok {
 		if isUntyped(cx.T) {
@@ -1240,6 +1456,18 @@ func convertIfConst(last BlockNode, x Expr, t Type) {
 	}
 }
 
+// If x is nil and t is an interface type, x becomes a nil of
+// type t, which only equals nil interfaces, rather than nil
+// values held by interfaces.  See isEql().
+func convertIfNil(last BlockNode, x Expr, t Type) {
+	if t == nil || t.Kind() != InterfaceKind {
+		return
+	}
+	if cx, ok := x.(*constExpr); ok && cx.IsUndefined() {
+		cx.T = t
+	}
+}
+
 // Returns any names not yet defined in expr.
 // These happen upon enter from the top, so value paths cannot be used.
 // If no names are un and x is TypeExpr, evalType(last, x) must not
@@ -0,0 +1,261 @@
+package gno
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+//----------------------------------------
+// ValueProof
+//
+// A ValueProof proves the TypedElemPreimage of an element
+// reachable from a realm's package block against the realm's
+// root hash (see Realm.GetHash()).  Each step proves an element
+// of an object against the object hash (oh) of that object,
+// which must in turn be included in the element of the next
+// step, up to the package block.
+//
+// Elements are selected by index, as they appear in
+// TypedElemPreimages(): values of blocks, fields of structs,
+// elements of arrays, and for maps, 2*i for the i'th key and
+// 2*i+1 for its value.  Pointers and slices that refer to real
+// objects are followed, so the next index selects an element of
+// the object referred to.
+
+type ValueProof struct {
+	Steps []ValueProofStep // leaf first, package block last.
+}
+
+type ValueProofStep struct {
+	Elem     TypedElemPreimage   // element at Index.
+	ElemVP   *TypedValuePreimage // if Elem.ElemType is ElemTypeOther.
+	Index    int                 // index of element in object.
+	NumElems int                 // number of elements in object.
+	Siblings []Hashlet           // merkle siblings, leaf first.
+}
+
+// Returns the value preimage of the proven element, if it is
+// not an object.  Object elements are proven by their object
+// hash in Steps[0].Elem.ValueHash.
+func (vp ValueProof) GetValuePreimage() *TypedValuePreimage {
+	if len(vp.Steps) == 0 {
+		return nil
+	}
+	return vp.Steps[0].ElemVP
+}
+
+// Produces a proof for the element at path, starting from the
+// realm's package block.  The realm must be finalized, such
+// that object hashes are up to date.
+func ProveValueAt(rlm *Realm, path []int) (ValueProof, error) {
+	if rlm.pkg == nil {
+		return ValueProof{}, errors.New("realm has no package")
+	}
+	if len(path) == 0 {
+		return ValueProof{}, errors.New("empty path")
+	}
+	var oo Object = &rlm.pkg.Block
+	steps := make([]ValueProofStep, 0, len(path))
+	for i, idx := range path {
+		if oo.GetIsDirty() {
+			return ValueProof{}, fmt.Errorf(
+				"object %v is dirty", oo.GetObjectID())
+		}
+		elems, tepz := objectElems(rlm, oo)
+		if idx < 0 || len(tepz) <= idx {
+			return ValueProof{}, fmt.Errorf(
+				"path[%d]: index %d out of range (%d elements)",
+				i, idx, len(tepz))
+		}
+		step := ValueProofStep{
+			Elem:     tepz[idx],
+			Index:    idx,
+			NumElems: len(tepz),
+			Siblings: elemsHashSiblings(tepz, idx),
+		}
+		tv := elems[idx]
+		if step.Elem.ElemType == ElemTypeOther {
+			tvp := tv.TypedValuePreimage(rlm, false)
+			step.ElemVP = &tvp
+		}
+		steps = append(steps, step)
+		if i == len(path)-1 {
+			break
+		}
+		// descend to the next object.
+		switch step.Elem.ElemType {
+		case ElemTypeOwned, ElemTypeOther:
+		default:
+			return ValueProof{}, fmt.Errorf(
+				"path[%d]: element is not owned", i)
+		}
+		co := tv.GetFirstObject()
+		if co == nil || !co.GetIsReal() {
+			return ValueProof{}, fmt.Errorf(
+				"path[%d]: element does not refer to a real object", i)
+		}
+		oo = co
+	}
+	// reverse, leaf first.
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return ValueProof{Steps: steps}, nil
+}
+
+// Verifies proof against the realm root hash.  This does not
+// require a Machine, nor access to any realm state.
+func VerifyValueProof(root ValueHash, proof ValueProof) error {
+	if len(proof.Steps) == 0 {
+		return errors.New("empty proof")
+	}
+	var oh ValueHash // of object proven by previous step.
+	for i, step := range proof.Steps {
+		// the element must include the previous object's hash.
+		if i > 0 {
+			if !elemIncludesHash(step, oh) {
+				return fmt.Errorf(
+					"step %d: element does not include object hash %X",
+					i, oh[:])
+			}
+		}
+		if step.ElemVP != nil {
+			if step.Elem.ElemType != ElemTypeOther {
+				return fmt.Errorf(
+					"step %d: unexpected value preimage", i)
+			}
+			if step.ElemVP.TypeID != step.Elem.TypeID {
+				return fmt.Errorf(
+					"step %d: value preimage type mismatch", i)
+			}
+			if step.ElemVP.ValueHash() != step.Elem.ValueHash {
+				return fmt.Errorf(
+					"step %d: value preimage hash mismatch", i)
+			}
+		}
+		eh, err := elemsHashFromSiblings(
+			step.Elem.LeafHash(), step.Index, step.NumElems, step.Siblings)
+		if err != nil {
+			return fmt.Errorf("step %d: %v", i, err)
+		}
+		// `ValuePreimage := 0x04,sz(ElemsHash)` if object.
+		// `oh(.) := lh(vp(.))`
+		vp := ValuePreimage{
+			ValType: ValTypeObject,
+			Data:    eh[:],
+		}
+		oh = ValueHash(leafHash(vp.Bytes()))
+	}
+	if oh != root {
+		return fmt.Errorf(
+			"root hash mismatch: got %X, want %X",
+			oh[:], root[:])
+	}
+	return nil
+}
+
+// Returns true if the element of step includes oh, either as
+// an owned object, or within the preimage of a pointer or slice.
+func elemIncludesHash(step ValueProofStep, oh ValueHash) bool {
+	switch step.Elem.ElemType {
+	case ElemTypeOwned:
+		return step.Elem.ValueHash == oh
+	case ElemTypeOther:
+		if step.ElemVP == nil {
+			return false
+		}
+		switch step.ElemVP.ValType {
+		case ValTypePointer, ValTypeSlice:
+			return bytes.HasSuffix(step.ElemVP.Data, oh[:])
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+}
+
+//----------------------------------------
+// misc
+
+// Returns the elements of oo and their preimages, in order.
+func objectElems(rlm *Realm, oo Object) ([]*TypedValue, []TypedElemPreimage) {
+	elems := []*TypedValue(nil)
+	forEachElem(oo, func(tv *TypedValue) {
+		elems = append(elems, fillValue(tv))
+	})
+	var tepz []TypedElemPreimage
+	switch cv := oo.(type) {
+	case *ArrayValue:
+		if cv.Data != nil {
+			return nil, nil
+		}
+		tepz = cv.TypedElemPreimages(rlm, false)
+	case *StructValue:
+		tepz = cv.TypedElemPreimages(rlm, false)
+	case *MapValue:
+		tepz = cv.TypedElemPreimages(rlm, false)
+	case *Block:
+		tepz = cv.TypedElemPreimages(rlm, false)
+	default:
+		panic("should not happen")
+	}
+	return elems, tepz
+}
+
+// Returns the merkle siblings of the idx'th leaf, as computed
+// by ElemsHashFromElements().
+func elemsHashSiblings(tepz []TypedElemPreimage, idx int) []Hashlet {
+	hz := make([]Hashlet, ((len(tepz)+1)/2)*2)
+	for i, tvp := range tepz {
+		hz[i] = tvp.LeafHash()
+	}
+	siblings := []Hashlet(nil)
+	for 1 < len(hz) {
+		if idx^1 < len(hz) {
+			siblings = append(siblings, hz[idx^1])
+		} else {
+			siblings = append(siblings, Hashlet{}) // zero
+		}
+		for i := 0; i < len(hz); i += 2 {
+			h1 := hz[i]
+			if i == len(hz)-1 {
+				hz[i/2] = innerHash(h1, Hashlet{})
+			} else {
+				hz[i/2] = innerHash(h1, hz[i+1])
+			}
+		}
+		hz = hz[:(len(hz)+1)/2]
+		idx /= 2
+	}
+	return siblings
+}
+
+// Computes the ElemsHash from a leaf hash and its siblings.
+func elemsHashFromSiblings(leaf Hashlet, idx, num int, siblings []Hashlet) (Hashlet, error) {
+	if idx < 0 || num <= idx {
+		return Hashlet{}, fmt.Errorf(
+			"index %d out of range (%d elements)", idx, num)
+	}
+	// the number of levels is determined by num.
+	levels := 0
+	for n := ((num + 1) / 2) * 2; 1 < n; n = (n + 1) / 2 {
+		levels++
+	}
+	if len(siblings) != levels {
+		return Hashlet{}, fmt.Errorf(
+			"expected %d siblings but got %d",
+			levels, len(siblings))
+	}
+	h := leaf
+	for _, sib := range siblings {
+		if idx%2 == 0 {
+			h = innerHash(h, sib)
+		} else {
+			h = innerHash(sib, h)
+		}
+		idx /= 2
+	}
+	return h, nil
+}
@@ -0,0 +1,65 @@
+package gno
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+func TestProveValueAt(t *testing.T) {
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewMemRealmer())
+	m := NewMachineWithOptions(MachineOptions{
+		Package: pv,
+		Output:  ioutil.Discard,
+	})
+	m.RunFiles(MustParseFile("main.go", `package test
+type Leaf struct {
+	Name string
+}
+type Node struct {
+	Name  string
+	Left  *Leaf
+	Right *Leaf
+}
+var count int
+var root *Node
+func main() {
+	count = 7
+	root = &Node{Name: "root", Left: &Leaf{Name: "l"}, Right: &Leaf{Name: "r"}}
+}`))
+	m.RunMain()
+	rlm := pv.GetRealm()
+	root := rlm.GetHash()
+	ridx := int(pn.GetPathForName("root").Index)
+	cidx := int(pn.GetPathForName("count").Index)
+
+	// a package variable.
+	proof, err := ProveValueAt(rlm, []int{cidx})
+	assert.Nil(t, err)
+	assert.Nil(t, VerifyValueProof(root, proof))
+	assert.Equal(t, proof.GetValuePreimage().Data,
+		[]byte{0, 0, 0, 0, 0, 0, 0, 7})
+
+	// a field of an object, through pointers.
+	proof, err = ProveValueAt(rlm, []int{ridx, 1, 0})
+	assert.Nil(t, err)
+	assert.Equal(t, len(proof.Steps), 3)
+	assert.Nil(t, VerifyValueProof(root, proof))
+	assert.Equal(t, proof.GetValuePreimage().Data, []byte("l"))
+
+	// tampered values and wrong roots do not verify.
+	proof.Steps[0].ElemVP.Data = []byte("x")
+	assert.NotNil(t, VerifyValueProof(root, proof))
+	proof, _ = ProveValueAt(rlm, []int{ridx, 1, 0})
+	assert.NotNil(t, VerifyValueProof(ValueHash{}, proof))
+	proof.Steps[0].Siblings = proof.Steps[0].Siblings[1:]
+	assert.NotNil(t, VerifyValueProof(root, proof))
+
+	// invalid paths.
+	_, err = ProveValueAt(rlm, []int{ridx, 9})
+	assert.NotNil(t, err)
+	_, err = ProveValueAt(rlm, []int{cidx, 0})
+	assert.NotNil(t, err)
+}
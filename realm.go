@@ -2,6 +2,7 @@ package gno
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -26,12 +27,28 @@ type Realm struct {
 	ID      RealmID
 	Path    string
 	Counter uint64
+	Size    int64 // total persisted size in bytes.
 
-	created []Object      // new objects attached to real.
-	updated []Object      // real objects that were modified.
-	deleted []Object      // real objects that became deleted.
-	ropslog []RealmOp     // for debugging.
-	pkg     *PackageValue // associated package if any.
+	created []Object            // new objects attached to real.
+	updated []Object            // real objects that were modified.
+	deleted []Object            // real objects that became deleted.
+	orphans []Object            // real objects that lost their owner.
+	release []Object            // deleted objects to release, see AIR-OT.
+	ropslog []RealmOp           // for debugging.
+	exowner map[Object]ObjectID // ex-owners of deleted, for ropslog.
+	pkg     *PackageValue       // associated package if any.
+	store   Store               // persistence; or nil.
+	airot   bool                // if enabled, see AIR-OT.
+	policy  StoragePolicy       // storage policy; or nil.
+	delta   int64               // size delta of last transaction.
+	gas     *GasMeter           // charged for hashing, see finalizeTransaction().
+	snaps   []*ObjectSnapshot   // of dirty objects, see SaveObjects().
+
+	cache map[ObjectID]Object // objects loaded this transaction.
+
+	undo      []func()         // undo log, see Rollback().
+	touched   map[Object]uint8 // touchedInfo|touchedValue.
+	finalized []func()         // undo log until Commit(), see Discard().
 }
 
 // Creates a blank new realm with counter 0.
@@ -48,6 +65,143 @@ func (rlm *Realm) String() string {
 		rlm.Path, rlm.Counter, rlm.ID.Bytes())
 }
 
+// Returns a copy with only the persisted fields set.
+func (rlm *Realm) copyForStore() *Realm {
+	return &Realm{
+		ID:      rlm.ID,
+		Path:    rlm.Path,
+		Counter: rlm.Counter,
+		Size:    rlm.Size,
+	}
+}
+
+func (rlm *Realm) GetStore() Store {
+	return rlm.store
+}
+
+// Objects are written through to store upon
+// FinalizeRealmTransaction().
+func (rlm *Realm) SetStore(store Store) {
+	rlm.store = store
+}
+
+// Loads the object from the store, or returns the same
+// instance if already loaded in this transaction.
+// RefValues in the loaded object are bound to rlm, so that
+// its children are in turn loaded lazily.
+func (rlm *Realm) GetObject(oid ObjectID) Object {
+	oo := rlm.findObject(oid)
+	if oo == nil {
+		panic(fmt.Sprintf(
+			"object %v not found in store",
+			oid))
+	}
+	return oo
+}
+
+// Like GetObject, but returns nil if not found.
+func (rlm *Realm) findObject(oid ObjectID) Object {
+	if oo, ok := rlm.cache[oid]; ok {
+		return oo
+	}
+	if rlm.pkg != nil && rlm.pkg.Block.ID == oid {
+		return &rlm.pkg.Block
+	}
+	if rlm.store == nil {
+		panic(fmt.Sprintf(
+			"cannot load object %v: realm has no store",
+			oid))
+	}
+	oo := rlm.store.GetObject(oid)
+	if oo == nil {
+		return nil
+	}
+	rlm.bindObject(oo)
+	return oo
+}
+
+// Returns the realm of rid, which is rlm itself or that of a
+// package known to rlm's store, or nil if unknown.
+func (rlm *Realm) getRealm(rid RealmID) *Realm {
+	if rid == rlm.ID {
+		return rlm
+	}
+	if rlm.store == nil {
+		return nil
+	}
+	path := rlm.store.GetRealmPath(rid)
+	if path == "" {
+		return nil
+	}
+	if pv := rlm.store.GetPackage(path); pv != nil {
+		return pv.GetRealm()
+	}
+	return nil
+}
+
+// Binds the RefValues of oo to rlm, as well as those of
+// objects the store had to load along with it (e.g. the
+// base of a slice), and restores the owner of oo if it was
+// decoded.
+func (rlm *Realm) bindObject(oo Object) {
+	if rlm.cache == nil {
+		rlm.cache = make(map[ObjectID]Object)
+	}
+	rlm.cache[oo.GetObjectID()] = oo
+	bindLoaded := func(bo Object) {
+		if bo.GetIsReal() && !rlm.isForeign(bo) {
+			if _, ok := rlm.cache[bo.GetObjectID()]; !ok {
+				rlm.bindObject(bo)
+			}
+		}
+	}
+	forEachElem(oo, func(tv *TypedValue) {
+		switch cv := tv.V.(type) {
+		case RefValue:
+			cv.realm = rlm
+			tv.V = cv
+		case WeakRefValue:
+			cv.realm = rlm
+			tv.V = cv
+		case PointerValue:
+			if cv.Base != nil {
+				bindLoaded(cv.GetBase())
+			} else if cv.TypedValue != nil {
+				if rv, ok := cv.TypedValue.V.(RefValue); ok {
+					rv.realm = rlm
+					cv.TypedValue.V = rv
+				}
+			}
+		case *SliceValue:
+			if cv.Base != nil {
+				bindLoaded(cv.Base)
+			}
+		}
+	})
+	oi := oo.GetObjectInfo()
+	if oi.Owner == nil && oi.ownerID.RealmID == rlm.ID &&
+		!oi.ownerID.IsZero() && oi.ownerID != oi.ID {
+		oi.Owner = rlm.GetObject(oi.ownerID)
+	}
+	oi.ownerID = ObjectID{}
+}
+
+// Panics if oo is a real object of a realm other than rlm,
+// which may be nil.  A realm's objects may only be modified
+// by code declared in that realm, e.g. through its exported
+// functions.
+func checkRealmAccess(rlm *Realm, oo Object) {
+	if oo == nil || !oo.GetIsReal() {
+		return
+	}
+	oid := oo.GetObjectID()
+	if rlm == nil || oid.RealmID != rlm.ID {
+		panic(fmt.Sprintf(
+			"cannot modify object %v of another realm from %v",
+			oid, rlm))
+	}
+}
+
 func (rlm *Realm) SetLogRealmOps(enabled bool) {
 	if enabled {
 		rlm.ropslog = make([]RealmOp, 0, 1024)
@@ -56,10 +210,50 @@ func (rlm *Realm) SetLogRealmOps(enabled bool) {
 	}
 }
 
-// object co attached to po
-func (rlm *Realm) DidAttachTo(co, po Object) {
+// Enables automatic inter-realm ownership transfer (AIR-OT)
+// for objects detached from this realm.  See ReleaseObjects().
+func (rlm *Realm) SetAutoTransfer(enabled bool) {
+	rlm.airot = enabled
+}
+
+// Called after an element of po was changed from referring
+// to xo to referring to co, either of which may be nil.  Only
+// changes to real objects are tracked; new objects are crawled
+// upon finalization.
+func (rlm *Realm) DidUpdate(po, xo, co Object) {
+	rlm.didUpdate(po, xo, co, false)
+}
+
+// Like DidUpdate, but if owned (e.g. the element is a field
+// tagged gno:"owned"), po takes ownership of co.
+func (rlm *Realm) didUpdate(po, xo, co Object, owned bool) {
+	if rlm == nil || po == nil {
+		return
+	}
+	if debug {
+		if po.GetIsDeleted() {
+			panic("cannot update a deleted object")
+		}
+	}
+	if !po.GetIsReal() {
+		return
+	}
+	rlm.MarkDirty(po)
+	if co != nil {
+		rlm.DidAttachTo(co, po, owned)
+	}
+	if xo != nil {
+		rlm.DidDetachFrom(xo, po)
+	}
+}
+
+// object co attached to real po.  If owned, po takes
+// ownership of co even if already owned by another; otherwise
+// po only owns co if co is not yet owned, and borrows it
+// otherwise.
+func (rlm *Realm) DidAttachTo(co, po Object, owned bool) {
 	if debug {
-		if po == nil {
+		if po == nil || !po.GetIsReal() {
 			panic("should not happen")
 		}
 		if co.GetIsDeleted() {
@@ -69,65 +263,57 @@ func (rlm *Realm) DidAttachTo(co, po Object) {
 			panic("cannot attach to a deleted object")
 		}
 	}
-	co.IncRefCount()
-	if !co.GetIsOwned() {
+	rlm.saveInfo(co)
+	rlm.incRefCount(co)
+	if owned {
+		// e.g. `a.foo = b.foo` where foo is tagged owned.
+		rlm.takeOwnership(co, po)
+	} else if !co.GetIsOwned() {
+		// e.g. `a.foo = &Foo{}`, or re-attaching an
+		// object whose owner had detached it.
 		co.SetOwner(po)
-		if po.GetIsReal() {
-			rlm.MarkNewReal(co)
-			rlm.MarkDirty(po)
-		}
-	} else if co.GetOwner() == po {
-		// already owned by po but inc owncount and mark dirty.
-		// e.g. `a.bar = a.foo`
-		if po.GetIsReal() {
-			rlm.MarkDirty(po)
-		}
 	} else {
-		// owner conflict allowed within a transaction.
-		// e.g. `b.foo = a.foo; a.foo = nil`
-		// conflicts will cause a panic upon transaction finalization,
-		// when owner's owned value doesn't match co's Owner.
-		ex := co.GetOwner()
-		co.SetOwner(po)
-		if ex.GetIsReal() {
-			rlm.MarkDirty(ex)
-		}
-		if po.GetIsReal() {
-			rlm.MarkDirty(po)
-		}
+		// already owned; e.g. `b.foo = a.foo`.
+		return
+	}
+	if !co.GetIsReal() {
+		rlm.MarkNewReal(co)
 	}
 }
 
-func (rlm *Realm) DidUpdate(oo Object) {
-	if debug {
-		if oo.GetIsDeleted() {
-			panic("cannot update to a deleted object")
-		}
+// Sets po as the owner of co.  A real ex-owner is marked
+// dirty, such that it gets validated upon finalization; it
+// is an error for it to still refer to co by an owned field.
+func (rlm *Realm) takeOwnership(co, po Object) {
+	xpo := co.GetOwner()
+	if xpo == po {
+		return
 	}
-	if oo.GetIsReal() {
-		fmt.Println("QQQ")
-		rlm.MarkDirty(oo)
+	if xpo != nil && xpo.GetIsReal() && !xpo.GetIsDeleted() {
+		rlm.MarkDirty(xpo)
 	}
+	rlm.saveInfo(co)
+	co.SetOwner(po)
 }
 
-func (rlm *Realm) DidDetach(oo Object) {
+// object xo detached from real po.
+func (rlm *Realm) DidDetachFrom(xo, po Object) {
 	if debug {
-		if oo.GetOwner() == nil {
-			panic("should not happen")
-		}
-		if oo.GetIsDeleted() {
-			panic("cannot delete a deleted object")
+		if xo.GetIsDeleted() {
+			panic("cannot detach a deleted object")
 		}
 	}
-	ex := oo.GetOwner()
-	oo.SetOwner(nil)
-	if ex.GetIsReal() {
-		rlm.MarkDirty(ex)
+	rlm.saveInfo(xo)
+	rc := rlm.decRefCount(xo)
+	if xo.GetOwner() == po && (rc == 0 || !refersTo(po, xo)) {
+		// xo remains ownerless until re-attached.
+		rlm.setExOwner(xo, po)
+		xo.SetOwner(nil)
+		rlm.orphans = append(rlm.orphans, xo)
 	}
-	if oo.DecRefCount() == 0 {
-		if oo.GetIsNewReal() || oo.GetIsReal() {
-			rlm.MarkDeleted(oo)
-		}
+	if rc == 0 {
+		// may yet be re-attached before finalization.
+		rlm.MarkDeleted(xo)
 	}
 }
 
@@ -136,13 +322,11 @@ func (rlm *Realm) MarkNewReal(oo Object) {
 		if oo.GetOwner() == nil {
 			panic("should not happen")
 		}
-		if !oo.GetOwner().GetIsReal() {
-			panic("should not happen")
-		}
 	}
 	if oo.GetIsNewReal() {
 		return // already marked.
 	} else {
+		rlm.saveInfo(oo)
 		oo.SetIsNewReal(true)
 	}
 	// append to .created
@@ -150,7 +334,6 @@ func (rlm *Realm) MarkNewReal(oo Object) {
 		rlm.created = make([]Object, 0, 256)
 	}
 	rlm.created = append(rlm.created, oo)
-
 }
 
 func (rlm *Realm) MarkDirty(oo Object) {
@@ -162,6 +345,7 @@ func (rlm *Realm) MarkDirty(oo Object) {
 	if oo.GetIsDirty() {
 		return // already marked.
 	} else {
+		rlm.saveInfo(oo)
 		oo.SetIsDirty(true)
 	}
 	// append to .updated
@@ -171,16 +355,14 @@ func (rlm *Realm) MarkDirty(oo Object) {
 	rlm.updated = append(rlm.updated, oo)
 }
 
+// Marks oo as a deletion candidate.  It is only deleted upon
+// finalization if its refcount is still zero by then.
 func (rlm *Realm) MarkDeleted(oo Object) {
 	if debug {
-		if !oo.GetIsNewReal() && !oo.GetIsReal() {
-			panic("should not happen")
-		}
 		if oo.GetIsDeleted() {
 			panic("should not happen")
 		}
 	}
-	oo.SetIsDeleted(true)
 	// append to .deleted
 	if rlm.deleted == nil {
 		rlm.deleted = make([]Object, 0, 256)
@@ -188,78 +370,883 @@ func (rlm *Realm) MarkDeleted(oo Object) {
 	rlm.deleted = append(rlm.deleted, oo)
 }
 
-// removes deleted objects from created & updated.
+// removes duplicates, non-real objects, and deleted objects
+// from created & updated, and revived objects from deleted.
 func (rlm *Realm) CompressMarks() {
 
 	if debug {
-		ensureUniq(rlm.created)
 		ensureUniq(rlm.updated)
-		ensureUniq(rlm.deleted)
 	}
 
 	c2 := make([]Object, 0, len(rlm.created))
 	u2 := make([]Object, 0, len(rlm.updated))
+	d2 := make([]Object, 0, len(rlm.deleted))
+	cm := make(map[Object]struct{}, len(rlm.created))
 	for _, co := range rlm.created {
-		if co.GetIsDeleted() {
-			// ignore deleted.
+		if !co.GetIsReal() || co.GetIsDeleted() {
+			// ignore abandoned & deleted.
+		} else if _, ok := cm[co]; ok {
+			// ignore duplicate.
 		} else {
+			cm[co] = struct{}{}
 			c2 = append(c2, co)
 		}
 	}
 	for _, uo := range rlm.updated {
 		if uo.GetIsDeleted() {
 			// ignore deleted.
+		} else if _, ok := cm[uo]; ok {
+			// ignore created.
 		} else {
 			u2 = append(u2, uo)
 		}
 	}
+	dm := make(map[Object]struct{}, len(rlm.deleted))
+	for _, do := range rlm.deleted {
+		if !do.GetIsDeleted() {
+			// ignore revived.
+		} else if _, ok := dm[do]; ok {
+			// ignore duplicate.
+		} else {
+			dm[do] = struct{}{}
+			d2 = append(d2, do)
+		}
+	}
 
 	rlm.created = c2
 	rlm.updated = u2
+	rlm.deleted = d2
 }
 
 //----------------------------------------
 
-// OpReturn calls this when exiting a realm transaction.
-func (rlm *Realm) FinalizeRealmTransaction() {
+// Finalizes and commits the realm transaction.  OpReturn
+// finalizes realms with finalizeTransaction() instead, see
+// Machine.finalizeRealm().  If the ownership tree is found to
+// be invalid, nothing is persisted and an OwnershipErrors is
+// returned, and likewise for objects that cannot be persisted.
+func (rlm *Realm) FinalizeRealmTransaction() error {
+	if err := rlm.finalizeTransaction(nil); err != nil {
+		return err
+	}
+	rlm.Commit()
+	return nil
+}
+
+// Like FinalizeRealmTransaction(), but the transaction is not
+// committed: its writes remain staged in the store, and it may
+// still be undone by Discard() until Commit().  Hashing is
+// charged to gm if any, which may panic with an OutOfGasError
+// before any object is saved.  Upon any such panic, the caller
+// must call Discard().
+func (rlm *Realm) finalizeTransaction(gm *GasMeter) error {
+	rlm.gas = gm
+	defer func() {
+		rlm.gas = nil
+	}()
 	// Process changes in created/updated/deleted.
-	rlm.CompressMarks()
+	rlm.AdoptReleasedObjects()
 	rlm.ProcessCreatedObjects()
-	rlm.ProcessUpdatedObjects()
 	rlm.ProcessDeletedObjects()
+	rlm.CollectCycles()
+	rlm.CompressMarks()
+	if err := rlm.ValidateObjects(); err != nil {
+		rlm.Rollback()
+		return err
+	}
+	if err := rlm.ProcessUpdatedObjects(); err != nil {
+		rlm.Rollback()
+		return err
+	}
+	if err := rlm.ProcessStorage(); err != nil {
+		rlm.Rollback()
+		return err
+	}
+	rlm.LogRealmOps()
+	rlm.SaveObjects()
+	rlm.ReleaseObjects()
+	if rlm.store != nil {
+		rlm.store.SetRealm(rlm)
+	}
+	rlm.finalized = append(rlm.finalized, rlm.undo...)
 	rlm.ClearMarks()
+	return nil
+}
+
+// Writes the finalized transactions staged in the store
+// through, after which they can no longer be undone.
+func (rlm *Realm) Commit() {
+	if rlm.store != nil {
+		rlm.store.Commit()
+	}
+	rlm.finalized = nil
 }
 
 // crawls marked created objects and finalizes ownership
-// by assigning it an ObjectID, recursively.
+// by assigning it an ObjectID, recursively.  Children
+// are attached (and their refcounts incremented) only
+// once their parent becomes real.
 func (rlm *Realm) ProcessCreatedObjects() {
-	// XXX actually create
+	// NOTE: rlm.created grows while iterating.
+	for i := 0; i < len(rlm.created); i++ {
+		co := rlm.created[i]
+		if co.GetIsReal() {
+			continue // duplicate.
+		}
+		if co.GetRefCount() == 0 {
+			// detached since; appended again if
+			// re-attached by a later created object.
+			continue
+		}
+		rlm.assignNewObjectID(co)
+		co.SetIsNewReal(false)
+		forEachChild(co, func(ch Object) {
+			rlm.saveInfo(ch)
+			rc := rlm.incRefCount(ch)
+			if ch.GetIsReal() {
+				if !ch.GetIsOwned() {
+					ch.SetOwner(co)
+				}
+				return
+			}
+			if !ch.GetIsOwned() {
+				ch.SetOwner(co)
+			}
+			if !ch.GetIsNewReal() || rc == 1 {
+				ch.SetIsNewReal(true)
+				rlm.created = append(rlm.created, ch)
+			}
+		})
+		forEachOwnedChild(co, func(ch Object) {
+			rlm.takeOwnership(ch, co)
+		})
+	}
 }
 
-// crawls marked updated objects up the ownership chain
-// to update the merkle hash.
-func (rlm *Realm) ProcessUpdatedObjects() {
-	for _, uo := range rlm.updated {
-		// XXX actually update.
-		if rlm.ropslog != nil {
-			rlm.ropslog = append(rlm.ropslog,
-				RealmOp{
-					Type:   RealmOpMod,
-					Object: uo,
-				})
-		}
+func (rlm *Realm) assignNewObjectID(oo Object) {
+	rlm.saveInfo(oo)
+	counter := rlm.Counter
+	rlm.undo = append(rlm.undo, func() {
+		rlm.Counter = counter
+	})
+	rlm.Counter++
+	oi := oo.GetObjectInfo()
+	oi.ID = ObjectID{
+		RealmID: rlm.ID,
+		Ordinal: rlm.Counter,
 	}
+	oi.released = false
 }
 
-// crawls marked deleted objects, recursively.
+// crawls deletion candidates whose refcount is still zero,
+// and detaches their children, recursively.  With AIR-OT,
+// orphans only referred to by other realms are deleted too,
+// and all deleted objects are to be released.
 func (rlm *Realm) ProcessDeletedObjects() {
-	// XXX actually delete.
+	if rlm.airot {
+		for _, oo := range rlm.orphans {
+			if oo.GetRefCount() > 0 && rlm.isReleasable(oo) {
+				rlm.deleted = append(rlm.deleted, oo)
+			}
+		}
+	}
+	// NOTE: rlm.deleted grows while iterating.
+	for i := 0; i < len(rlm.deleted); i++ {
+		do := rlm.deleted[i]
+		if do.GetIsDeleted() {
+			continue // duplicate.
+		}
+		if do.GetRefCount() > 0 && !rlm.isReleasable(do) {
+			continue // revived.
+		}
+		if !do.GetIsReal() {
+			continue // was never persisted.
+		}
+		rlm.deleteObject(do)
+	}
+}
+
+// Marks do as deleted, and detaches its children.  Children
+// whose refcount becomes zero are appended to rlm.deleted.
+func (rlm *Realm) deleteObject(do Object) {
+	rlm.saveInfo(do)
+	do.SetIsDeleted(true)
+	if rlm.airot {
+		// load all children, as they may no
+		// longer be loaded from this realm.
+		forEachElem(do, func(tv *TypedValue) {
+			fillValue(tv)
+		})
+		rlm.release = append(rlm.release, do)
+	}
+	forEachChild(do, func(ch Object) {
+		rlm.saveInfo(ch)
+		if ch.GetOwner() == do {
+			rlm.setExOwner(ch, do)
+			ch.SetOwner(nil)
+			rlm.orphans = append(rlm.orphans, ch)
+		}
+		if ch.GetIsReal() {
+			rc := rlm.decRefCount(ch)
+			if rc == 0 || rlm.isReleasable(ch) {
+				rlm.deleted = append(rlm.deleted, ch)
+			}
+		}
+	})
+}
+
+// marks the owners of created and updated objects as dirty
+// up to the root, then recomputes hashes bottom-up.  Returns
+// an error before hashing if any refers to a closure.
+func (rlm *Realm) ProcessUpdatedObjects() error {
+	// NOTE: rlm.updated grows while iterating.
+	for _, co := range rlm.created {
+		co.SetIsDirty(true)
+		rlm.markOwnersDirty(co)
+	}
+	for i := 0; i < len(rlm.updated); i++ {
+		rlm.markOwnersDirty(rlm.updated[i])
+	}
+	// deepest first, so that owners see clean children.
+	dirty := make([]Object, 0, len(rlm.created)+len(rlm.updated))
+	dirty = append(dirty, rlm.created...)
+	dirty = append(dirty, rlm.updated...)
+	depths := make(map[Object]int, len(dirty))
+	for _, oo := range dirty {
+		depths[oo] = ownerDepth(oo, len(dirty))
+	}
+	sort.SliceStable(dirty, func(i, j int) bool {
+		return depths[dirty[i]] > depths[dirty[j]]
+	})
+	for _, oo := range dirty {
+		if err := checkClosures(oo); err != nil {
+			return err
+		}
+	}
+	for _, oo := range dirty {
+		// as by objectHash(), for dirty objects.
+		vp := oo.ValuePreimage(rlm, false)
+		bz := vp.Bytes()
+		if rlm.gas != nil {
+			// charged for the bytes to hash.
+			rlm.gas.consumeBytes(rlm.gas.Table.HashByte, int64(len(bz)), "hash")
+		}
+		oo.GetObjectInfo().Hash = ValueHash(leafHash(bz))
+		oo.SetIsDirty(false)
+		if rlm.store != nil {
+			rlm.snaps = append(rlm.snaps, newObjectSnapshot(oo, vp))
+		}
+	}
+	return nil
+}
+
+// Function values are persisted along with their closure,
+// unless it is the block of a function call, which is neither
+// crawled nor persisted (see writeBlockRef()).
+func checkClosures(oo Object) (err error) {
+	forEachElem(oo, func(tv *TypedValue) {
+		if fv, ok := tv.V.(*FuncValue); ok && err == nil {
+			if !isEncodableBlock(fv.Closure) {
+				err = fmt.Errorf(
+					"cannot persist closure of function in object %v",
+					oo.GetObjectID())
+			}
+		}
+	})
+	return
+}
+
+func (rlm *Realm) markOwnersDirty(oo Object) {
+	for po := oo.GetOwner(); po != nil; po = po.GetOwner() {
+		if po.GetIsDirty() || po.GetIsDeleted() {
+			return
+		}
+		rlm.MarkDirty(po)
+	}
+}
+
+// Returns the length of the ownership chain, bounded by max
+// in case of (garbage) ownership cycles.
+func ownerDepth(oo Object, max int) int {
+	depth := 0
+	for po := oo.GetOwner(); po != nil && depth <= max; po = po.GetOwner() {
+		depth++
+	}
+	return depth
+}
+
+// writes through created, updated, and deleted objects.  The
+// writes are staged by the store until Commit(), such that
+// none are persisted if any object fails to encode.
+func (rlm *Realm) SaveObjects() {
+	if rlm.store == nil {
+		return
+	}
+	for _, co := range rlm.created {
+		rlm.store.SetObject(co)
+	}
+	for _, uo := range rlm.updated {
+		rlm.store.SetObject(uo)
+	}
+	for _, do := range rlm.deleted {
+		rlm.store.DelObject(do)
+	}
+	// past versions are kept, see RealmSnapshot.
+	for _, os := range rlm.snaps {
+		rlm.store.SetObjectSnapshot(os)
+	}
+}
+
+// Returns the realm's root hash, which is the hash of its
+// package block as of the last finalized transaction.
+func (rlm *Realm) GetHash() ValueHash {
+	if rlm.pkg == nil {
+		return ValueHash{}
+	}
+	return rlm.pkg.Block.Hash
 }
 
 func (rlm *Realm) ClearMarks() {
 	rlm.created = nil
 	rlm.updated = nil
 	rlm.deleted = nil
+	rlm.orphans = nil
+	rlm.release = nil
+	rlm.exowner = nil
+	rlm.cache = nil
+	rlm.undo = nil
+	rlm.touched = nil
+	rlm.snaps = nil
+}
+
+//----------------------------------------
+// Cycle collection
+//
+// Refcounting alone cannot reclaim cycles of real objects,
+// e.g. A->B->A, once they are detached from the rest of the
+// realm: they are left ownerless with non-zero refcounts.
+// Upon finalization, the objects reachable from such orphans
+// are checked for references from outside of them (trial
+// deletion), and those that are unreachable from the package
+// block are deleted like any other.
+
+// Deletes orphaned real objects, and the objects reachable from
+// them, that are only referred to by each other.
+func (rlm *Realm) CollectCycles() {
+	// the cluster of objects reachable from orphans,
+	// and the number of references to each from within.
+	refs := make(map[Object]int)
+	cluster := []Object(nil)
+	add := func(oo Object) {
+		if _, ok := refs[oo]; !ok {
+			refs[oo] = 0
+			cluster = append(cluster, oo)
+		}
+	}
+	for _, oo := range rlm.orphans {
+		if rlm.isCollectable(oo) && !oo.GetIsOwned() && oo.GetRefCount() > 0 {
+			add(oo)
+		}
+	}
+	if len(cluster) == 0 {
+		return
+	}
+	// NOTE: cluster grows while iterating.
+	for i := 0; i < len(cluster); i++ {
+		forEachChild(cluster[i], func(ch Object) {
+			if rlm.isCollectable(ch) {
+				add(ch)
+				refs[ch]++
+			}
+		})
+	}
+	// objects referred to from outside are live, and so are
+	// the objects reachable from them.
+	live := make(map[Object]struct{})
+	stack := []Object(nil)
+	for _, oo := range cluster {
+		if oo.GetRefCount() > refs[oo] || rlm.isPackageBlock(oo) {
+			live[oo] = struct{}{}
+			stack = append(stack, oo)
+		}
+	}
+	for len(stack) > 0 {
+		oo := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		forEachChild(oo, func(ch Object) {
+			if _, ok := refs[ch]; !ok {
+				return // not in cluster.
+			}
+			if _, ok := live[ch]; !ok {
+				live[ch] = struct{}{}
+				stack = append(stack, ch)
+			}
+		})
+	}
+	// the rest is garbage.
+	for _, oo := range cluster {
+		if _, ok := live[oo]; ok {
+			continue
+		}
+		rlm.deleted = append(rlm.deleted, oo)
+		rlm.deleteObject(oo)
+	}
+	// delete children that are no longer referenced.
+	rlm.ProcessDeletedObjects()
+}
+
+// Returns true if oo is a real object of this realm that is
+// not yet deleted.
+func (rlm *Realm) isCollectable(oo Object) bool {
+	return oo.GetIsReal() && !oo.GetIsDeleted() && !rlm.isForeign(oo)
+}
+
+func (rlm *Realm) isPackageBlock(oo Object) bool {
+	return rlm.pkg != nil && oo == Object(&rlm.pkg.Block)
+}
+
+//----------------------------------------
+// Storage
+//
+// Each realm keeps track of the total size of its persisted
+// objects, as derived from their preimages.  Upon finalization
+// the size delta of the transaction is computed, and passed
+// onto the realm's StoragePolicy if any, which may reject the
+// transaction, e.g. if the realm would exceed its budget or
+// has not paid enough rent or deposit.
+
+// Returns an error to reject a transaction that would change
+// the persisted size of rlm by delta bytes.  rlm.Size is the
+// size prior to the transaction.
+type StoragePolicy func(rlm *Realm, delta int64) error
+
+func (rlm *Realm) SetStoragePolicy(policy StoragePolicy) {
+	rlm.policy = policy
+}
+
+// Returns the size delta in bytes of the last finalized
+// transaction.
+func (rlm *Realm) GetSizeDelta() int64 {
+	return rlm.delta
+}
+
+// Returns a StoragePolicy that rejects transactions that grow
+// a realm beyond max bytes.  Transactions that shrink a realm
+// are always accepted.
+func NewStorageBudget(max int64) StoragePolicy {
+	return func(rlm *Realm, delta int64) error {
+		if delta > 0 && rlm.Size+delta > max {
+			return fmt.Errorf(
+				"storage budget exceeded: realm %s would use %d bytes (max %d)",
+				rlm.Path, rlm.Size+delta, max)
+		}
+		return nil
+	}
+}
+
+// Updates the sizes of created, updated, and deleted objects,
+// and the total size of the realm, unless rejected by the
+// realm's storage policy.  Called upon finalization once
+// hashes are up to date.
+func (rlm *Realm) ProcessStorage() error {
+	delta := int64(0)
+	for _, co := range rlm.created {
+		delta += rlm.resize(co, objectSize(rlm, co))
+	}
+	for _, uo := range rlm.updated {
+		delta += rlm.resize(uo, objectSize(rlm, uo))
+	}
+	for _, do := range rlm.deleted {
+		delta += rlm.resize(do, 0)
+	}
+	if rlm.policy != nil {
+		if err := rlm.policy(rlm, delta); err != nil {
+			return err
+		}
+	}
+	size := rlm.Size
+	rlm.undo = append(rlm.undo, func() {
+		rlm.Size = size
+	})
+	rlm.Size += delta
+	rlm.delta = delta
+	return nil
+}
+
+// Sets the size of oo, and returns the difference.
+func (rlm *Realm) resize(oo Object, size int64) int64 {
+	rlm.saveInfo(oo)
+	oi := oo.GetObjectInfo()
+	diff := size - oi.Size
+	oi.Size = size
+	return diff
+}
+
+// Returns the number of bytes persisted for oo, which is the
+// size of its value preimage along with the preimages of its
+// elements.  Owned objects are accounted for separately.
+func objectSize(rlm *Realm, oo Object) int64 {
+	vp := oo.ValuePreimage(rlm, false)
+	size := int64(len(vp.Bytes()))
+	elems, tepz := objectElems(rlm, oo)
+	for i, tep := range tepz {
+		size += int64(len(tep.Bytes()))
+		if tep.ElemType == ElemTypeOther {
+			tvp := elems[i].TypedValuePreimage(rlm, false)
+			size += int64(len(tvp.Bytes()))
+		}
+	}
+	return size
+}
+
+//----------------------------------------
+// AIR-OT
+//
+// Auto-Inter-Realm-Ownership-Transfer: if enabled for a realm,
+// objects detached from it are released rather than deleted
+// upon finalization.  Released objects are deleted from the
+// realm's store as usual, but they become new objects again,
+// such that another realm that attaches them (before or after
+// they were detached, within the same transaction) re-homes
+// them under its own ObjectIDs, along with their owned
+// descendants.  Released objects that are not attached
+// elsewhere are simply garbage.
+//
+// To tell apart objects still referred to by the releasing
+// realm from those only referred to by other realms, refcounts
+// from other realms are also tracked separately.
+// XXX these are not persisted, so an object that was attached
+// by another realm in a prior transaction cannot be released.
+
+// Like oo.IncRefCount(), but also tracks references to real
+// objects of other realms.
+func (rlm *Realm) incRefCount(oo Object) int {
+	if rlm.isForeign(oo) {
+		oo.GetObjectInfo().xrefs++
+	}
+	return oo.IncRefCount()
+}
+
+// Like oo.DecRefCount(), but also tracks references to real
+// objects of other realms.
+func (rlm *Realm) decRefCount(oo Object) int {
+	if rlm.isForeign(oo) {
+		oo.GetObjectInfo().xrefs--
+	}
+	return oo.DecRefCount()
+}
+
+// Returns true if oo is a real object of another realm.
+func (rlm *Realm) isForeign(oo Object) bool {
+	return oo.GetIsReal() && oo.GetObjectID().RealmID != rlm.ID
+}
+
+// Returns true if oo may be released, that is, if it is an
+// ownerless object of this realm no longer referred to by it.
+func (rlm *Realm) isReleasable(oo Object) bool {
+	return rlm.airot && !rlm.isForeign(oo) && !oo.GetIsOwned() &&
+		oo.GetRefCount() == oo.GetObjectInfo().xrefs
+}
+
+// Turns deleted objects to be released into new objects, once
+// they were deleted from the store.  Their refcounts only
+// count references from (real objects of) other realms.
+func (rlm *Realm) ReleaseObjects() {
+	for _, do := range rlm.release {
+		oi := do.GetObjectInfo()
+		oi.ID = ObjectID{}
+		oi.Hash = ValueHash{}
+		oi.Owner = nil
+		oi.IsNewReal = false
+		oi.IsDirty = false
+		oi.IsDeleted = false
+		oi.Size = 0
+		oi.xrefs = 0
+		oi.released = true
+	}
+}
+
+// Released objects that were attached to real objects of this
+// realm before they were released are adopted by the first
+// such object, and become new reals of this realm.  Objects
+// attached after they were released are already new reals.
+func (rlm *Realm) AdoptReleasedObjects() {
+	for _, uo := range rlm.updated {
+		forEachLoadedChild(uo, func(ch Object) {
+			oi := ch.GetObjectInfo()
+			if !oi.released || ch.GetIsOwned() || ch.GetIsNewReal() {
+				return
+			}
+			rlm.saveInfo(ch)
+			ch.SetOwner(uo)
+			rlm.MarkNewReal(ch)
+		})
+	}
+}
+
+//----------------------------------------
+// Rollback
+//
+// Before a real object is first modified in a transaction,
+// its ObjectInfo and/or its elements are saved onto an undo
+// log, such that all changes can be discarded if the
+// transaction fails (e.g. upon panic, or if the ownership tree
+// is invalid upon finalization).  Objects that were not yet
+// real are simply abandoned.
+
+const (
+	touchedInfo  uint8 = 0x01
+	touchedValue uint8 = 0x02
+)
+
+// Returns true if the flag for oo was already set,
+// and sets it otherwise.
+func (rlm *Realm) touch(oo Object, flag uint8) bool {
+	if rlm.touched == nil {
+		rlm.touched = make(map[Object]uint8)
+	}
+	if rlm.touched[oo]&flag != 0 {
+		return true
+	}
+	rlm.touched[oo] |= flag
+	return false
+}
+
+// Saves the ObjectInfo of oo, if not yet saved.
+func (rlm *Realm) saveInfo(oo Object) {
+	if rlm.touch(oo, touchedInfo) {
+		return
+	}
+	oi := oo.GetObjectInfo()
+	saved := *oi
+	rlm.undo = append(rlm.undo, func() {
+		*oi = saved
+	})
+}
+
+// Called before the elements of oo are modified.  If oo is
+// real, its elements and ObjectInfo are saved such that they
+// may be restored by Rollback().  It is safe to call on a nil
+// realm or object.
+func (rlm *Realm) WillUpdate(oo Object) {
+	if rlm == nil || oo == nil || !oo.GetIsReal() {
+		return
+	}
+	rlm.saveInfo(oo)
+	if rlm.touch(oo, touchedValue) {
+		return
+	}
+	switch cv := oo.(type) {
+	case *ArrayValue:
+		if cv.Data == nil {
+			list := make([]TypedValue, len(cv.List))
+			copy(list, cv.List)
+			rlm.undo = append(rlm.undo, func() {
+				copy(cv.List, list)
+			})
+		} else {
+			data := make([]byte, len(cv.Data))
+			copy(data, cv.Data)
+			rlm.undo = append(rlm.undo, func() {
+				copy(cv.Data, data)
+			})
+		}
+	case *StructValue:
+		fields := make([]TypedValue, len(cv.Fields))
+		copy(fields, cv.Fields)
+		rlm.undo = append(rlm.undo, func() {
+			copy(cv.Fields, fields)
+		})
+	case *MapValue:
+		list, vmap := cv.copyList()
+		rlm.undo = append(rlm.undo, func() {
+			cv.List, cv.vmap = list, vmap
+		})
+	case *Block:
+		values := make([]TypedValue, len(cv.Values))
+		copy(values, cv.Values)
+		rlm.undo = append(rlm.undo, func() {
+			copy(cv.Values, values)
+		})
+	default:
+		panic("should not happen")
+	}
+}
+
+// Restores all objects saved since the last finalized
+// transaction, in reverse order, and discards all marks.
+func (rlm *Realm) Rollback() {
+	for i := len(rlm.undo) - 1; 0 <= i; i-- {
+		rlm.undo[i]()
+	}
+	rlm.ClearMarks()
+}
+
+// Like Rollback(), but also restores all objects saved by
+// transactions finalized since the last Commit(), and drops
+// the writes staged in the store, if any.
+func (rlm *Realm) Discard() {
+	rlm.Rollback()
+	for i := len(rlm.finalized) - 1; 0 <= i; i-- {
+		rlm.finalized[i]()
+	}
+	rlm.finalized = nil
+	if rlm.store != nil {
+		rlm.store.Discard()
+	}
+}
+
+//----------------------------------------
+// Validation
+//
+// Objects may be temporarily unaccounted for during a
+// transaction, but upon finalization the ownership tree must
+// be consistent.  Only objects marked during the transaction
+// are checked.
+
+type OwnershipErrorType uint8
+
+const (
+	UnaccountedObject OwnershipErrorType = iota // real, ownerless, yet referenced.
+	OwnerConflict                               // owner doesn't refer to object, or owned by another.
+	RefCountMismatch                            // referenced but refcount is 0.
+	DanglingReference                           // refers to a deleted object.
+)
+
+func (oet OwnershipErrorType) String() string {
+	switch oet {
+	case UnaccountedObject:
+		return "unaccounted object"
+	case OwnerConflict:
+		return "owner conflict"
+	case RefCountMismatch:
+		return "refcount mismatch"
+	case DanglingReference:
+		return "dangling reference"
+	default:
+		panic("should not happen")
+	}
+}
+
+type OwnershipError struct {
+	Type     OwnershipErrorType
+	ObjectID ObjectID // of offending object.
+	OwnerID  ObjectID // of owner or referrer, if any.
+	RefCount int
+}
+
+func (oe OwnershipError) Error() string {
+	return fmt.Sprintf("%v: object %v (owner/referrer %v, refcount %d)",
+		oe.Type, oe.ObjectID, oe.OwnerID, oe.RefCount)
+}
+
+type OwnershipErrors []OwnershipError
+
+func (oes OwnershipErrors) Error() string {
+	ss := make([]string, len(oes))
+	for i, oe := range oes {
+		ss[i] = oe.Error()
+	}
+	return "invalid ownership tree: " + strings.Join(ss, "; ")
+}
+
+// Checks orphaned, created, and updated objects.
+// Returns nil or OwnershipErrors.
+func (rlm *Realm) ValidateObjects() error {
+	var errs OwnershipErrors
+	om := make(map[Object]struct{}, len(rlm.orphans))
+	for _, oo := range rlm.orphans {
+		if _, ok := om[oo]; ok {
+			continue
+		}
+		om[oo] = struct{}{}
+		if oo.GetIsDeleted() || oo.GetIsOwned() {
+			continue // deleted or re-attached.
+		}
+		if oo.GetRefCount() > 0 {
+			errs = append(errs, OwnershipError{
+				Type:     UnaccountedObject,
+				ObjectID: oo.GetObjectID(),
+				RefCount: oo.GetRefCount(),
+			})
+		}
+	}
+	check := func(oo Object) {
+		if po := oo.GetOwner(); po != nil && !refersTo(po, oo) {
+			errs = append(errs, OwnershipError{
+				Type:     OwnerConflict,
+				ObjectID: oo.GetObjectID(),
+				OwnerID:  po.GetObjectID(),
+				RefCount: oo.GetRefCount(),
+			})
+		}
+		forEachOwnedChild(oo, func(ch Object) {
+			if ch.GetOwner() != oo {
+				errs = append(errs, OwnershipError{
+					Type:     OwnerConflict,
+					ObjectID: ch.GetObjectID(),
+					OwnerID:  oo.GetObjectID(),
+					RefCount: ch.GetRefCount(),
+				})
+			}
+		})
+		forEachLoadedChild(oo, func(ch Object) {
+			if ch.GetIsDeleted() {
+				errs = append(errs, OwnershipError{
+					Type:     DanglingReference,
+					ObjectID: ch.GetObjectID(),
+					OwnerID:  oo.GetObjectID(),
+				})
+			} else if ch.GetRefCount() <= 0 {
+				errs = append(errs, OwnershipError{
+					Type:     RefCountMismatch,
+					ObjectID: ch.GetObjectID(),
+					OwnerID:  oo.GetObjectID(),
+					RefCount: ch.GetRefCount(),
+				})
+			}
+		})
+	}
+	for _, co := range rlm.created {
+		check(co)
+	}
+	for _, uo := range rlm.updated {
+		check(uo)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Returns true if any element of po refers to oo.
+// Unloaded elements are compared by ObjectID.
+func refersTo(po, oo Object) bool {
+	found := false
+	oid := oo.GetObjectID()
+	forEachElem(po, func(tv *TypedValue) {
+		if rv, ok := tv.V.(RefValue); ok {
+			if !oid.IsZero() && rv.ObjectID == oid {
+				found = true
+			}
+		} else if tv.GetFirstObject() == oo {
+			found = true
+		}
+	})
+	return found
+}
+
+// Like forEachChild, but skips unloaded objects, which
+// cannot have been modified.
+func forEachLoadedChild(oo Object, fn func(ch Object)) {
+	forEachElem(oo, func(tv *TypedValue) {
+		if _, ok := tv.V.(RefValue); ok {
+			return
+		}
+		if ch := tv.GetFirstObject(); ch != nil {
+			fn(ch)
+		}
+	})
 }
 
 //----------------------------------------
@@ -301,6 +1288,57 @@ const (
 type RealmOp struct {
 	Type RealmOpType
 	Object
+	OwnerID  ObjectID      // owner, or ex-owner if deleted.
+	Preimage ValuePreimage // as of finalization.
+}
+
+// Makes a RealmOp for oo as of now.
+func (rlm *Realm) newRealmOp(rt RealmOpType, oo Object) RealmOp {
+	var oid ObjectID
+	if rt == RealmOpDel {
+		oid = rlm.exowner[oo]
+	} else if po := oo.GetOwner(); po != nil {
+		oid = po.GetObjectID()
+	}
+	// NOTE: assumes *Realm is no longer needed.
+	return RealmOp{
+		Type:     rt,
+		Object:   oo,
+		OwnerID:  oid,
+		Preimage: oo.ValuePreimage(nil, true),
+	}
+}
+
+// Appends created, updated, and deleted objects to the log of
+// realm ops, if enabled.  Called upon finalization once hashes
+// are up to date.
+func (rlm *Realm) LogRealmOps() {
+	if rlm.ropslog == nil {
+		return
+	}
+	for _, co := range rlm.created {
+		rlm.ropslog = append(rlm.ropslog,
+			rlm.newRealmOp(RealmOpNew, co))
+	}
+	for _, uo := range rlm.updated {
+		rlm.ropslog = append(rlm.ropslog,
+			rlm.newRealmOp(RealmOpMod, uo))
+	}
+	for _, do := range rlm.deleted {
+		rlm.ropslog = append(rlm.ropslog,
+			rlm.newRealmOp(RealmOpDel, do))
+	}
+}
+
+// Remembers the ex-owner of oo, if logging realm ops.
+func (rlm *Realm) setExOwner(oo, po Object) {
+	if rlm.ropslog == nil {
+		return
+	}
+	if rlm.exowner == nil {
+		rlm.exowner = make(map[Object]ObjectID)
+	}
+	rlm.exowner[oo] = po.GetObjectID()
 }
 
 // used by the tests/file_test system to check
@@ -308,15 +1346,19 @@ type RealmOp struct {
 func (rop RealmOp) String() string {
 	switch rop.Type {
 	case RealmOpNew:
-		return "NOTYETIMPL"
+		return fmt.Sprintf("c[%X]o[%X]=%v",
+			rop.Object.GetObjectID(),
+			rop.OwnerID,
+			rop.Preimage.String())
 	case RealmOpMod:
-		// NOTE: assumes *Realm is no longer needed.
-		var rlm *Realm = nil
 		return fmt.Sprintf("u[%X]=%v",
 			rop.Object.GetObjectID(),
-			rop.Object.ValuePreimage(rlm, true).String())
+			rop.Preimage.String())
 	case RealmOpDel:
-		return "NOTYETIMPL"
+		return fmt.Sprintf("d[%X]o[%X]=%v",
+			rop.Object.GetObjectID(),
+			rop.OwnerID,
+			rop.Preimage.String())
 	default:
 		panic("should not happen")
 	}
@@ -349,3 +1391,27 @@ func NewMemRealmer() Realmer {
 		}
 	})
 }
+
+//----------------------------------------
+// StoreRealmer
+
+// Like MemRealmer, but realms are loaded from and
+// persisted to store.
+func NewStoreRealmer(store Store) Realmer {
+	rlms := make(map[string]*Realm)
+	return Realmer(func(pkgPath string) *Realm {
+		if !IsRealmPath(pkgPath) {
+			panic("should not happen")
+		}
+		if rlm, ok := rlms[pkgPath]; ok {
+			return rlm
+		}
+		rlm := store.GetRealm(pkgPath)
+		if rlm == nil {
+			rlm = NewRealm(pkgPath)
+		}
+		rlm.SetStore(store)
+		rlms[pkgPath] = rlm
+		return rlm
+	})
+}
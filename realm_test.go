@@ -0,0 +1,845 @@
+package gno
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+func TestRealmFinalizeHashes(t *testing.T) {
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewMemRealmer())
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+type Leaf struct {
+	Name string
+}
+type Node struct {
+	Name  string
+	Left  *Leaf
+	Right *Leaf
+}
+var root *Node
+func main() {
+	root = &Node{Name: "root", Left: &Leaf{Name: "l"}, Right: &Leaf{Name: "r"}}
+}
+func renameLeft() {
+	root.Left.Name = "l2"
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+
+	// new objects were assigned ids and hashes.
+	assert.Equal(t, rlm.Counter, uint64(3))
+	rv := pv.Block.Values[pn.GetPathForName("root").Index]
+	rootv := rv.V.(PointerValue).TypedValue.V.(*StructValue)
+	leftv := rootv.Fields[1].V.(PointerValue).TypedValue.V.(*StructValue)
+	rightv := rootv.Fields[2].V.(PointerValue).TypedValue.V.(*StructValue)
+	for _, sv := range []*StructValue{rootv, leftv, rightv} {
+		assert.True(t, sv.GetIsReal())
+		assert.False(t, sv.GetIsDirty())
+		assert.Equal(t, sv.GetRefCount(), 1)
+		assert.NotEqual(t, sv.Hash, ValueHash{})
+	}
+	assert.Equal(t, leftv.GetOwner(), Object(rootv))
+	assert.Equal(t, rootv.GetOwner(), Object(&pv.Block))
+	hash1 := rlm.GetHash()
+	assert.NotEqual(t, hash1, ValueHash{})
+
+	// only the dirty path to the root is rehashed.
+	lhash1, rhash1, rthash1 := leftv.Hash, rightv.Hash, rootv.Hash
+	m.RunStatement(S(Call(X("renameLeft"))))
+	assert.Equal(t, rlm.Counter, uint64(3))
+	assert.NotEqual(t, leftv.Hash, lhash1)
+	assert.NotEqual(t, rootv.Hash, rthash1)
+	assert.Equal(t, rightv.Hash, rhash1)
+	assert.NotEqual(t, rlm.GetHash(), hash1)
+}
+
+func TestRealmRecursiveType(t *testing.T) {
+	db := NewMemDB()
+	_, err := AddPackage(NewKVStore(db), "gno.land/r/list",
+		MustParseFile("list.go", `package list
+type Node struct {
+	Name string
+	Next *Node
+}
+var head *Node
+func init() {
+	head = &Node{Name: "a"}
+}
+func Push(name string) {
+	head = &Node{Name: name, Next: head}
+}
+func Names() string {
+	s := ""
+	for n := head; n != nil; n = n.Next {
+		s += n.Name
+	}
+	return s
+}`))
+	assert.Nil(t, err)
+
+	// the list is persisted and loaded upon restart.
+	for _, name := range []string{"b", "c"} {
+		store := NewKVStore(db)
+		_, r := runMain(MachineOptions{Importer: store.GetPackage},
+			`package main
+import "gno.land/r/list"
+func main() {
+	list.Push("`+name+`")
+}`)
+		assert.Nil(t, r)
+	}
+	store := NewKVStore(db)
+	buf := new(bytes.Buffer)
+	_, r := runMain(MachineOptions{
+		Output:   buf,
+		Importer: store.GetPackage,
+	}, `package main
+import "gno.land/r/list"
+func main() {
+	println(list.Names())
+}`)
+	assert.Nil(t, r)
+	assert.Equal(t, buf.String(), "cba\n")
+}
+
+func TestRealmUnaccountedObject(t *testing.T) {
+	store := NewMemStore()
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+type Leaf struct {
+	Name string
+}
+type Node struct {
+	A *Leaf
+	B *Leaf
+}
+var root *Node
+var other *Node
+func main() {
+	l := &Leaf{Name: "l"}
+	root = &Node{A: l}
+	other = &Node{A: l}
+}
+func move() {
+	l := root.A
+	root.A = nil
+	other.B = l
+}
+func detach() {
+	root.A = other.A
+	other.A = nil
+	other.B = nil
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+	assert.Equal(t, rlm.Counter, uint64(3))
+
+	// detached from owner but re-attached within the transaction.
+	m.RunStatement(S(Call(X("move"))))
+	hash := rlm.GetHash()
+
+	// detached from owner while still referenced by root.A.
+	err, _ := catchPanic(func() {
+		m.RunStatement(S(Call(X("detach"))))
+	}).(error)
+	oes, ok := err.(OwnershipErrors)
+	assert.True(t, ok)
+	assert.Equal(t, len(oes), 1)
+	assert.Equal(t, oes[0].Type, UnaccountedObject)
+	assert.Equal(t, oes[0].RefCount, 1)
+	// nothing was persisted.
+	assert.Equal(t, rlm.GetHash(), hash)
+	assert.Equal(t, store.GetRealm("gno.land/r/test").Counter, uint64(3))
+}
+
+func TestRealmOwnedFieldTag(t *testing.T) {
+	owned, err := Tag(`json:"x" gno:"owned"`).ParseGnoTag()
+	assert.Nil(t, err)
+	assert.True(t, owned)
+	owned, err = Tag(`gno:owned`).ParseGnoTag()
+	assert.Nil(t, err)
+	assert.True(t, owned)
+	owned, err = Tag(`json:"owned"`).ParseGnoTag()
+	assert.Nil(t, err)
+	assert.False(t, owned)
+	_, err = Tag(`gno:"bogus"`).ParseGnoTag()
+	assert.NotNil(t, err)
+
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewMemRealmer())
+	m := NewMachineWithOptions(MachineOptions{
+		Package: pv,
+		Output:  ioutil.Discard,
+	})
+	m.RunFiles(MustParseFile("main.go", `package test
+type Node struct {
+	Owned map[string]int `+"`gno:\"owned\"`"+`
+	Other map[string]int
+}
+var a *Node
+var b *Node
+func main() {
+	x := map[string]int{"x": 1}
+	b = &Node{Owned: map[string]int{}, Other: x}
+	a = &Node{Owned: x}
+}
+func steal() {
+	b.Owned = a.Owned
+}`))
+	m.RunMain()
+
+	// the tagged field takes ownership from b.
+	av := pv.Block.Values[pn.GetPathForName("a").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	bv := pv.Block.Values[pn.GetPathForName("b").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	xv := av.Fields[0].V.(*MapValue)
+	assert.Equal(t, xv.GetRefCount(), 2)
+	assert.Equal(t, xv.GetOwner(), Object(av))
+	atepz := av.TypedElemPreimages(nil, false)
+	assert.Equal(t, atepz[0].ElemType, ElemTypeOwned)
+	assert.Equal(t, atepz[0].ValueHash, xv.Hash)
+	btepz := bv.TypedElemPreimages(nil, false)
+	assert.Equal(t, btepz[1].ElemType, ElemTypeBorrowed)
+
+	// an object cannot be owned by two owned fields.
+	err, _ = catchPanic(func() {
+		m.RunStatement(S(Call(X("steal"))))
+	}).(error)
+	oes, ok := err.(OwnershipErrors)
+	assert.True(t, ok)
+	assert.Equal(t, len(oes), 1)
+	assert.Equal(t, oes[0].Type, OwnerConflict)
+	assert.Equal(t, oes[0].ObjectID, xv.GetObjectID())
+	assert.Equal(t, oes[0].OwnerID, av.GetObjectID())
+
+	// unknown gno tag options are rejected.
+	assert.Panics(t, func() {
+		m.RunFiles(MustParseFile("bad.go", `package test
+type Bad struct {
+	X int `+"`gno:\"bogus\"`"+`
+}`))
+	})
+}
+
+func TestRealmRollback(t *testing.T) {
+	store := NewMemStore()
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+type Leaf struct {
+	Name string
+}
+type Node struct {
+	Name string
+	M    map[string]int
+	L    *Leaf
+	A    [2]int
+}
+var root *Node
+func main() {
+	root = &Node{Name: "root", M: map[string]int{"a": 1}, L: &Leaf{Name: "l"}, A: [2]int{5, 6}}
+}
+func fail() {
+	root.Name = "changed"
+	root.M["a"] += 1
+	root.M["b"] = 2
+	root.L.Name = "changed"
+	root.L = &Leaf{Name: "new"}
+	root.A[0]++
+	var s []int
+	s[1] = 0 // panics
+}
+func rename() {
+	root.Name = "renamed"
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+	counter := rlm.Counter
+	hash := rlm.GetHash()
+	rootv := pv.Block.Values[pn.GetPathForName("root").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	leafv := rootv.Fields[2].V.(PointerValue).TypedValue.V.(*StructValue)
+	rootInfo, leafInfo := rootv.ObjectInfo, leafv.ObjectInfo
+
+	assert.Panics(t, func() {
+		m.RunStatement(S(Call(X("fail"))))
+	})
+	// all mutations were discarded.
+	assert.Equal(t, string(rootv.Fields[0].GetString()), "root")
+	mv := rootv.Fields[1].V.(*MapValue)
+	assert.Equal(t, mv.GetLength(), 1)
+	val, _ := mv.GetValueForKey(&TypedValue{T: StringType, V: StringValue("a")})
+	assert.Equal(t, val.GetInt(), 1)
+	assert.Equal(t, rootv.Fields[2].V.(PointerValue).TypedValue.V, Value(leafv))
+	assert.Equal(t, string(leafv.Fields[0].GetString()), "l")
+	assert.Equal(t, rootv.Fields[3].V.(*ArrayValue).List[0].GetInt(), 5)
+	assert.Equal(t, rootv.ObjectInfo, rootInfo)
+	assert.Equal(t, leafv.ObjectInfo, leafInfo)
+	assert.Equal(t, rlm.Counter, counter)
+	assert.Equal(t, rlm.GetHash(), hash)
+	assert.Equal(t, len(rlm.created)+len(rlm.updated)+len(rlm.deleted), 0)
+
+	// the realm remains usable.
+	m.RunStatement(S(Call(X("rename"))))
+	assert.Equal(t, string(rootv.Fields[0].GetString()), "renamed")
+	assert.Equal(t, rlm.Counter, counter)
+	assert.NotEqual(t, rlm.GetHash(), hash)
+	assert.Equal(t, store.GetRealm("gno.land/r/test").Counter, counter)
+}
+
+func TestRealmOpsLog(t *testing.T) {
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewMemRealmer())
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+type Leaf struct {
+	Name string
+}
+type Node struct {
+	Left *Leaf
+}
+var root *Node
+func main() {
+	root = &Node{Left: &Leaf{Name: "l"}}
+}
+func replace() {
+	root.Left = &Leaf{Name: "l2"}
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+	rootv := pv.Block.Values[pn.GetPathForName("root").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	leftv := rootv.Fields[0].V.(PointerValue).TypedValue.V.(*StructValue)
+
+	rlm.SetLogRealmOps(true)
+	m.RunStatement(S(Call(X("replace"))))
+	newv := rootv.Fields[0].V.(PointerValue).TypedValue.V.(*StructValue)
+	rops := rlm.ropslog
+	assert.Equal(t, len(rops), 4)
+	// created leaf, owned by root.
+	assert.Equal(t, rops[0].Type, RealmOpNew)
+	assert.Equal(t, rops[0].Object, Object(newv))
+	assert.Equal(t, rops[0].OwnerID, rootv.GetObjectID())
+	assert.Equal(t, rops[0].Preimage.String(), newv.ValuePreimage(nil, true).String())
+	// updated root, and its owner the package block.
+	assert.Equal(t, rops[1].Type, RealmOpMod)
+	assert.Equal(t, rops[1].Object, Object(rootv))
+	assert.Equal(t, rops[2].Type, RealmOpMod)
+	assert.Equal(t, rops[2].Object, Object(&pv.Block))
+	// deleted leaf, formerly owned by root.
+	assert.Equal(t, rops[3].Type, RealmOpDel)
+	assert.Equal(t, rops[3].Object, Object(leftv))
+	assert.Equal(t, rops[3].OwnerID, rootv.GetObjectID())
+	assert.True(t, leftv.GetIsDeleted())
+	assert.Contains(t, rlm.SprintRealmOps(), "\nd[")
+}
+
+func TestRealmCrossCall(t *testing.T) {
+	var bobv *PackageValue
+	importer := func(pkgPath string) *PackageValue {
+		switch pkgPath {
+		case "gno.land/r/bob":
+			return bobv
+		default:
+			return nil
+		}
+	}
+
+	// bob is a realm with a counter.
+	bobn := NewPackageNode("bob", "gno.land/r/bob", &FileSet{})
+	bobv = bobn.NewPackage(NewMemRealmer())
+	mb := NewMachineWithOptions(MachineOptions{
+		Package:  bobv,
+		Output:   ioutil.Discard,
+		Importer: importer,
+	})
+	mb.RunFiles(MustParseFile("bob.go", `package bob
+import "std"
+type Thing struct {
+	N int
+}
+var Count int
+var Caller string
+var CallerPkg string
+var Obj = &Thing{}
+type Outer struct {
+	P *Thing
+}
+var Out = &Outer{P: &Thing{}}
+func Inc() {
+	Count++
+	Caller = std.CallerRealmPath()
+	CallerPkg = std.CallerPkgPath()
+}`))
+	bobrlm := bobv.GetRealm()
+
+	// alice calls into bob.
+	alicen := NewPackageNode("alice", "gno.land/r/alice", &FileSet{})
+	alicev := alicen.NewPackage(NewMemRealmer())
+	m := NewMachineWithOptions(MachineOptions{
+		Package:  alicev,
+		Output:   ioutil.Discard,
+		Importer: importer,
+	})
+	m.RunFiles(MustParseFile("alice.go", `package alice
+import "gno.land/r/bob"
+var x int
+func main() {
+	x = 1
+	bob.Inc()
+	x = bob.Count
+}
+func setCount() {
+	bob.Count = 100
+}
+func setObj() {
+	p := bob.Obj
+	p.N = 100
+}
+func setDeref() {
+	p := bob.Obj
+	*p = bob.Thing{N: 7}
+}
+func setDerefField() {
+	o := bob.Out
+	*o.P = bob.Thing{N: 7}
+}`))
+	alicerlm := alicev.GetRealm()
+	assert.NotEqual(t, alicerlm, bobrlm)
+	m.RunMain()
+
+	// bob's transaction was finalized upon return to alice.
+	assert.Equal(t, m.Realm, alicerlm)
+	countv := bobv.Block.Values[bobn.GetPathForName("Count").Index]
+	assert.Equal(t, countv.GetInt(), 1)
+	callerv := bobv.Block.Values[bobn.GetPathForName("Caller").Index]
+	assert.Equal(t, string(callerv.GetString()), "gno.land/r/alice")
+	cpkgv := bobv.Block.Values[bobn.GetPathForName("CallerPkg").Index]
+	assert.Equal(t, string(cpkgv.GetString()), "gno.land/r/alice")
+	assert.NotEqual(t, bobrlm.GetHash(), ValueHash{})
+	assert.False(t, bobv.Block.GetIsDirty())
+	xv := alicev.Block.Values[alicen.GetPathForName("x").Index]
+	assert.Equal(t, xv.GetInt(), 1)
+	assert.NotEqual(t, alicerlm.GetHash(), ValueHash{})
+
+	// alice cannot modify bob's objects directly.
+	hash := bobrlm.GetHash()
+	for _, fn := range []string{
+		"setCount", "setObj", "setDeref", "setDerefField",
+	} {
+		r := catchPanic(func() {
+			m.RunStatement(S(Call(X(fn))))
+		})
+		assert.Contains(t, fmt.Sprint(r), "of another realm")
+	}
+	objv := bobv.Block.Values[bobn.GetPathForName("Obj").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	countv = bobv.Block.Values[bobn.GetPathForName("Count").Index]
+	assert.Equal(t, countv.GetInt(), 1)
+	assert.Equal(t, objv.Fields[0].GetInt(), 0)
+	outv := bobv.Block.Values[bobn.GetPathForName("Out").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	innerv := outv.Fields[0].V.(PointerValue).TypedValue.V.(*StructValue)
+	assert.Equal(t, innerv.Fields[0].GetInt(), 0)
+	assert.Equal(t, bobrlm.GetHash(), hash)
+}
+
+func TestRealmCrossCallRollback(t *testing.T) {
+	db := NewMemDB()
+	store := NewKVStore(db)
+	bobv, err := AddPackage(store, "gno.land/r/bob",
+		MustParseFile("bob.go", `package bob
+var Count int
+func Inc() {
+	Count++
+}`))
+	assert.Nil(t, err)
+	bobrlm := bobv.GetRealm()
+	counter, hash := bobrlm.Counter, bobrlm.GetHash()
+	nkvs := len(db.kvs)
+
+	// alice panics once bob's transaction is finalized.
+	alicen := NewPackageNode("alice", "gno.land/r/alice", &FileSet{})
+	alicev := alicen.NewPackage(NewStoreRealmer(store))
+	m, r := runMain(MachineOptions{
+		Package:  alicev,
+		Importer: store.GetPackage,
+	}, `package alice
+import "gno.land/r/bob"
+var x int
+func main() {
+	x = 1
+	bob.Inc()
+	panic("alice fails")
+}
+func inc() {
+	x = 1
+	bob.Inc()
+}`)
+	assert.Equal(t, fmt.Sprint(r), "alice fails")
+	// bob's transaction was rolled back along with alice's.
+	countv := bobv.Block.Values[bobv.Source.GetPathForName("Count").Index]
+	assert.Equal(t, countv.GetInt(), 0)
+	assert.Equal(t, bobrlm.Counter, counter)
+	assert.Equal(t, bobrlm.GetHash(), hash)
+	assert.Equal(t, len(db.kvs), nkvs)
+
+	// both are persisted once alice returns.
+	m.RunStatement(S(Call(X("inc"))))
+	assert.NotEqual(t, bobrlm.GetHash(), hash)
+	assert.NotEqual(t, len(db.kvs), nkvs)
+	bobv2 := NewKVStore(db).GetPackage("gno.land/r/bob")
+	countv = bobv2.Block.Values[bobv2.Source.GetPathForName("Count").Index]
+	assert.Equal(t, countv.GetInt(), 1)
+}
+
+func TestRealmClosure(t *testing.T) {
+	db := NewMemDB()
+	store := NewKVStore(db)
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+var F func() int
+func one() int {
+	return 1
+}
+func main() {
+	F = one
+}
+func setClosure() {
+	y := 2
+	F = func() int { return y }
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+	fv := pv.Block.Values[pn.GetPathForName("F").Index].V
+	counter, nkvs := rlm.Counter, len(db.kvs)
+
+	// closures of function calls cannot be persisted, which
+	// is an error before anything is hashed or saved.
+	r = catchPanic(func() {
+		m.RunStatement(S(Call(X("setClosure"))))
+	})
+	assert.Contains(t, fmt.Sprint(r), "cannot persist closure")
+	assert.Equal(t, pv.Block.Values[pn.GetPathForName("F").Index].V, fv)
+	assert.Equal(t, rlm.Counter, counter)
+	assert.False(t, pv.Block.GetIsDirty())
+	assert.Equal(t, len(db.kvs), nkvs)
+}
+
+func TestRealmWeakRef(t *testing.T) {
+	store := NewMemStore()
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+type Leaf struct {
+	Name string
+}
+var leaf *Leaf
+var w weakref
+var found interface{}
+var ok bool
+func main() {
+	leaf = &Leaf{Name: "l"}
+	w = makeweak(leaf)
+}
+func deref() {
+	found, ok = derefweak(w)
+}
+func remove() {
+	leaf = nil
+	found = nil
+}`)
+	assert.Nil(t, r)
+	leafv := pv.Block.Values[pn.GetPathForName("leaf").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	wv := pv.Block.Values[pn.GetPathForName("w").Index].V.(WeakRefValue)
+	// weak references do not count.
+	assert.Equal(t, leafv.GetRefCount(), 1)
+	assert.Equal(t, wv.GetTargetID(), leafv.GetObjectID())
+	assert.False(t, wv.GetTargetID().IsZero())
+
+	m.RunStatement(S(Call(X("deref"))))
+	okv := pv.Block.Values[pn.GetPathForName("ok").Index]
+	foundv := pv.Block.Values[pn.GetPathForName("found").Index]
+	assert.True(t, okv.GetBool())
+	assert.Equal(t, foundv.T, wv.T)
+	assert.Equal(t, foundv.V.(PointerValue).TypedValue.V, Value(leafv))
+
+	// a weakref by ID alone resolves through the store.
+	wv2 := WeakRefValue{
+		TargetID: leafv.GetObjectID(),
+		T:        wv.T,
+		realm:    pv.GetRealm(),
+	}
+	assert.Equal(t, wv2.GetTarget(), Object(leafv))
+
+	// once deleted, the weakref is invalid.
+	m.RunStatement(S(Call(X("remove"))))
+	assert.True(t, leafv.GetIsDeleted())
+	assert.Nil(t, wv2.GetTarget())
+	m.RunStatement(S(Call(X("deref"))))
+	okv = pv.Block.Values[pn.GetPathForName("ok").Index]
+	foundv = pv.Block.Values[pn.GetPathForName("found").Index]
+	assert.False(t, okv.GetBool())
+	assert.Nil(t, foundv.T)
+}
+
+func TestRealmWeakRefStore(t *testing.T) {
+	db := NewMemDB()
+	store := NewKVStore(db)
+	_, err := AddPackage(store, "gno.land/r/nft",
+		MustParseFile("nft.go", `package nft
+type Meta struct {
+	Name string
+}
+type Token struct {
+	Meta *Meta
+}
+var B = &Token{Meta: &Meta{Name: "b"}}
+func GetB() *Token {
+	return B
+}`))
+	assert.Nil(t, err)
+	_, err = AddPackage(store, "gno.land/r/bob",
+		MustParseFile("bob.go", `package bob
+import "gno.land/r/nft"
+var w weakref
+func init() {
+	w = makeweak(nft.GetB())
+}
+func Name() string {
+	t, ok := derefweak(w)
+	if ok {
+		var tok *nft.Token
+		tok = t.(*nft.Token)
+		return tok.Meta.Name
+	}
+	return "not found"
+}`))
+	assert.Nil(t, err)
+
+	// upon restart, the target is loaded by its own realm,
+	// such that its children are loaded in turn.
+	store2 := NewKVStore(db)
+	buf := new(bytes.Buffer)
+	_, r := runMain(MachineOptions{
+		Output:   buf,
+		Importer: store2.GetPackage,
+	}, `package main
+import "gno.land/r/bob"
+func main() {
+	println(bob.Name())
+}`)
+	assert.Nil(t, r)
+	assert.Equal(t, buf.String(), "b\n")
+}
+
+func TestRealmAutoTransfer(t *testing.T) {
+	store := NewMemStore()
+	realmer := NewStoreRealmer(store)
+	var nftv *PackageValue
+	importer := func(pkgPath string) *PackageValue {
+		if pkgPath == "gno.land/r/nft" {
+			return nftv
+		}
+		return nil
+	}
+
+	// nft hands out tokens to other realms.
+	nftn := NewPackageNode("nft", "gno.land/r/nft", &FileSet{})
+	nftv = nftn.NewPackage(realmer)
+	_, r := runMain(MachineOptions{Package: nftv}, `package nft
+type Meta struct {
+	Name string
+}
+type Token struct {
+	ID   int
+	Meta *Meta
+}
+var A *Token
+var B *Token
+func main() {
+	A = &Token{ID: 1, Meta: &Meta{Name: "a"}}
+	B = &Token{ID: 2, Meta: &Meta{Name: "b"}}
+}
+func TakeA() *Token {
+	t := A
+	A = nil
+	return t
+}
+func GetB() *Token {
+	return B
+}
+func DropB() {
+	B = nil
+}`)
+	assert.Nil(t, r)
+	nftrlm := nftv.GetRealm()
+	nftrlm.SetAutoTransfer(true)
+	tokav := nftv.Block.Values[nftn.GetPathForName("A").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	metaav := tokav.Fields[1].V.(PointerValue).TypedValue.V.(*StructValue)
+	tokbv := nftv.Block.Values[nftn.GetPathForName("B").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	tokaid := tokav.GetObjectID()
+	metaaid := metaav.GetObjectID()
+	assert.Equal(t, tokaid.RealmID, nftrlm.ID)
+
+	// bob takes A, then grabs B before it is dropped.
+	bobn := NewPackageNode("bob", "gno.land/r/bob", &FileSet{})
+	bobv := bobn.NewPackage(realmer)
+	m := NewMachineWithOptions(MachineOptions{
+		Package:  bobv,
+		Output:   ioutil.Discard,
+		Importer: importer,
+	})
+	m.RunFiles(MustParseFile("bob.go", `package bob
+import "gno.land/r/nft"
+var Mine *nft.Token
+var Also *nft.Token
+func main() {
+	Mine = nft.TakeA()
+}
+func grab() {
+	Also = nft.GetB()
+	nft.DropB()
+}`))
+	bobrlm := bobv.GetRealm()
+	hash := nftrlm.GetHash()
+	m.RunMain()
+
+	// A and its meta were re-homed under bob.
+	assert.Equal(t, tokav.GetObjectID().RealmID, bobrlm.ID)
+	assert.Equal(t, metaav.GetObjectID().RealmID, bobrlm.ID)
+	assert.Equal(t, tokav.GetOwner(), Object(&bobv.Block))
+	assert.Equal(t, metaav.GetOwner(), Object(tokav))
+	assert.Equal(t, tokav.GetRefCount(), 1)
+	assert.Equal(t, metaav.GetRefCount(), 1)
+	assert.Nil(t, store.GetObject(tokaid))
+	assert.Nil(t, store.GetObject(metaaid))
+	assert.Equal(t, store.GetObject(tokav.GetObjectID()), Object(tokav))
+	assert.NotEqual(t, nftrlm.GetHash(), hash)
+	assert.NotEqual(t, bobrlm.GetHash(), ValueHash{})
+	assert.False(t, tokav.GetIsDirty())
+	assert.NotEqual(t, tokav.Hash, ValueHash{})
+	// weakrefs by its former ID are invalid.
+	wv := WeakRefValue{TargetID: tokaid, realm: nftrlm}
+	assert.Nil(t, wv.GetTarget())
+	wv = WeakRefValue{TargetID: tokaid, target: tokav, realm: nftrlm}
+	assert.Nil(t, wv.GetTarget())
+
+	// B is re-homed even though bob attached it first.
+	hash = nftrlm.GetHash()
+	m.RunStatement(S(Call(X("grab"))))
+	assert.Equal(t, tokbv.GetObjectID().RealmID, bobrlm.ID)
+	assert.Equal(t, tokbv.GetOwner(), Object(&bobv.Block))
+	assert.Equal(t, tokbv.GetRefCount(), 1)
+	assert.NotEqual(t, nftrlm.GetHash(), hash)
+	assert.False(t, bobv.Block.GetIsDirty())
+}
+
+func TestRealmStorage(t *testing.T) {
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewMemRealmer())
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+type Leaf struct {
+	Name string
+}
+var leaf *Leaf
+var other *Leaf
+func main() {
+	leaf = &Leaf{Name: "l"}
+}
+func grow() {
+	leaf.Name = "a much longer name than before"
+}
+func add() {
+	other = &Leaf{Name: "o"}
+}
+func shrink() {
+	leaf = nil
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+	leafv := pv.Block.Values[pn.GetPathForName("leaf").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	assert.True(t, rlm.Size > 0)
+	assert.Equal(t, rlm.GetSizeDelta(), rlm.Size)
+	assert.Equal(t, rlm.Size, pv.Block.Size+leafv.Size)
+
+	// growing the leaf is accounted for.
+	size := rlm.Size
+	m.RunStatement(S(Call(X("grow"))))
+	assert.Equal(t, rlm.GetSizeDelta(), int64(len("a much longer name than before")-len("l")))
+	assert.Equal(t, rlm.Size, size+rlm.GetSizeDelta())
+	assert.Equal(t, rlm.Size, pv.Block.Size+leafv.Size)
+
+	// the budget rejects growth, but not shrinkage.
+	rlm.SetStoragePolicy(NewStorageBudget(rlm.Size))
+	size = rlm.Size
+	hash := rlm.GetHash()
+	r = catchPanic(func() {
+		m.RunStatement(S(Call(X("add"))))
+	})
+	assert.Contains(t, fmt.Sprint(r), "storage budget exceeded")
+	assert.Equal(t, rlm.Size, size)
+	assert.Equal(t, rlm.GetHash(), hash)
+	otherv := pv.Block.Values[pn.GetPathForName("other").Index]
+	assert.Nil(t, otherv.V)
+	m.RunStatement(S(Call(X("shrink"))))
+	assert.True(t, rlm.GetSizeDelta() < 0)
+	assert.Equal(t, rlm.Size, pv.Block.Size)
+}
+
+func TestRealmCollectCycles(t *testing.T) {
+	store := NewMemStore()
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+type Node struct {
+	Name string
+	Peer interface{}
+}
+var root *Node
+func main() {
+	a := &Node{Name: "a"}
+	b := &Node{Name: "b", Peer: a}
+	a.Peer = b
+	root = a
+}
+func detach() {
+	root = nil
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+	av := pv.Block.Values[pn.GetPathForName("root").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	bv := av.Fields[1].V.(PointerValue).TypedValue.V.(*StructValue)
+	assert.Equal(t, av.GetRefCount(), 2)
+	assert.Equal(t, bv.GetRefCount(), 1)
+	assert.NotNil(t, store.GetObject(av.GetObjectID()))
+	assert.NotNil(t, store.GetObject(bv.GetObjectID()))
+
+	// the detached cycle is collected.
+	rlm.SetLogRealmOps(true)
+	m.RunStatement(S(Call(X("detach"))))
+	assert.True(t, av.GetIsDeleted())
+	assert.True(t, bv.GetIsDeleted())
+	assert.Nil(t, store.GetObject(av.GetObjectID()))
+	assert.Nil(t, store.GetObject(bv.GetObjectID()))
+	assert.Equal(t, rlm.Size, pv.Block.Size)
+	dels := 0
+	for _, rop := range rlm.ropslog {
+		if rop.Type == RealmOpDel {
+			dels++
+		}
+	}
+	assert.Equal(t, dels, 2)
+}
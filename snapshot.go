@@ -0,0 +1,413 @@
+package gno
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//----------------------------------------
+// RealmSnapshot
+//
+// Upon finalization, realms persist an ObjectSnapshot of each
+// object they save, keyed by its ObjectID and object hash, such
+// that past states of a realm remain in its store.  The state of
+// a realm as of a root hash, that is the hash of its package
+// block, is thus known by the root hash alone: DiffRealms()
+// compares two such states by walking their objects from the
+// package block down, loading only the objects whose hashes
+// differ, e.g. to review what a transaction changed, even upon
+// restart.  Objects of other realms are not included.
+//
+// `ObjectSnapshot := ver,sz(oid),sz(oh),Preimage,Elems,Owned`
+// `Preimage := vt,sz(data),off,len,max`
+// `Elems := 0x00` if byte-array.
+// `Elems := 0x01,n,(sz(tid),et,sz(oid),sz(vh))*n`
+// `Owned := n,(sz(oid),sz(oh))*n`
+//  * owned are the children of the object in its realm, in
+//    order of elements, whose hashes are included in its own.
+
+// Refers to the state of a realm as of a root hash.
+type RealmSnapshot struct {
+	Path string
+	Root ValueHash // see Realm.GetHash().
+
+	store Store // of object snapshots.
+}
+
+type ObjectSnapshot struct {
+	ObjectID
+	Hash     ValueHash           // object hash.
+	Preimage ValuePreimage       // as of hash.
+	Elems    []TypedElemPreimage // nil if byte-array.
+	Owned    []ObjectRef         // owned children of same realm.
+}
+
+// Refers to an object as of its object hash.
+type ObjectRef struct {
+	ObjectID
+	Hash ValueHash
+}
+
+// Captures the realm at its current root hash.  The realm must
+// be finalized, such that its objects are persisted as of the
+// root hash.  Nothing is loaded nor copied.
+func SnapshotRealm(rlm *Realm) (*RealmSnapshot, error) {
+	if rlm.pkg == nil {
+		return nil, errors.New("realm has no package")
+	}
+	if rlm.store == nil {
+		return nil, errors.New("realm has no store")
+	}
+	if len(rlm.created)+len(rlm.updated)+len(rlm.deleted) != 0 {
+		return nil, errors.New("realm has unfinalized changes")
+	}
+	return &RealmSnapshot{
+		Path:  rlm.Path,
+		Root:  rlm.GetHash(),
+		store: rlm.store,
+	}, nil
+}
+
+// Returns the snapshot of oo as of its preimage vp, once hashed,
+// see ProcessUpdatedObjects().
+func newObjectSnapshot(oo Object, vp ValuePreimage) *ObjectSnapshot {
+	tepz := vp.preimages
+	// copy, as byte-arrays are referred to directly.
+	vp.Data = append([]byte(nil), vp.Data...)
+	vp.preimages = nil
+	if tepz == nil && vp.ValType != ValTypeData {
+		tepz = []TypedElemPreimage{}
+	}
+	return &ObjectSnapshot{
+		ObjectID: oo.GetObjectID(),
+		Hash:     oo.GetObjectInfo().Hash,
+		Preimage: vp,
+		Elems:    tepz,
+		Owned:    ownedRefs(oo),
+	}
+}
+
+// Returns the children owned by oo in its realm, in order of
+// elements, without loading them.
+func ownedRefs(oo Object) []ObjectRef {
+	var refs []ObjectRef
+	rid := oo.GetObjectID().RealmID
+	seen := make(map[ObjectID]bool)
+	add := func(v Value) {
+		var ref ObjectRef
+		switch cv := v.(type) {
+		case RefValue:
+			if cv.Hash == (ValueHash{}) {
+				return // borrowed.
+			}
+			ref = ObjectRef{cv.ObjectID, cv.Hash}
+		case Object: // including blocks of pointers.
+			if cv.GetOwner() != oo {
+				return
+			}
+			ref = ObjectRef{cv.GetObjectID(), cv.GetObjectInfo().Hash}
+		default:
+			return
+		}
+		if ref.RealmID != rid || seen[ref.ObjectID] {
+			return
+		}
+		seen[ref.ObjectID] = true
+		refs = append(refs, ref)
+	}
+	forEachElem(oo, func(tv *TypedValue) {
+		switch cv := tv.V.(type) {
+		case PointerValue:
+			if cv.Base != nil {
+				add(cv.Base)
+			} else if cv.TypedValue != nil {
+				add(cv.TypedValue.V)
+			}
+		case *SliceValue:
+			if cv.Base != nil {
+				add(cv.Base)
+			}
+		default:
+			add(tv.V)
+		}
+	})
+	return refs
+}
+
+//----------------------------------------
+// ObjectDiff
+
+type ObjectDiff struct {
+	Type     RealmOpType     // New, Mod, or Del.
+	ObjectID ObjectID        // of added, modified, or removed.
+	OwnerID  ObjectID        // as of New, or Old if removed.
+	Old      *ObjectSnapshot // nil if added.
+	New      *ObjectSnapshot // nil if removed.
+	Changed  []int           // indices of modified elements.
+}
+
+// Lists the objects that were added, modified, or removed from
+// snapshot a to snapshot b of the same realm, see DiffRealms().
+func DiffSnapshots(a, b *RealmSnapshot) ([]ObjectDiff, error) {
+	if a.Path != b.Path {
+		return nil, fmt.Errorf(
+			"cannot diff snapshots of different realms %s and %s",
+			a.Path, b.Path)
+	}
+	return DiffRealms(a.store, a.Path, a.Root, b.Root)
+}
+
+// Lists the objects of the realm of path that were added,
+// modified, or removed from root hash rootA to rootB, as
+// persisted in store, ordered by ObjectID.  Owners of modified
+// objects are modified as well, up to the package block.  Only
+// the objects whose hashes differ are loaded, so any two roots
+// of a realm may be compared.
+func DiffRealms(store Store, path string, rootA, rootB ValueHash) ([]ObjectDiff, error) {
+	// snapshots loaded, such as of the roots.
+	loaded := make(map[ObjectRef]*ObjectSnapshot)
+	load := func(oid ObjectID, oh ValueHash) (*ObjectSnapshot, error) {
+		ref := ObjectRef{oid, oh}
+		if os, ok := loaded[ref]; ok {
+			return os, nil
+		}
+		os := store.GetObjectSnapshot(oid, oh)
+		if os == nil {
+			return nil, fmt.Errorf(
+				"no snapshot of object %v at hash %X", oid, oh)
+		}
+		loaded[ref] = os
+		return os, nil
+	}
+	pbid := ObjectID{RealmID: RealmIDFromPath(path)}
+	for _, root := range []ValueHash{rootA, rootB} {
+		if _, err := load(pbid, root); err != nil {
+			return nil, fmt.Errorf(
+				"no snapshot of %s at root %X", path, root)
+		}
+	}
+	// the objects of either side, and their owners.
+	type version struct {
+		os    *ObjectSnapshot
+		owner ObjectID
+	}
+	olds := make(map[ObjectID]version)
+	news := make(map[ObjectID]version)
+	oids := []ObjectID(nil) // in order of visit.
+	// objects to visit, as of either side.
+	type pair struct {
+		oid, owner ObjectID
+		ha, hb     ValueHash // zero if absent.
+	}
+	queue := []pair{{oid: pbid, ha: rootA, hb: rootB}}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if p.ha == p.hb {
+			continue // unchanged, along with its children.
+		}
+		_, seena := olds[p.oid]
+		_, seenb := news[p.oid]
+		if !seena && !seenb {
+			oids = append(oids, p.oid)
+		}
+		var ownedA, ownedB []ObjectRef
+		if p.ha != (ValueHash{}) && !seena {
+			os, err := load(p.oid, p.ha)
+			if err != nil {
+				return nil, err
+			}
+			olds[p.oid] = version{os, p.owner}
+			ownedA = os.Owned
+		}
+		if p.hb != (ValueHash{}) && !seenb {
+			os, err := load(p.oid, p.hb)
+			if err != nil {
+				return nil, err
+			}
+			news[p.oid] = version{os, p.owner}
+			ownedB = os.Owned
+		}
+		// children of both sides are compared pairwise.
+		hbs := make(map[ObjectID]ValueHash, len(ownedB))
+		for _, ref := range ownedB {
+			hbs[ref.ObjectID] = ref.Hash
+		}
+		for _, ref := range ownedA {
+			queue = append(queue, pair{
+				oid:   ref.ObjectID,
+				owner: p.oid,
+				ha:    ref.Hash,
+				hb:    hbs[ref.ObjectID],
+			})
+			delete(hbs, ref.ObjectID)
+		}
+		for _, ref := range ownedB {
+			if _, ok := hbs[ref.ObjectID]; ok {
+				queue = append(queue, pair{
+					oid:   ref.ObjectID,
+					owner: p.oid,
+					hb:    ref.Hash,
+				})
+			}
+		}
+	}
+	var diffs []ObjectDiff
+	for _, oid := range oids {
+		va, oka := olds[oid]
+		vb, okb := news[oid]
+		switch {
+		case oka && okb:
+			if va.os.Hash == vb.os.Hash {
+				continue // moved.
+			}
+			diffs = append(diffs, ObjectDiff{
+				Type:     RealmOpMod,
+				ObjectID: oid,
+				OwnerID:  vb.owner,
+				Old:      va.os,
+				New:      vb.os,
+				Changed:  changedElems(va.os.Elems, vb.os.Elems),
+			})
+		case oka:
+			diffs = append(diffs, ObjectDiff{
+				Type:     RealmOpDel,
+				ObjectID: oid,
+				OwnerID:  va.owner,
+				Old:      va.os,
+			})
+		case okb:
+			diffs = append(diffs, ObjectDiff{
+				Type:     RealmOpNew,
+				ObjectID: oid,
+				OwnerID:  vb.owner,
+				New:      vb.os,
+			})
+		}
+	}
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].ObjectID.Ordinal < diffs[j].ObjectID.Ordinal
+	})
+	return diffs, nil
+}
+
+// Returns the indices of elements that differ, including those
+// only present in one of tepz1 and tepz2.
+func changedElems(tepz1, tepz2 []TypedElemPreimage) []int {
+	var changed []int
+	for i := 0; i < len(tepz1) || i < len(tepz2); i++ {
+		if i < len(tepz1) && i < len(tepz2) && tepz1[i] == tepz2[i] {
+			continue
+		}
+		changed = append(changed, i)
+	}
+	return changed
+}
+
+// Uses the same format as RealmOp.String().
+func (od ObjectDiff) String() string {
+	switch od.Type {
+	case RealmOpNew:
+		return fmt.Sprintf("c[%X]o[%X]=%v",
+			od.ObjectID,
+			od.OwnerID,
+			od.New.Preimage.String())
+	case RealmOpMod:
+		return fmt.Sprintf("u[%X]=%v->%v%v",
+			od.ObjectID,
+			od.Old.Preimage.String(),
+			od.New.Preimage.String(),
+			od.Changed)
+	case RealmOpDel:
+		return fmt.Sprintf("d[%X]o[%X]=%v",
+			od.ObjectID,
+			od.OwnerID,
+			od.Old.Preimage.String())
+	default:
+		panic("should not happen")
+	}
+}
+
+func SprintObjectDiffs(diffs []ObjectDiff) string {
+	ss := make([]string, 0, len(diffs))
+	for _, od := range diffs {
+		ss = append(ss, od.String())
+	}
+	return strings.Join(ss, "\n")
+}
+
+//----------------------------------------
+// Snapshot encoding
+
+// Encodes an object snapshot deterministically, see
+// RealmSnapshot.
+func EncodeObjectSnapshot(os *ObjectSnapshot) (bz []byte, err error) {
+	defer recoverEncodingError(&err)
+	enc := &encoder{}
+	enc.writeByte(EncodingVersion)
+	enc.writeObjectID(os.ObjectID)
+	enc.writeBytes(os.Hash[:])
+	enc.writeByte(byte(os.Preimage.ValType))
+	enc.writeBytes(os.Preimage.Data)
+	enc.writeInt(os.Preimage.Offset)
+	enc.writeInt(os.Preimage.Length)
+	enc.writeInt(os.Preimage.Maxcap)
+	if os.Elems == nil {
+		enc.writeByte(0x00)
+	} else {
+		enc.writeByte(0x01)
+		enc.writeInt(len(os.Elems))
+		for _, tep := range os.Elems {
+			enc.writeBytes(tep.TypeID[:])
+			enc.writeByte(byte(tep.ElemType))
+			enc.writeObjectID(tep.ObjectID)
+			enc.writeBytes(tep.ValueHash[:])
+		}
+	}
+	enc.writeInt(len(os.Owned))
+	for _, ref := range os.Owned {
+		enc.writeObjectID(ref.ObjectID)
+		enc.writeBytes(ref.Hash[:])
+	}
+	return enc.buf, nil
+}
+
+// Decodes an object snapshot encoded with EncodeObjectSnapshot().
+func DecodeObjectSnapshot(bz []byte) (os *ObjectSnapshot, err error) {
+	defer recoverEncodingError(&err)
+	dec := &decoder{buf: bz}
+	dec.readVersion()
+	os = &ObjectSnapshot{}
+	os.ObjectID = dec.readObjectID()
+	os.Hash = dec.readHash()
+	os.Preimage.ValType = ValType(dec.readByte())
+	os.Preimage.Data = dec.readBytes()
+	os.Preimage.Offset = dec.readInt()
+	os.Preimage.Length = dec.readInt()
+	os.Preimage.Maxcap = dec.readInt()
+	if dec.readBool() {
+		ne := dec.readInt()
+		os.Elems = make([]TypedElemPreimage, 0, ne)
+		for i := 0; i < ne; i++ {
+			tep := TypedElemPreimage{}
+			tep.TypeID = TypeID(dec.readHash())
+			tep.ElemType = ElemType(dec.readByte())
+			tep.ObjectID = dec.readObjectID()
+			tep.ValueHash = dec.readHash()
+			os.Elems = append(os.Elems, tep)
+		}
+	}
+	if no := dec.readInt(); no > 0 {
+		os.Owned = make([]ObjectRef, 0, no)
+		for i := 0; i < no; i++ {
+			ref := ObjectRef{}
+			ref.ObjectID = dec.readObjectID()
+			ref.Hash = dec.readHash()
+			os.Owned = append(os.Owned, ref)
+		}
+	}
+	dec.readEnd()
+	return os, nil
+}
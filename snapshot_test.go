@@ -0,0 +1,167 @@
+package gno
+
+import (
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	store := NewMemStore()
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+type Leaf struct {
+	Name string
+}
+type Node struct {
+	Name  string
+	Left  *Leaf
+	Right *Leaf
+}
+var root *Node
+func main() {
+	root = &Node{Name: "root", Left: &Leaf{Name: "l"}, Right: &Leaf{Name: "r"}}
+}
+func migrate() {
+	root.Left = &Leaf{Name: "l2"}
+	root.Right.Name = "r2"
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+	rootv := pv.Block.Values[pn.GetPathForName("root").Index].
+		V.(PointerValue).TypedValue.V.(*StructValue)
+	leftv := rootv.Fields[1].V.(PointerValue).TypedValue.V.(*StructValue)
+	rightv := rootv.Fields[2].V.(PointerValue).TypedValue.V.(*StructValue)
+
+	snap1, err := SnapshotRealm(rlm)
+	assert.Nil(t, err)
+	assert.Equal(t, snap1.Root, rlm.GetHash())
+	rightHash := rightv.Hash
+	diffs, err := DiffSnapshots(snap1, snap1)
+	assert.Nil(t, err)
+	assert.Nil(t, diffs)
+
+	m.RunStatement(S(Call(X("migrate"))))
+	newv := rootv.Fields[1].V.(PointerValue).TypedValue.V.(*StructValue)
+	snap2, err := SnapshotRealm(rlm)
+	assert.Nil(t, err)
+	assert.NotEqual(t, snap1.Root, snap2.Root)
+	diffs, err = DiffSnapshots(snap1, snap2)
+	assert.Nil(t, err)
+	types := make(map[ObjectID]RealmOpType)
+	for _, od := range diffs {
+		types[od.ObjectID] = od.Type
+	}
+	assert.Equal(t, len(diffs), 5)
+	assert.Equal(t, types[pv.Block.GetObjectID()], RealmOpMod)
+	assert.Equal(t, types[rootv.GetObjectID()], RealmOpMod)
+	assert.Equal(t, types[rightv.GetObjectID()], RealmOpMod)
+	assert.Equal(t, types[leftv.GetObjectID()], RealmOpDel)
+	assert.Equal(t, types[newv.GetObjectID()], RealmOpNew)
+	for i, od := range diffs {
+		if 0 < i {
+			assert.True(t, diffs[i-1].ObjectID.Ordinal < od.ObjectID.Ordinal)
+		}
+		switch od.ObjectID {
+		case rootv.GetObjectID():
+			assert.Equal(t, od.Changed, []int{1, 2})
+		case rightv.GetObjectID():
+			assert.Equal(t, od.Changed, []int{0})
+			// the old version is unaffected by the migration.
+			assert.Equal(t, od.Old.Hash, rightHash)
+			assert.Equal(t, od.New.Hash, rightv.Hash)
+		case leftv.GetObjectID():
+			assert.Equal(t, od.OwnerID, rootv.GetObjectID())
+		case newv.GetObjectID():
+			assert.Equal(t, od.OwnerID, rootv.GetObjectID())
+		}
+	}
+	assert.NotNil(t, store.GetObjectSnapshot(rightv.GetObjectID(), rightHash))
+	assert.Contains(t, SprintObjectDiffs(diffs), "d[")
+
+	// roots are diffed from the store, and in reverse.
+	diffs2, err := DiffRealms(store, rlm.Path, snap1.Root, snap2.Root)
+	assert.Nil(t, err)
+	assert.Equal(t, diffs2, diffs)
+	diffs2, err = DiffRealms(store, rlm.Path, snap2.Root, snap1.Root)
+	assert.Nil(t, err)
+	assert.Equal(t, len(diffs2), 5)
+	for _, od := range diffs2 {
+		if od.ObjectID == leftv.GetObjectID() {
+			assert.Equal(t, od.Type, RealmOpNew)
+		}
+	}
+
+	// snapshots of different realms cannot be compared.
+	snap3 := *snap2
+	snap3.Path = "gno.land/r/other"
+	_, err = DiffSnapshots(snap1, &snap3)
+	assert.NotNil(t, err)
+}
+
+func TestDiffRealms(t *testing.T) {
+	db := NewMemDB()
+	store := NewKVStore(db)
+	pv, err := AddPackage(store, "gno.land/r/test",
+		MustParseFile("test.go", `package test
+type Item struct {
+	Name string
+}
+type Pair struct {
+	A *Item
+	B *Item
+}
+var pair *Pair
+func init() {
+	pair = &Pair{A: &Item{Name: "a"}}
+}
+func SetB(name string) {
+	pair.B = &Item{Name: name}
+}`))
+	assert.Nil(t, err)
+	rlm := pv.GetRealm()
+	root1 := rlm.GetHash()
+	runSetB := func(name string) {
+		_, r := runMain(MachineOptions{Importer: store.GetPackage}, `package main
+import "gno.land/r/test"
+func main() {
+	test.SetB("`+name+`")
+}`)
+		assert.Nil(t, r)
+	}
+	runSetB("b")
+	root2 := rlm.GetHash()
+	runSetB("c")
+	snap3, err := SnapshotRealm(rlm)
+	assert.Nil(t, err)
+	assert.NotEqual(t, root1, root2)
+	assert.NotEqual(t, root2, snap3.Root)
+
+	// any two roots are diffed from the store upon restart,
+	// whether snapshotted or not.
+	store2 := &countingStore{Store: NewKVStore(db)}
+	diffs, err := DiffRealms(store2, rlm.Path, root1, root2)
+	assert.Nil(t, err)
+	assert.Equal(t, len(diffs), 3) // package block, pair, new item.
+	assert.Equal(t, diffs[1].Changed, []int{1})
+	assert.Equal(t, diffs[2].Type, RealmOpNew)
+	// item "a" is unchanged, thus not loaded, nor any object.
+	assert.Equal(t, store2.snaps, 2+2+1)
+	assert.Equal(t, store2.gets, 0)
+	diffs, err = DiffRealms(store2, rlm.Path, root2, snap3.Root)
+	assert.Nil(t, err)
+	assert.Equal(t, len(diffs), 4) // item "b" replaced.
+	assert.Contains(t, SprintObjectDiffs(diffs), "d[")
+	diffs2, err := DiffSnapshots(&RealmSnapshot{
+		Path: rlm.Path, Root: root2, store: store2,
+	}, snap3)
+	assert.Nil(t, err)
+	assert.Equal(t, SprintObjectDiffs(diffs2), SprintObjectDiffs(diffs))
+
+	// unknown roots are an error.
+	_, err = DiffRealms(store2, rlm.Path, root1, ValueHash{1})
+	assert.Contains(t, err.Error(), "no snapshot")
+	_, err = DiffRealms(store2, "gno.land/r/other", root1, root2)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,66 @@
+package gno
+
+import "sync"
+
+//----------------------------------------
+// std
+//
+// The std package exposes the context of the running machine
+// to Gno code, such as the realm and package of the caller.
+// Its functions are native, and it is importable as "std" by
+// all machines regardless of their Importer, and by stores.
+
+var (
+	stdPackage     *PackageValue
+	stdPackageOnce sync.Once
+)
+
+// Always returns the same instance, even if called concurrently.
+func StdPackage() *PackageValue {
+	stdPackageOnce.Do(func() {
+		stdPackage = newStdPackage()
+	})
+	return stdPackage
+}
+
+func newStdPackage() *PackageValue {
+	pn := NewPackageNode("std", "std", nil)
+	// Returns the path of the realm that called into the
+	// active realm, or "" if none.
+	pn.DefineNative("CallerRealmPath",
+		Flds(), Flds("", "string"),
+		func(m *Machine) {
+			path := ""
+			if rlm := m.GetCallerRealm(); rlm != nil {
+				path = rlm.Path
+			}
+			m.PushValue(TypedValue{T: StringType, V: StringValue(path)})
+		},
+	)
+	// Returns the path of the package that called into the
+	// active realm, or "" if none.
+	pn.DefineNative("CallerPkgPath",
+		Flds(), Flds("", "string"),
+		func(m *Machine) {
+			path := ""
+			if pv := m.GetCallerPackage(); pv != nil {
+				path = pv.PkgPath
+			}
+			m.PushValue(TypedValue{T: StringType, V: StringValue(path)})
+		},
+	)
+	return pn.NewPackage(nil)
+}
+
+// Resolves "std", and other packages with imp, if any.
+func withStd(imp Importer) Importer {
+	return func(pkgPath string) *PackageValue {
+		if pkgPath == "std" {
+			return StdPackage()
+		}
+		if imp == nil {
+			return nil
+		}
+		return imp(pkgPath)
+	}
+}
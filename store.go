@@ -0,0 +1,722 @@
+package gno
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//----------------------------------------
+// Store
+//
+// A Store persists real objects by their ObjectID, as well as
+// an index of realms by package path, a registry of types by
+// TypeID, and preprocessed packages by package path.  Writes
+// are staged until Commit(), or dropped by Discard().  Realms
+// write through to their store upon FinalizeRealmTransaction(),
+// committing only once all objects were encoded.
+
+type Store interface {
+	GetObject(oid ObjectID) Object // nil if not found.
+	SetObject(oo Object)
+	DelObject(oo Object)
+	GetRealm(path string) *Realm     // nil if not found.
+	GetRealmPath(rid RealmID) string // "" if not found.
+	SetRealm(rlm *Realm)
+	GetType(tid TypeID) Type // nil if not found.
+	SetType(t Type)
+	GetPackage(pkgPath string) *PackageValue // nil if not found.
+	SetPackage(pv *PackageValue)
+	GetObjectSnapshot(oid ObjectID, oh ValueHash) *ObjectSnapshot // nil if not found.
+	SetObjectSnapshot(os *ObjectSnapshot)
+	Commit()  // writes staged changes through.
+	Discard() // drops staged changes.
+}
+
+//----------------------------------------
+// MemStore
+
+// Keeps objects in memory.  Useful for testing.
+// Writes take effect immediately, and are undone by Discard().
+type MemStore struct {
+	objects map[ObjectID]Object
+	realms  map[string]*Realm
+	types   map[TypeID]Type
+	pkgs    map[string]*PackageValue
+	snaps   map[ObjectRef]*ObjectSnapshot
+	undo    []func() // since last Commit().
+}
+
+var _ Store = &MemStore{}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		objects: make(map[ObjectID]Object),
+		realms:  make(map[string]*Realm),
+		types:   make(map[TypeID]Type),
+		pkgs:    make(map[string]*PackageValue),
+		snaps:   make(map[ObjectRef]*ObjectSnapshot),
+	}
+}
+
+func (ms *MemStore) GetObject(oid ObjectID) Object {
+	return ms.objects[oid]
+}
+
+func (ms *MemStore) SetObject(oo Object) {
+	oid := oo.GetObjectID()
+	if oid.IsZero() {
+		panic("cannot store object without ObjectID")
+	}
+	ms.saveObject(oid)
+	ms.objects[oid] = oo
+}
+
+func (ms *MemStore) DelObject(oo Object) {
+	oid := oo.GetObjectID()
+	if oid.IsZero() {
+		panic("cannot delete object without ObjectID")
+	}
+	ms.saveObject(oid)
+	delete(ms.objects, oid)
+}
+
+func (ms *MemStore) saveObject(oid ObjectID) {
+	prev, ok := ms.objects[oid]
+	ms.undo = append(ms.undo, func() {
+		if ok {
+			ms.objects[oid] = prev
+		} else {
+			delete(ms.objects, oid)
+		}
+	})
+}
+
+// Returns a copy of the realm's persisted fields.
+func (ms *MemStore) GetRealm(path string) *Realm {
+	if rlm, ok := ms.realms[path]; ok {
+		return rlm.copyForStore()
+	} else {
+		return nil
+	}
+}
+
+func (ms *MemStore) GetRealmPath(rid RealmID) string {
+	for path, rlm := range ms.realms {
+		if rlm.ID == rid {
+			return path
+		}
+	}
+	return ""
+}
+
+func (ms *MemStore) SetRealm(rlm *Realm) {
+	prev, ok := ms.realms[rlm.Path]
+	ms.undo = append(ms.undo, func() {
+		if ok {
+			ms.realms[rlm.Path] = prev
+		} else {
+			delete(ms.realms, rlm.Path)
+		}
+	})
+	ms.realms[rlm.Path] = rlm.copyForStore()
+}
+
+func (ms *MemStore) GetType(tid TypeID) Type {
+	return ms.types[tid]
+}
+
+func (ms *MemStore) SetType(t Type) {
+	tid := t.TypeID()
+	prev, ok := ms.types[tid]
+	ms.undo = append(ms.undo, func() {
+		if ok {
+			ms.types[tid] = prev
+		} else {
+			delete(ms.types, tid)
+		}
+	})
+	ms.types[tid] = t
+}
+
+func (ms *MemStore) GetPackage(pkgPath string) *PackageValue {
+	return ms.pkgs[pkgPath]
+}
+
+func (ms *MemStore) SetPackage(pv *PackageValue) {
+	prev, ok := ms.pkgs[pv.PkgPath]
+	ms.undo = append(ms.undo, func() {
+		if ok {
+			ms.pkgs[pv.PkgPath] = prev
+		} else {
+			delete(ms.pkgs, pv.PkgPath)
+		}
+	})
+	ms.pkgs[pv.PkgPath] = pv
+}
+
+func (ms *MemStore) GetObjectSnapshot(oid ObjectID, oh ValueHash) *ObjectSnapshot {
+	return ms.snaps[ObjectRef{oid, oh}]
+}
+
+func (ms *MemStore) SetObjectSnapshot(os *ObjectSnapshot) {
+	key := ObjectRef{os.ObjectID, os.Hash}
+	prev, ok := ms.snaps[key]
+	ms.undo = append(ms.undo, func() {
+		if ok {
+			ms.snaps[key] = prev
+		} else {
+			delete(ms.snaps, key)
+		}
+	})
+	ms.snaps[key] = os
+}
+
+func (ms *MemStore) Commit() {
+	ms.undo = nil
+}
+
+// Undoes all writes since the last Commit(), in reverse order.
+func (ms *MemStore) Discard() {
+	for i := len(ms.undo) - 1; 0 <= i; i-- {
+		ms.undo[i]()
+	}
+	ms.undo = nil
+}
+
+//----------------------------------------
+// KVStore
+//
+// KVStore persists objects onto a key/value database.
+// Objects are encoded as they are set, and the resulting writes
+// are staged in a write buffer until Commit().  Objects are
+// kept in an object cache.
+// The declared types that objects refer to are persisted along
+// with them, unless already persisted.  Packages are persisted
+// preprocessed, and are loaded with their file blocks and, if a
+// realm, its package block; pure packages are initialized anew.
+//
+// key "oid:<hex(ObjectID)>"          => object bytes, see EncodeObject()
+// key "rlm:<path>"                   => realm bytes (counter, size)
+// key "rid:<hex(RealmID)>"           => realm path
+// key "tid:<hex(TypeID)>"            => type bytes, see EncodeType()
+// key "pkg:<path>"                   => package bytes, see EncodePackageNode()
+// key "snp:<hex(ObjectID)>:<hex(oh)>" => object snapshot bytes, see EncodeObjectSnapshot()
+
+type KVStore struct {
+	db    KVDB
+	batch map[string][]byte // staged writes; nil if deleted.
+	keys  []string          // of batch, in order of staging.
+	undo  []func()          // reverts caches upon Discard().
+	cache map[ObjectID]Object
+	types map[TypeID]Type          // persisted or loaded.
+	pkgs  map[string]*PackageValue // persisted or loaded.
+	rs    Resolver                 // for packages, see SetResolver().
+}
+
+var _ Store = &KVStore{}
+
+func NewKVStore(db KVDB) *KVStore {
+	return &KVStore{
+		db:    db,
+		cache: make(map[ObjectID]Object),
+		types: make(map[TypeID]Type),
+		pkgs:  make(map[string]*PackageValue),
+	}
+}
+
+func (ks *KVStore) GetObject(oid ObjectID) Object {
+	if oo, ok := ks.cache[oid]; ok {
+		return oo
+	}
+	bz := ks.get(objectKey(oid))
+	if bz == nil {
+		return nil
+	}
+	oo, err := DecodeObject(bz, kvResolver{ks})
+	if err != nil {
+		panic(fmt.Sprintf(
+			"cannot decode object %v: %v",
+			oid, err))
+	}
+	ks.cache[oid] = oo
+	return oo
+}
+
+func (ks *KVStore) SetObject(oo Object) {
+	oid := oo.GetObjectID()
+	if oid.IsZero() {
+		panic("cannot store object without ObjectID")
+	}
+	bz, dts, err := encodeObject(oo)
+	if err != nil {
+		panic(fmt.Sprintf(
+			"cannot encode object %v: %v",
+			oid, err))
+	}
+	ks.set(objectKey(oid), bz)
+	ks.saveCache(oid)
+	ks.cache[oid] = oo
+	for _, dt := range dts {
+		ks.setTypeIfNew(dt)
+	}
+}
+
+func (ks *KVStore) DelObject(oo Object) {
+	oid := oo.GetObjectID()
+	if oid.IsZero() {
+		panic("cannot delete object without ObjectID")
+	}
+	ks.set(objectKey(oid), nil)
+	ks.saveCache(oid)
+	delete(ks.cache, oid)
+}
+
+func (ks *KVStore) saveCache(oid ObjectID) {
+	prev, ok := ks.cache[oid]
+	ks.undo = append(ks.undo, func() {
+		if ok {
+			ks.cache[oid] = prev
+		} else {
+			delete(ks.cache, oid)
+		}
+	})
+}
+
+func (ks *KVStore) GetRealm(path string) *Realm {
+	bz := ks.get(realmKey(path))
+	if bz == nil {
+		return nil
+	}
+	counter, n := binary.Uvarint(bz)
+	if n <= 0 {
+		panic(fmt.Sprintf(
+			"corrupted realm bytes for %s", path))
+	}
+	size, n2 := binary.Uvarint(bz[n:])
+	if n2 <= 0 {
+		panic(fmt.Sprintf(
+			"corrupted realm bytes for %s", path))
+	}
+	rlm := NewRealm(path)
+	rlm.Counter = counter
+	rlm.Size = int64(size)
+	return rlm
+}
+
+func (ks *KVStore) GetRealmPath(rid RealmID) string {
+	return string(ks.get(realmIDKey(rid)))
+}
+
+func (ks *KVStore) SetRealm(rlm *Realm) {
+	bz := uvarintBytes(rlm.Counter)
+	bz = append(bz, uvarintBytes(uint64(rlm.Size))...)
+	ks.set(realmKey(rlm.Path), bz)
+	ks.set(realmIDKey(rlm.ID), []byte(rlm.Path))
+}
+
+// Sets the resolver of the packages that stored objects and
+// types refer to, which are not persisted by the store, e.g.
+// packages with native Go bindings.
+// Objects and types are resolved by the resolver first, if
+// known, or else by the store itself.
+func (ks *KVStore) SetResolver(rs Resolver) {
+	ks.rs = rs
+}
+
+// Resolves objects from the store, and the rest with the
+// store's resolver, if any, besides std.
+type kvResolver struct {
+	ks *KVStore
+}
+
+func (kr kvResolver) GetObject(oid ObjectID) Object {
+	if kr.ks.rs != nil {
+		if oo := kr.ks.rs.GetObject(oid); oo != nil {
+			return oo
+		}
+	}
+	return kr.ks.GetObject(oid)
+}
+
+func (kr kvResolver) GetType(tid TypeID) Type {
+	if kr.ks.rs != nil {
+		if t := kr.ks.rs.GetType(tid); t != nil {
+			return t
+		}
+	}
+	return kr.ks.GetType(tid)
+}
+
+func (kr kvResolver) GetPackage(pkgPath string) *PackageValue {
+	if pkgPath == "std" {
+		return StdPackage()
+	}
+	if kr.ks.rs != nil {
+		if pv := kr.ks.rs.GetPackage(pkgPath); pv != nil {
+			return pv
+		}
+	}
+	return kr.ks.GetPackage(pkgPath)
+}
+
+func (ks *KVStore) GetType(tid TypeID) Type {
+	if t, ok := ks.types[tid]; ok {
+		return t
+	}
+	bz := ks.get(typeKey(tid))
+	if bz == nil {
+		return nil
+	}
+	// cached before decoding, as it may refer to itself.
+	dt := &DeclaredType{}
+	ks.types[tid] = dt
+	t, err := decodeType(bz, kvResolver{ks}, dt)
+	if err != nil {
+		delete(ks.types, tid)
+		panic(fmt.Sprintf(
+			"cannot decode type %v: %v",
+			tid, err))
+	}
+	ks.types[tid] = t
+	return t
+}
+
+func (ks *KVStore) SetType(t Type) {
+	tid := t.TypeID()
+	bz, dts, err := encodeType(t)
+	if err != nil {
+		panic(fmt.Sprintf(
+			"cannot encode type %s: %v",
+			t.String(), err))
+	}
+	ks.set(typeKey(tid), bz)
+	ks.saveType(tid)
+	ks.types[tid] = t
+	for _, dt := range dts {
+		ks.setTypeIfNew(dt)
+	}
+}
+
+// Persists dt and the types it refers to, unless already
+// persisted.
+func (ks *KVStore) setTypeIfNew(dt *DeclaredType) {
+	tid := dt.TypeID()
+	if _, ok := ks.types[tid]; ok {
+		return
+	}
+	if ks.get(typeKey(tid)) != nil {
+		ks.types[tid] = dt
+		return
+	}
+	ks.SetType(dt)
+}
+
+func (ks *KVStore) saveType(tid TypeID) {
+	prev, ok := ks.types[tid]
+	ks.undo = append(ks.undo, func() {
+		if ok {
+			ks.types[tid] = prev
+		} else {
+			delete(ks.types, tid)
+		}
+	})
+}
+
+// Loads the package from its preprocessed package node, without
+// parsing or preprocessing its files again.  The package block
+// of a realm is loaded from the store, while the declarations
+// of other packages are run again.
+func (ks *KVStore) GetPackage(pkgPath string) *PackageValue {
+	if pv, ok := ks.pkgs[pkgPath]; ok {
+		return pv
+	}
+	bz := ks.get(packageKey(pkgPath))
+	if bz == nil {
+		return nil
+	}
+	pn, err := DecodePackageNode(bz, kvResolver{ks})
+	if err != nil {
+		panic(fmt.Sprintf(
+			"cannot decode package %s: %v",
+			pkgPath, err))
+	}
+	// the package's own types, unless already loaded.
+	for _, dt := range declaredTypesOf(pn) {
+		if _, ok := ks.types[dt.TypeID()]; !ok {
+			ks.types[dt.TypeID()] = dt
+		}
+	}
+	pv := pn.NewPackage(NewStoreRealmer(ks))
+	// cached before loading, as the package block may
+	// refer to the package.
+	ks.pkgs[pkgPath] = pv
+	if rlm := pv.GetRealm(); rlm != nil {
+		pbid := pv.Block.GetObjectID()
+		bz := ks.get(objectKey(pbid))
+		if bz == nil {
+			return pv // not yet finalized.
+		}
+		err := decodePackageBlock(bz, kvResolver{ks}, pv)
+		if err != nil {
+			panic(fmt.Sprintf(
+				"cannot decode package block of %s: %v",
+				pkgPath, err))
+		}
+		ks.cache[pbid] = &pv.Block
+		rlm.bindObject(&pv.Block)
+	} else {
+		// pure packages are not persisted with state, so
+		// they are initialized again as by AddPackage().
+		m := NewMachineWithOptions(MachineOptions{
+			Package:  pv,
+			Output:   ioutil.Discard,
+			Importer: kvResolver{ks}.GetPackage,
+		})
+		m.runFileDeclarations()
+		if _, ok := pn.GetLocalIndex("init"); ok {
+			m.RunStatement(S(Call(X("init"))))
+		}
+	}
+	return pv
+}
+
+// Persists the preprocessed package node of pv, along with its
+// declared types, such that other packages may refer to them.
+// The package block of a realm is persisted as an object.
+func (ks *KVStore) SetPackage(pv *PackageValue) {
+	pn := pv.Source.(*PackageNode)
+	bz, err := EncodePackageNode(pn)
+	if err != nil {
+		panic(fmt.Sprintf(
+			"cannot encode package %s: %v",
+			pv.PkgPath, err))
+	}
+	ks.set(packageKey(pv.PkgPath), bz)
+	prev, ok := ks.pkgs[pv.PkgPath]
+	ks.undo = append(ks.undo, func() {
+		if ok {
+			ks.pkgs[pv.PkgPath] = prev
+		} else {
+			delete(ks.pkgs, pv.PkgPath)
+		}
+	})
+	ks.pkgs[pv.PkgPath] = pv
+	for _, dt := range declaredTypesOf(pn) {
+		ks.setTypeIfNew(dt)
+	}
+}
+
+func (ks *KVStore) GetObjectSnapshot(oid ObjectID, oh ValueHash) *ObjectSnapshot {
+	bz := ks.get(snapshotKey(oid, oh))
+	if bz == nil {
+		return nil
+	}
+	os, err := DecodeObjectSnapshot(bz)
+	if err != nil {
+		panic(fmt.Sprintf(
+			"cannot decode snapshot of object %v at hash %X: %v",
+			oid, oh, err))
+	}
+	return os
+}
+
+func (ks *KVStore) SetObjectSnapshot(os *ObjectSnapshot) {
+	bz, err := EncodeObjectSnapshot(os)
+	if err != nil {
+		panic(fmt.Sprintf(
+			"cannot encode snapshot of object %v: %v",
+			os.ObjectID, err))
+	}
+	ks.set(snapshotKey(os.ObjectID, os.Hash), bz)
+}
+
+// Reads through staged writes.
+func (ks *KVStore) get(key []byte) []byte {
+	if bz, ok := ks.batch[string(key)]; ok {
+		return bz
+	}
+	return ks.db.Get(key)
+}
+
+// Stages a write of value to key, or a delete if value is nil.
+func (ks *KVStore) set(key []byte, value []byte) {
+	if ks.batch == nil {
+		ks.batch = make(map[string][]byte)
+	}
+	if _, ok := ks.batch[string(key)]; !ok {
+		ks.keys = append(ks.keys, string(key))
+	}
+	ks.batch[string(key)] = value
+}
+
+// Writes the staged writes to the database, in the order they
+// were first staged.
+func (ks *KVStore) Commit() {
+	for _, key := range ks.keys {
+		if bz := ks.batch[key]; bz == nil {
+			ks.db.Delete([]byte(key))
+		} else {
+			ks.db.Set([]byte(key), bz)
+		}
+	}
+	ks.batch = nil
+	ks.keys = nil
+	ks.undo = nil
+}
+
+// Drops the staged writes, and reverts the caches to match.
+func (ks *KVStore) Discard() {
+	for i := len(ks.undo) - 1; 0 <= i; i-- {
+		ks.undo[i]()
+	}
+	ks.batch = nil
+	ks.keys = nil
+	ks.undo = nil
+}
+
+// Returns the types declared at the package level of pn.
+func declaredTypesOf(pn *PackageNode) (dts []*DeclaredType) {
+	for _, tv := range pn.Values {
+		if tv.T == nil || tv.T.Kind() != TypeKind {
+			continue
+		}
+		if dt, ok := tv.GetType().(*DeclaredType); ok {
+			if dt.PkgPath == pn.PkgPath {
+				dts = append(dts, dt)
+			}
+		}
+	}
+	return
+}
+
+func objectKey(oid ObjectID) []byte {
+	return []byte("oid:" + hex.EncodeToString(oid.Bytes()))
+}
+
+func realmKey(path string) []byte {
+	return []byte("rlm:" + path)
+}
+
+func realmIDKey(rid RealmID) []byte {
+	return []byte("rid:" + hex.EncodeToString(rid.Bytes()))
+}
+
+func typeKey(tid TypeID) []byte {
+	return []byte("tid:" + hex.EncodeToString(tid.Bytes()))
+}
+
+func packageKey(pkgPath string) []byte {
+	return []byte("pkg:" + pkgPath)
+}
+
+func snapshotKey(oid ObjectID, oh ValueHash) []byte {
+	return []byte("snp:" + hex.EncodeToString(oid.Bytes()) +
+		":" + hex.EncodeToString(oh[:]))
+}
+
+//----------------------------------------
+// KVDB
+
+// A simple key/value database.
+// Get returns nil if the key does not exist.
+type KVDB interface {
+	Get(key []byte) []byte
+	Set(key []byte, value []byte)
+	Delete(key []byte)
+}
+
+// MemDB is an in-memory KVDB.
+type MemDB struct {
+	kvs map[string][]byte
+}
+
+var _ KVDB = &MemDB{}
+
+func NewMemDB() *MemDB {
+	return &MemDB{
+		kvs: make(map[string][]byte),
+	}
+}
+
+func (mdb *MemDB) Get(key []byte) []byte {
+	return mdb.kvs[string(key)]
+}
+
+func (mdb *MemDB) Set(key []byte, value []byte) {
+	bz := make([]byte, len(value))
+	copy(bz, value)
+	mdb.kvs[string(key)] = bz
+}
+
+func (mdb *MemDB) Delete(key []byte) {
+	delete(mdb.kvs, string(key))
+}
+
+// FileDB is an on-disk KVDB that stores each value in its own
+// file, named by the hex encoding of its key, or by the hash of
+// its key if too long for a filename.
+type FileDB struct {
+	dir string
+}
+
+var _ KVDB = &FileDB{}
+
+func NewFileDB(dir string) (*FileDB, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDB{dir: dir}, nil
+}
+
+// Hex names are at most this long, leaving room for the
+// suffix of temporary files within the usual 255 byte limit.
+const maxFileDBNameLen = 200
+
+// Hashed names are prefixed such that they never collide with
+// hex names.
+func (fdb *FileDB) path(key []byte) string {
+	name := hex.EncodeToString(key)
+	if len(name) > maxFileDBNameLen {
+		hash := sha256.Sum256(key)
+		name = "sha256-" + hex.EncodeToString(hash[:])
+	}
+	return filepath.Join(fdb.dir, name)
+}
+
+func (fdb *FileDB) Get(key []byte) []byte {
+	bz, err := ioutil.ReadFile(fdb.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+	return bz
+}
+
+func (fdb *FileDB) Set(key []byte, value []byte) {
+	// write to a temporary file first so that
+	// a crash never leaves a partial value.
+	path := fdb.path(key)
+	temp := path + ".tmp"
+	err := ioutil.WriteFile(temp, value, 0600)
+	if err != nil {
+		panic(err)
+	}
+	err = os.Rename(temp, path)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (fdb *FileDB) Delete(key []byte) {
+	err := os.Remove(fdb.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+}
@@ -0,0 +1,353 @@
+package gno
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jaekwon/testify/assert"
+)
+
+func TestMemStoreFinalize(t *testing.T) {
+	store := NewMemStore()
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	_, r := runMain(MachineOptions{Package: pv}, `package test
+var root interface{}
+func main() {
+	root = 1
+}`)
+	assert.Nil(t, r)
+	rlm := pv.GetRealm()
+	pbid := pv.Block.GetObjectID()
+	assert.False(t, pbid.IsZero())
+	assert.Equal(t, store.GetObject(pbid), Object(&pv.Block))
+	srlm := store.GetRealm("gno.land/r/test")
+	assert.NotNil(t, srlm)
+	assert.Equal(t, srlm.ID, rlm.ID)
+	assert.Equal(t, srlm.Counter, rlm.Counter)
+	assert.Equal(t, srlm.Size, rlm.Size)
+}
+
+func TestKVStoreFileDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gno-store")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	db, err := NewFileDB(dir)
+	assert.Nil(t, err)
+	store := NewKVStore(db)
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	_, r := runMain(MachineOptions{Package: pv}, `package test
+var root interface{}
+func main() {
+	root = 1
+}`)
+	assert.Nil(t, r)
+	pbid := pv.Block.GetObjectID()
+	assert.NotNil(t, db.Get(objectKey(pbid)))
+	// realm index survives a new store on the same directory.
+	db2, err := NewFileDB(dir)
+	assert.Nil(t, err)
+	srlm := NewKVStore(db2).GetRealm("gno.land/r/test")
+	assert.NotNil(t, srlm)
+	assert.Equal(t, srlm.ID, pv.GetRealm().ID)
+	assert.Equal(t, srlm.Counter, pv.GetRealm().Counter)
+	assert.Equal(t, srlm.Size, pv.GetRealm().Size)
+	// deletes are persisted.
+	store.DelObject(&pv.Block)
+	store.Commit()
+	assert.Nil(t, db.Get(objectKey(pbid)))
+	// keys too long for a filename are hashed.
+	long := "gno.land/r/" + strings.Repeat("x", 200)
+	store.SetRealm(NewRealm(long))
+	store.Commit()
+	srlm = NewKVStore(db2).GetRealm(long)
+	assert.NotNil(t, srlm)
+	assert.Equal(t, srlm.Path, long)
+}
+
+func TestKVStoreCommit(t *testing.T) {
+	db := NewMemDB()
+	store := NewKVStore(db)
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	m, r := runMain(MachineOptions{Package: pv}, `package test
+import "std"
+type Item struct {
+	Name string
+}
+var first *Item
+var path func() string
+func main() {
+	first = &Item{Name: "a"}
+}
+func setPath() {
+	first = &Item{Name: "b"}
+	path = std.CallerRealmPath
+}`)
+	assert.Nil(t, r)
+	pbid := pv.Block.GetObjectID()
+	assert.NotNil(t, db.Get(objectKey(pbid)))
+	nkvs := len(db.kvs)
+
+	// writes are staged until committed.
+	store.DelObject(&pv.Block)
+	assert.Nil(t, store.GetObject(pbid))
+	assert.NotNil(t, db.Get(objectKey(pbid)))
+	store.Discard()
+	assert.Equal(t, store.GetObject(pbid), Object(&pv.Block))
+
+	// the new item is encoded before the package block, which
+	// fails to encode, and neither is persisted.
+	r = catchPanic(func() {
+		m.RunStatement(S(Call(X("setPath"))))
+	})
+	assert.Contains(t, fmt.Sprint(r), "cannot encode native function")
+	assert.Equal(t, len(db.kvs), nkvs)
+	assert.Equal(t, store.GetObject(pbid), Object(&pv.Block))
+}
+
+// Counts loads from the underlying store.
+type countingStore struct {
+	Store
+	gets  int
+	snaps int
+}
+
+func (cs *countingStore) GetObject(oid ObjectID) Object {
+	cs.gets++
+	return cs.Store.GetObject(oid)
+}
+
+func (cs *countingStore) GetObjectSnapshot(oid ObjectID, oh ValueHash) *ObjectSnapshot {
+	cs.snaps++
+	return cs.Store.GetObjectSnapshot(oid, oh)
+}
+
+func TestLazyLoad(t *testing.T) {
+	store := &countingStore{Store: NewMemStore()}
+	rlm := NewRealm("gno.land/r/test")
+	rlm.SetStore(store)
+	st := &StructType{
+		PkgPath: "gno.land/r/test",
+		Fields:  []FieldType{{Name: "X", Type: IntType}},
+	}
+	pst := &StructType{
+		PkgPath: "gno.land/r/test",
+		Fields: []FieldType{
+			{Name: "A", Type: st},
+			{Name: "B", Type: st},
+		},
+	}
+	x := TypedValue{T: IntType}
+	x.SetInt(42)
+	coid := ObjectID{rlm.ID, 2}
+	child := &StructValue{
+		ObjectInfo: ObjectInfo{ID: coid},
+		Fields:     []TypedValue{x},
+	}
+	poid := ObjectID{rlm.ID, 1}
+	parent := &StructValue{
+		ObjectInfo: ObjectInfo{ID: poid},
+		Fields: []TypedValue{
+			{T: st, V: RefValue{ObjectID: coid}},
+			{T: st, V: RefValue{ObjectID: coid}},
+		},
+	}
+	store.SetObject(child)
+	store.SetObject(parent)
+
+	// loading the parent does not load its children.
+	pv := rlm.GetObject(poid).(*StructValue)
+	assert.Equal(t, store.gets, 1)
+	_, isRef := pv.Fields[0].V.(RefValue)
+	assert.True(t, isRef)
+
+	// children are loaded upon access.
+	ptv := TypedValue{T: pst, V: pv}
+	av := ptv.GetValueRefAt(NewValuePath("A", 1, 0))
+	assert.Equal(t, store.gets, 2)
+	assert.Equal(t, av.V, Value(child))
+	xv := av.GetValueRefAt(NewValuePath("X", 1, 0))
+	assert.Equal(t, xv.GetInt(), 42)
+
+	// the same object is not loaded twice per transaction.
+	bv := ptv.GetValueRefAt(NewValuePath("B", 1, 0))
+	assert.Equal(t, store.gets, 2)
+	assert.Equal(t, av.V, bv.V)
+
+	// the cache is cleared upon finalization.
+	rlm.FinalizeRealmTransaction()
+	rlm.GetObject(poid)
+	assert.Equal(t, store.gets, 3)
+}
+
+// Resolves pv only, such that types are loaded from the store.
+type packageResolver struct {
+	pv *PackageValue
+}
+
+func (pr packageResolver) GetObject(oid ObjectID) Object {
+	return nil
+}
+
+func (pr packageResolver) GetType(tid TypeID) Type {
+	return nil
+}
+
+func (pr packageResolver) GetPackage(pkgPath string) *PackageValue {
+	if pkgPath == pr.pv.PkgPath {
+		return pr.pv
+	}
+	return nil
+}
+
+func TestKVStoreTypes(t *testing.T) {
+	db := NewMemDB()
+	code := `package test
+type Item struct {
+	Name string
+}
+func (it *Item) Greet() string {
+	return "hello " + it.Name
+}
+var first *Item
+var other *Item
+func main() {
+	first = &Item{Name: "a"}
+}
+func greet() {
+	println(other.Greet())
+}`
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(NewKVStore(db)))
+	_, r := runMain(MachineOptions{Package: pv}, code)
+	assert.Nil(t, r)
+	itv := pv.Block.Values[pv.Source.GetPathForName("Item").Index]
+	tid := itv.GetType().TypeID()
+	// declared types are persisted along with objects.
+	assert.NotNil(t, db.Get(typeKey(tid)))
+	firstv := pv.Block.Values[pv.Source.GetPathForName("first").Index]
+	oid := firstv.V.(PointerValue).TypedValue.V.(Object).GetObjectID()
+
+	// upon restart, the type is restored from the store,
+	// including its methods.
+	store := NewKVStore(db)
+	pn2 := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv2 := pn2.NewPackage(NewStoreRealmer(store))
+	buf := new(bytes.Buffer)
+	m := NewMachineWithOptions(MachineOptions{
+		Package: pv2,
+		Output:  buf,
+	})
+	m.RunFiles(MustParseFile("main.go", code))
+	store.SetResolver(packageResolver{pv2})
+	dt := store.GetType(tid).(*DeclaredType)
+	assert.NotEqual(t, dt, pv2.Block.Values[pn2.GetPathForName("Item").Index].GetType())
+	assert.Equal(t, dt.TypeID(), tid)
+	assert.Equal(t, len(dt.Methods), 1)
+	assert.Equal(t, dt.Methods[0].V.(*FuncValue).Name, Name("Greet"))
+	assert.Equal(t, dt.Methods[0].V.(*FuncValue).GetPackage(), pv2)
+
+	// and the stored object can be decoded and called.
+	sv := pv2.GetRealm().GetObject(oid).(*StructValue)
+	assert.Equal(t, string(sv.Fields[0].GetString()), "a")
+	otherv := &pv2.Block.Values[pn2.GetPathForName("other").Index]
+	otherv.V = PointerValue{TypedValue: &TypedValue{T: dt, V: sv}}
+	m.RunStatement(S(Call(X("greet"))))
+	assert.Equal(t, buf.String(), "hello a\n")
+}
+
+func TestKVStorePackages(t *testing.T) {
+	db := NewMemDB()
+	store := NewKVStore(db)
+	// a package, imported by the realm below.
+	upn := NewPackageNode("util", "gno.land/p/util", &FileSet{})
+	upv := upn.NewPackage(nil)
+	m := NewMachineWithOptions(MachineOptions{
+		Package: upv,
+		Output:  ioutil.Discard,
+	})
+	m.RunFiles(MustParseFile("util.go", `package util
+var Prefix = "hello "
+func Greet(name string) string {
+	return Prefix + name
+}`))
+	store.SetPackage(upv)
+	store.Commit()
+	pn := NewPackageNode("test", "gno.land/r/test", &FileSet{})
+	pv := pn.NewPackage(NewStoreRealmer(store))
+	m = NewMachineWithOptions(MachineOptions{
+		Package:  pv,
+		Output:   ioutil.Discard,
+		Importer: store.GetPackage,
+	})
+	m.RunFiles(MustParseFile("main.go", `package test
+import "gno.land/p/util"
+type Item struct {
+	Name string
+}
+func (it *Item) Greet() string {
+	return util.Greet(it.Name)
+}
+const answer = 42
+var first *Item
+var count = answer
+var next = func() int {
+	count++
+	return count
+}
+func main() {
+	first = &Item{Name: "a"}
+	next()
+}
+func greet() {
+	println(first.Greet(), count, next())
+}`))
+	m.RunMain()
+	store.SetPackage(pv)
+	store.Commit()
+	assert.NotNil(t, db.Get(packageKey("gno.land/r/test")))
+
+	// upon restart, packages are loaded by path, without
+	// parsing or preprocessing their files again.
+	store2 := NewKVStore(db)
+	pv2 := store2.GetPackage("gno.land/r/test")
+	assert.NotNil(t, pv2)
+	assert.Equal(t, store2.GetPackage("gno.land/r/test"), pv2)
+	pn2 := pv2.Source.(*PackageNode)
+	assert.NotEqual(t, pn2, pn)
+	fn := pn2.FileSet.GetFileByName("main.go")
+	assert.Equal(t, fn.GetAttribute(ATTR_PREPROCESSED), true)
+	assert.Equal(t, pv2.FBlocks["main.go"].Source, BlockNode(fn))
+	idx := pn2.GetPathForName("answer").Index
+	assert.Equal(t, pn2.Values[idx].GetInt(), 42)
+	// the encoding is deterministic.
+	bz, err := EncodePackageNode(pn2)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(bz, db.Get(packageKey("gno.land/r/test"))))
+	// the realm's package block is restored from the store.
+	assert.Equal(t, pv2.Block.GetObjectID(), pv.Block.GetObjectID())
+	assert.Equal(t, pv2.Block.Hash, pv.Block.Hash)
+	assert.Equal(t, pv2.GetRealm().Counter, pv.GetRealm().Counter)
+	// and imports are loaded by path, with declarations run.
+	upv2 := store2.GetPackage("gno.land/p/util")
+	idx = upv2.Source.GetPathForName("Prefix").Index
+	assert.Equal(t, string(upv2.Values[idx].GetString()), "hello ")
+	// the declared type is shared with the store.
+	idx = pn2.GetPathForName("Item").Index
+	dt := pv2.Values[idx].GetType()
+	assert.Equal(t, store2.GetType(dt.TypeID()), dt)
+
+	buf := new(bytes.Buffer)
+	m = NewMachineWithOptions(MachineOptions{
+		Package: pv2,
+		Output:  buf,
+	})
+	m.RunStatement(S(Call(X("greet"))))
+	assert.Equal(t, buf.String(), "hello a 43 44\n")
+}
@@ -0,0 +1,24 @@
+package main
+
+func main() {
+	a := [3]byte{1, 2, 3}
+	b := [4]byte{2: 7, 3: 9}
+	var c [2]byte
+	c[1] = 9
+	c[1] += 1
+	c[0]++
+	d := a
+	d[0] = 5
+	a[1] = 4
+	println(a[0], a[1], a[2], b[2], b[3], c[0], c[1], d[0], len(a))
+	for i, x := range b {
+		println(i, x)
+	}
+}
+
+// Output:
+// 1 4 3 7 9 1 10 5 3
+// 0 0
+// 1 0
+// 2 7
+// 3 9
@@ -0,0 +1,18 @@
+package main
+
+func main() {
+	var s []int
+	s = append(s, 1, 2)
+	println(len(s), s[1])
+	var b []byte
+	b = append(b, 'x')
+	println(len(b), string(b))
+	var n []int
+	n = append(n, n...)
+	println(len(n), n == nil)
+}
+
+// Output:
+// 2 2
+// 1 x
+// 0 true
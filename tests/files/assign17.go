@@ -0,0 +1,16 @@
+package main
+
+func pair() (int, string) {
+	return 1, "a"
+}
+
+func main() {
+	x, y := pair()
+	println(x, y)
+	x, y = pair()
+	println(x, y)
+}
+
+// Output:
+// 1 a
+// 1 a
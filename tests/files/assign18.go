@@ -0,0 +1,22 @@
+package main
+
+type T struct {
+	A int
+	B string
+}
+
+func pair() (int, string) {
+	return 2, "b"
+}
+
+func main() {
+	var t T
+	s := []int{0, 0}
+	m := map[string]string{}
+	t.A, t.B = pair()
+	s[1], m["k"] = pair()
+	println(t.A, t.B, s[0], s[1], m["k"])
+}
+
+// Output:
+// 2 b 0 2 b
@@ -0,0 +1,34 @@
+package main
+
+type T struct {
+	Name string
+}
+
+func (t T) Print(suffix string) {
+	println(t.Name + suffix)
+}
+
+func f() (x int) {
+	defer func() {
+		x = x * 2
+	}()
+	x = 1
+	for i := 0; i < 3; i++ {
+		defer println("loop", i)
+	}
+	return x + 1
+}
+
+func main() {
+	t := T{Name: "t"}
+	defer t.Print("!")
+	t.Name = "changed"
+	println(f())
+}
+
+// Output:
+// loop 2
+// loop 1
+// loop 0
+// 4
+// t!
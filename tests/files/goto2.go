@@ -0,0 +1,75 @@
+package main
+
+func count(n int) int {
+	i := 0
+	if 0 < n {
+	loop:
+		i++
+		if i < n {
+			goto loop
+		}
+	} else {
+	neg:
+		i--
+		if n < i {
+			goto neg
+		}
+	}
+	return i
+}
+
+func sum(xs []int) int {
+	s := 0
+	for _, x := range xs {
+		if x == 0 {
+			break
+		} else {
+		again:
+			if x < 0 {
+				x += 10
+				goto again
+			}
+			if 5 < x {
+				continue
+			}
+			s += x
+		}
+	}
+	return s
+}
+
+func main() {
+	println(count(3), count(-2))
+	println(sum([]int{1, -7, 7, -15, 0, 2}))
+	x := 0
+	{
+		y := 10
+	again:
+		x++
+		y--
+		if x < 3 {
+			goto again
+		}
+		println(x, y)
+	}
+	println(x)
+	for i := 0; i < 2; i++ {
+		{
+			n := 0
+		inc:
+			n++
+			if n < i+2 {
+				goto inc
+			}
+			println(i, n)
+		}
+	}
+}
+
+// Output:
+// 3 -2
+// 9
+// 3 7
+// 3
+// 0 2
+// 1 3
@@ -0,0 +1,13 @@
+package main
+
+func main() {
+	if true {
+		goto L
+	} else {
+	L:
+		println("else")
+	}
+}
+
+// Error:
+// goto L jumps into block
@@ -0,0 +1,53 @@
+package main
+
+func count(n int) int {
+	i := 0
+loop:
+	if i < n {
+		i++
+		goto loop
+	}
+	return i
+}
+
+func find(x int) string {
+	for i := 0; i < 3; i++ {
+		switch i {
+		case x:
+			goto found
+		}
+	}
+	return "not found"
+found:
+	return "found"
+}
+
+func skip() {
+	for i := 0; i < 3; i++ {
+		if i == 1 {
+			goto next
+		}
+		println("loop", i)
+	next:
+	}
+}
+
+func main() {
+	println(count(3))
+	println(find(1), find(5))
+	skip()
+	switch x := 1; x {
+	case 1:
+		goto done
+		println("not reached")
+	done:
+		println("done", x)
+	}
+}
+
+// Output:
+// 3
+// found not found
+// loop 0
+// loop 2
+// done 1
@@ -0,0 +1,20 @@
+package main
+
+func main() {
+outer:
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if j == 1 {
+				continue outer
+			}
+			if i == 2 {
+				break outer
+			}
+			println(i, j)
+		}
+	}
+}
+
+// Output:
+// 0 0
+// 1 0
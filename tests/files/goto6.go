@@ -0,0 +1,12 @@
+package main
+
+func main() {
+	goto L
+	for i := 0; i < 1; i++ {
+	L:
+		println(i)
+	}
+}
+
+// Error:
+// goto L jumps into block
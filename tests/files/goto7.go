@@ -0,0 +1,11 @@
+package main
+
+func main() {
+	goto L
+	x := 1
+L:
+	println(x)
+}
+
+// Error:
+// goto L jumps over variable declaration
@@ -0,0 +1,34 @@
+package main
+
+func kind(x interface{}) string {
+	switch x.(type) {
+	case int:
+		return "int"
+	case int32:
+		return "int32"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	}
+	return "other"
+}
+
+func get() interface{} {
+	return 2
+}
+
+func main() {
+	var x interface{} = 1
+	println(kind(x), kind('a'), kind("s"), kind(true), kind(get()))
+	x = "t"
+	println(kind(x), x.(string))
+	var n int
+	n = get().(int)
+	println(n + 1)
+}
+
+// Output:
+// int int32 string bool int
+// string t
+// 3
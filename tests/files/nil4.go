@@ -0,0 +1,41 @@
+package main
+
+type E struct{}
+
+func (e *E) Error() string {
+	return "E"
+}
+
+func get() error {
+	var p *E
+	return p
+}
+
+func main() {
+	var p *E
+	var e error = p
+	var f error
+	println(p == nil, e == nil, e != nil, nil == e, f == nil)
+	println(get() == nil)
+	switch e {
+	case nil:
+		println("nil")
+	default:
+		println("typed nil")
+	}
+	switch f {
+	case nil:
+		println("nil")
+	default:
+		println("typed nil")
+	}
+	f = nil
+	println(f == nil)
+}
+
+// Output:
+// true false true false true
+// false
+// typed nil
+// nil
+// true
@@ -0,0 +1,27 @@
+// PKGPATH: gno.land/r/test
+package test
+
+type Inner struct {
+	Count int
+}
+
+type Outer struct {
+	Name  string
+	Inner *Inner
+}
+
+var root *Outer
+
+func main() {
+	root = &Outer{Name: "outer", Inner: &Inner{Count: 1}}
+	root.Inner.Count++
+	println(root.Inner.Count)
+}
+
+// Output:
+// 2
+
+// Realm:
+// c[{{A8ADA09DEE16D791FD406D629FE29BB0ED084A30} 1}]o[{{A8ADA09DEE16D791FD406D629FE29BB0ED084A30} 0}]=ValuePreimage{4:B5589B0B0419807F15C717CB204DBA1DD370FFFC:0,0,0}
+// c[{{A8ADA09DEE16D791FD406D629FE29BB0ED084A30} 2}]o[{{A8ADA09DEE16D791FD406D629FE29BB0ED084A30} 1}]=ValuePreimage{4:CB025A16B20F8BB542FD6C9E08E8BFC5CAA2DD64:0,0,0}
+// u[{{A8ADA09DEE16D791FD406D629FE29BB0ED084A30} 0}]=ValuePreimage{4:ED0E24BCF24A24236E2A9DAA70CBEE311D025EA9:0,0,0}
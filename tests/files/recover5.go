@@ -0,0 +1,26 @@
+package main
+
+func div(a, b int) (res int, err string) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = -1, "recovered"
+		}
+	}()
+	if b == 0 {
+		panic("division by zero")
+	}
+	return a / b, "ok"
+}
+
+func main() {
+	res, err := div(6, 3)
+	println(res, err)
+	res, err = div(6, 0)
+	println(res, err)
+	println(recover())
+}
+
+// Output:
+// 2 ok
+// -1 recovered
+// undefined
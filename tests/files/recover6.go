@@ -0,0 +1,24 @@
+package main
+
+func f(n int) {
+	defer println("unwind", n)
+	if n == 0 {
+		panic(42)
+	}
+	f(n - 1)
+}
+
+func main() {
+	defer func() {
+		r := recover()
+		println("recovered", r)
+	}()
+	f(2)
+	println("not reached")
+}
+
+// Output:
+// unwind 0
+// unwind 1
+// unwind 2
+// recovered 42
@@ -0,0 +1,37 @@
+package main
+
+func helper() interface{} {
+	return recover() // not called by a deferred call.
+}
+
+func f() {
+	defer func() {
+		println("helper", helper())
+		r := recover()
+		panic(r)
+	}()
+	panic("first")
+}
+
+func g() {
+	defer func() {
+		println("g", recover())
+	}()
+	defer func() {
+		panic("second")
+	}()
+	panic("first")
+}
+
+func main() {
+	defer func() {
+		println("main", recover())
+	}()
+	g()
+	f()
+}
+
+// Output:
+// g second
+// helper undefined
+// main first
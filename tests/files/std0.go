@@ -0,0 +1,11 @@
+package main
+
+import "std"
+
+func main() {
+	// main is not called by another realm.
+	println(std.CallerRealmPath() == "", std.CallerPkgPath() == "")
+}
+
+// Output:
+// true true
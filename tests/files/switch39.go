@@ -0,0 +1,60 @@
+package main
+
+type E struct{}
+
+func nilMap() map[string]int {
+	return nil
+}
+
+func main() {
+	var p *E
+	switch p {
+	case nil:
+		println("nil pointer")
+	default:
+		println("pointer")
+	}
+	p = &E{}
+	switch p {
+	case nil:
+		println("nil pointer")
+	default:
+		println("pointer")
+	}
+	var s []int
+	switch s {
+	case nil:
+		println("nil slice")
+	default:
+		println("slice")
+	}
+	s = []int{}
+	switch s {
+	case nil:
+		println("nil slice")
+	default:
+		println("slice")
+	}
+	m := nilMap()
+	switch m {
+	case nil:
+		println("nil map")
+	default:
+		println("map")
+	}
+	m = map[string]int{}
+	switch m {
+	case nil:
+		println("nil map")
+	default:
+		println("map")
+	}
+}
+
+// Output:
+// nil pointer
+// pointer
+// nil slice
+// slice
+// nil map
+// map
@@ -0,0 +1,22 @@
+package main
+
+func main() {
+	var x interface{}
+	switch y := x.(type) {
+	case nil:
+		println(y, y == nil)
+	case int:
+		println("int", y)
+	}
+	x = 1
+	switch y := x.(type) {
+	case nil:
+		println(y, y == nil)
+	case int:
+		println("int", y)
+	}
+}
+
+// Output:
+// nil true
+// int 1
@@ -0,0 +1,43 @@
+package main
+
+func name(i int) string {
+	switch j := i * 2; j {
+	default:
+		return "many"
+	case 0:
+		return "zero"
+	case 2, 4:
+		return "few"
+	}
+}
+
+func sign(i int) int {
+	switch {
+	case i < 0:
+		return -1
+	case i > 0:
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	println(name(0), name(1), name(2), name(3))
+	println(sign(-5), sign(0), sign(5))
+	for i := 0; i < 4; i++ {
+		switch i {
+		case 1:
+			continue
+		case 2:
+			break
+		}
+		println("loop", i)
+	}
+}
+
+// Output:
+// zero few few many
+// -1 0 1
+// loop 0
+// loop 2
+// loop 3
@@ -0,0 +1,21 @@
+package main
+
+func f(i int) int {
+	println("f", i)
+	return i
+}
+
+// cases are evaluated in order until one matches.
+func main() {
+	switch 2 {
+	case f(1), f(2), f(3):
+		println("matched")
+	case f(4):
+		println("not reached")
+	}
+}
+
+// Output:
+// f 1
+// f 2
+// matched
@@ -0,0 +1,20 @@
+package main
+
+func main() {
+	switch x := 1; x {
+	case 1:
+		x = 5
+		fallthrough
+	default:
+		println("default", x)
+		fallthrough
+	case 2:
+		println("two", x)
+	case 3:
+		println("not reached")
+	}
+}
+
+// Output:
+// default 5
+// two 5
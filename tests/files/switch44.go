@@ -0,0 +1,44 @@
+package main
+
+type Stringer interface {
+	String() string
+}
+
+type S struct {
+	Name string
+}
+
+func (s S) String() string {
+	return "S:" + s.Name
+}
+
+func kind(x interface{}) string {
+	switch v := x.(type) {
+	case nil:
+		return "nil"
+	case int:
+		return "int"
+	case string, bool:
+		switch v {
+		case "a", true:
+			return "a or true"
+		}
+		return "string or bool"
+	case Stringer:
+		return "stringer"
+	case *S:
+		return "*S " + v.Name
+	default:
+		return "unknown"
+	}
+}
+
+func main() {
+	var x interface{}
+	println(kind(x), kind(1), kind("a"), kind(true), kind("b"))
+	println(kind(S{"s"}), kind(&S{"p"}), kind([]int{1}))
+}
+
+// Output:
+// nil int a or true a or true string or bool
+// stringer *S p unknown
@@ -0,0 +1,13 @@
+package main
+
+func main() {
+	switch 1 {
+	case 1:
+		fallthrough
+		println(1)
+	case 2:
+	}
+}
+
+// Error:
+// fallthrough statement out of place
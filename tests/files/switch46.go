@@ -0,0 +1,11 @@
+package main
+
+func main() {
+	switch 1 {
+	case 1:
+		fallthrough
+	}
+}
+
+// Error:
+// cannot fallthrough final case in switch
@@ -0,0 +1,13 @@
+package main
+
+func main() {
+	var x interface{}
+	switch x.(type) {
+	case int:
+		fallthrough
+	default:
+	}
+}
+
+// Error:
+// cannot fallthrough in type switch
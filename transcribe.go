@@ -577,9 +577,11 @@ func transcribe(t Transform, ns []Node, ftype TransField, index int, n Node, nc
 				return
 			}
 		}
-		cnn.X = transcribe(t, nns, TRANS_SWITCH_X, 0, cnn.X, &c).(Expr)
-		if isStop(nc, c) {
-			return
+		if cnn.X != nil {
+			cnn.X = transcribe(t, nns, TRANS_SWITCH_X, 0, cnn.X, &c).(Expr)
+			if isStop(nc, c) {
+				return
+			}
 		}
 		for idx, _ := range cnn.Cases {
 			cnn.Cases[idx] = *transcribe(t, nns, TRANS_SWITCH_CASE, idx, &cnn.Cases[idx], &c).(*SwitchCaseStmt)
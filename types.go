@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 //----------------------------------------
@@ -53,6 +54,7 @@ func (*FuncType) assertType()      {}
 func (*MapType) assertType()       {}
 func (*InterfaceType) assertType() {}
 func (*TypeType) assertType()      {}
+func (*WeakRefType) assertType()   {}
 func (*DeclaredType) assertType()  {}
 func (*PackageType) assertType()   {}
 func (*ChanType) assertType()      {}
@@ -218,11 +220,40 @@ func (pt PrimitiveType) Elem() Type {
 
 type Tag string
 
+// Parses the "gno" key of the tag, e.g. `gno:"owned"`.
+// For compatibility with the README, `gno:owned` is also
+// accepted.  Returns an error for unknown gno options.
+func (tag Tag) ParseGnoTag() (owned bool, err error) {
+	val, ok := reflect.StructTag(tag).Lookup("gno")
+	if !ok {
+		for _, part := range strings.Fields(string(tag)) {
+			if strings.HasPrefix(part, "gno:") {
+				val, ok = strings.TrimPrefix(part, "gno:"), true
+				break
+			}
+		}
+	}
+	if !ok {
+		return false, nil
+	}
+	for _, opt := range strings.Split(val, ",") {
+		switch opt {
+		case "owned":
+			owned = true
+		default:
+			return false, fmt.Errorf(
+				"unknown gno tag option %q", opt)
+		}
+	}
+	return owned, nil
+}
+
 type FieldType struct {
 	Name     Name
 	Type     Type
 	Embedded Name
 	Tag      Tag
+	Owned    bool // if struct field tagged gno:"owned".
 }
 
 func (ft FieldType) Kind() Kind {
@@ -528,6 +559,11 @@ func (st *StructType) Elem() Type {
 	panic("struct types have no (universal) elements")
 }
 
+// Returns true if the (flattened) i'th field is tagged as owned.
+func (st *StructType) IsOwnedField(i int) bool {
+	return st.Fields[i].Owned
+}
+
 func (st *StructType) GetPathForName(n Name) ValuePath {
 	for i := 0; i < len(st.Fields); i++ {
 		ft := st.Fields[i]
@@ -844,6 +880,34 @@ func (tt *TypeType) Elem() Type {
 	panic("typeval types have no elements")
 }
 
+//----------------------------------------
+// Weak reference type
+// A weakref refers to an object without owning it, nor
+// incrementing its refcount.  See makeweak() and derefweak()
+// in uverse.
+
+type WeakRefType struct {
+	// nothing yet.
+}
+
+var gWeakRefType = &WeakRefType{}
+
+func (wt *WeakRefType) Kind() Kind {
+	return WeakRefKind
+}
+
+func (wt *WeakRefType) TypeID() TypeID {
+	return typeid("weakref")
+}
+
+func (wt *WeakRefType) String() string {
+	return string("weakref")
+}
+
+func (wt *WeakRefType) Elem() Type {
+	panic("weakref types have no elements")
+}
+
 //----------------------------------------
 // Declared type
 // Declared types have a name, base (underlying) type,
@@ -885,10 +949,12 @@ func (dt *DeclaredType) Kind() Kind {
 	return dt.Base.Kind()
 }
 
+// Declared types are identified by package path and name,
+// not by their base, which may refer to the declared type
+// itself.
 func (dt *DeclaredType) TypeID() TypeID {
 	if dt.typeid.IsZero() {
-		dt.typeid = typeid("%s.%s=%s",
-			dt.PkgPath, dt.Name, dt.Base.TypeID().String())
+		dt.typeid = typeid("%s.%s", dt.PkgPath, dt.Name)
 	}
 	return dt.typeid
 }
@@ -969,7 +1035,8 @@ func (dt *DeclaredType) GetMethod(n Name) *FuncValue {
 func (dt *DeclaredType) Implements(ot *InterfaceType) bool {
 	for _, om := range ot.Methods {
 		if dm := dt.GetMethod(om.Name); dm != nil {
-			dmtid := dm.Type.TypeID()
+			// method types include the receiver.
+			dmtid := dm.Type.BoundType().TypeID()
 			omtid := om.Type.TypeID()
 			if dmtid != omtid {
 				return false
@@ -1148,7 +1215,8 @@ const (
 	MapKind
 	TypeKind // not in go.
 	// UnsafePointerKind
-	BlockKind // not in go.
+	BlockKind   // not in go.
+	WeakRefKind // not in go.
 )
 
 // This is generally slower than switching on baseOf(t).
@@ -1211,6 +1279,8 @@ func KindOf(t Type) Kind {
 		return MapKind
 	case *TypeType:
 		return TypeKind
+	case *WeakRefType:
+		return WeakRefKind
 	case *nativeType:
 		return t.Kind()
 	case blockType:
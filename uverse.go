@@ -33,32 +33,7 @@ func UverseNode() *PackageNode {
 
 	// temporary convenience function; type is filled later by caller.
 	defNative := func(n Name, ps, rs FieldTypeExprs, native func(*Machine)) {
-		fd := FuncD(n, ps, rs, nil)
-		// Preprocess sets v.Source.Name on .Source.StaticBlock.
-		fd = Preprocess(nil, uverseNode, fd).(*FuncDecl)
-		ft := evalType(uverseNode, &fd.Type).(*FuncType)
-		if debug {
-			if ft == nil {
-				panic("should not happen")
-			}
-		}
-		/*
-			tv := TypedValue{
-				T: ft,
-				V: &FuncValue{
-					Type:       ft,
-					Source:     fd,
-					Name:       n,
-					NativeBody: native,
-				},
-			}
-		*/
-		// Set the native override function,
-		// which doesn't get interpeted as it
-		// doesn't exist in the declaration node.
-		fv := uverseNode.GetValueRef(n).V.(*FuncValue)
-		fv.NativeBody = native
-		// fv.Closure, fv.pkg set during .NewPackage().
+		uverseNode.DefineNative(n, ps, rs, native)
 	}
 
 	// Primitive types
@@ -95,6 +70,7 @@ func UverseNode() *PackageNode {
 	// value of a "typeval" value is represented by a TypeValue.
 	def("typeval", asValue(gTypeType))
 	def("error", asValue(&InterfaceType{})) // XXX define somehow.
+	def("weakref", asValue(gWeakRefType))
 
 	// Values
 	def("true", untypedBool(true))
@@ -112,6 +88,18 @@ func UverseNode() *PackageNode {
 		func(m *Machine) {
 			arg0, arg1 := m.LastBlock().GetParams2()
 			xt := arg0.T
+			if isNil(arg1) {
+				// append(x, nil...) is x.
+				m.PushValue(*arg0)
+				return
+			}
+			if isNil(arg0) {
+				// append(nil, ???) appends to a slice w/o capacity.
+				arg0 = &TypedValue{
+					T: xt,
+					V: &SliceValue{Base: &ArrayValue{}},
+				}
+			}
 			switch xv := arg0.V.(type) {
 
 			//----------------------------------------------------------------
@@ -127,13 +115,16 @@ func UverseNode() *PackageNode {
 				case *SliceValue:
 					argsl := args.Length
 					argso := args.Offset
+					m.allocateAppend(xt, xvl, argsl, xvc)
 					if xvl+argsl <= xvc {
 						// append(*SliceValue, *SliceValue) w/i capacity -----
+						m.willUpdate(xv.Base)
 						if xv.Base.Data == nil {
 							// append(*SliceValue.List, *SliceValue) ---------
 							list := xv.Base.List
 							if args.Base.Data == nil {
-								copy(
+								copyListToList2(
+									m.Realm, xv.Base,
 									list[xvo+xvl:xvo+xvl+argsl],
 									args.Base.List[argso:argso+argsl])
 							} else {
@@ -141,6 +132,7 @@ func UverseNode() *PackageNode {
 									list[xvo+xvl:xvo+xvl+argsl],
 									args.Base.Data[argso:argso+argsl],
 									xt.Elem())
+								m.Realm.DidUpdate(xv.Base, nil, nil)
 							}
 						} else {
 							// append(*SliceValue.Data, *SliceValue) ---------
@@ -154,6 +146,7 @@ func UverseNode() *PackageNode {
 									data[xvo+xvl:xvo+xvl+argsl],
 									args.Base.Data[argso:argso+argsl])
 							}
+							m.Realm.DidUpdate(xv.Base, nil, nil)
 						}
 						m.PushValue(TypedValue{
 							T: xt,
@@ -212,8 +205,10 @@ func UverseNode() *PackageNode {
 				case *nativeValue:
 					argsrv := args.Value
 					argsl := argsrv.Len()
+					m.allocateAppend(xt, xvl, argsl, xvc)
 					if xvl+argsl <= xvc {
 						// append(*SliceValue, *nativeValue) w/i capacity ----
+						m.willUpdate(xv.Base)
 						if xv.Base.Data == nil {
 							// append(*SliceValue.List, *nativeValue) --------
 							list := xv.Base.List
@@ -227,6 +222,7 @@ func UverseNode() *PackageNode {
 								data[xvo:xvo+argsl],
 								argsrv, argsl)
 						}
+						m.Realm.DidUpdate(xv.Base, nil, nil)
 						m.PushValue(TypedValue{
 							T: xt,
 							V: &SliceValue{
@@ -291,6 +287,7 @@ func UverseNode() *PackageNode {
 					st := sv.Type()
 					argso := args.Offset
 					argsl := args.Length
+					m.allocateAppend(xt, sv.Len(), argsl, sv.Cap())
 					argsrv := reflect.MakeSlice(st, argsl, argsl)
 					if args.Base.Data == nil {
 						for i := 0; i < argsl; i++ {
@@ -317,6 +314,7 @@ func UverseNode() *PackageNode {
 				// append(*nativeValue, *nativeValue)
 				case *nativeValue:
 					argsrv := args.Value
+					m.allocateAppend(xt, sv.Len(), argsrv.Len(), sv.Cap())
 					resrv := reflect.AppendSlice(sv, argsrv)
 					m.PushValue(TypedValue{
 						T: xt,
@@ -330,6 +328,7 @@ func UverseNode() *PackageNode {
 						// TODO this might be faster if reflect supports
 						// appending this way without first converting to a slice.
 						argrv := reflect.ValueOf([]byte(arg1.V.(StringValue)))
+						m.allocateAppend(xt, sv.Len(), argrv.Len(), sv.Cap())
 						resrv := reflect.AppendSlice(sv, argrv)
 						m.PushValue(TypedValue{
 							T: xt,
@@ -414,6 +413,10 @@ func UverseNode() *PackageNode {
 				if vargsl == 1 {
 					lv := vargs.GetValueAtIndexInt(0)
 					li := lv.GetInt()
+					if li < 0 {
+						panic("make() of slice with negative length")
+					}
+					m.allocate(listSize(nil, li))
 					list := make([]TypedValue, li)
 					if et := bt.Elem(); et.Kind() == InterfaceKind {
 						// leave as is
@@ -434,6 +437,10 @@ func UverseNode() *PackageNode {
 					li := lv.GetInt()
 					cv := vargs.GetValueAtIndexInt(1)
 					ci := cv.GetInt()
+					if li < 0 || ci < li {
+						panic("make() of slice with invalid length or capacity")
+					}
+					m.allocate(listSize(nil, ci))
 					list := make([]TypedValue, li, ci)
 					if et := bt.Elem(); et.Kind() == InterfaceKind {
 						// leave as is
@@ -480,7 +487,16 @@ func UverseNode() *PackageNode {
 		},
 	)
 	def("new", undefined)
-	def("panic", undefined)
+	defNative("panic",
+		Flds( // params
+			"err", InterfaceT(nil), // args[0]
+		),
+		nil, // results
+		func(m *Machine) {
+			arg0 := m.LastBlock().GetParams1()
+			m.Panic(*arg0)
+		},
+	)
 	defNative("print",
 		Flds( // params
 			"xs", Vrd(InterfaceT(nil)), // args[0]
@@ -517,7 +533,81 @@ func UverseNode() *PackageNode {
 			m.Output.Write([]byte(rs))
 		},
 	)
-	def("recover", undefined)
+	defNative("recover",
+		nil, // params
+		Flds( // results
+			"", InterfaceT(nil),
+		),
+		func(m *Machine) {
+			ex, _ := m.Recover()
+			m.PushValue(ex)
+		},
+	)
+	defNative("makeweak",
+		Flds( // params
+			"x", InterfaceT(nil),
+		),
+		Flds( // results
+			"", "weakref",
+		),
+		func(m *Machine) {
+			arg0 := m.LastBlock().GetParams1()
+			if _, ok := baseOf(arg0.T).(PointerType); !ok {
+				panic(fmt.Sprintf(
+					"cannot make weakref of non-pointer %s",
+					arg0.String()))
+			}
+			pv := arg0.V.(PointerValue)
+			oo, ok := fillValue(pv.TypedValue).V.(Object)
+			if !ok {
+				panic(fmt.Sprintf(
+					"cannot make weakref of non-object %s",
+					arg0.String()))
+			}
+			m.PushValue(TypedValue{
+				T: gWeakRefType,
+				V: WeakRefValue{
+					TargetID: oo.GetObjectID(),
+					T:        arg0.T,
+					target:   oo,
+					realm:    m.Realm,
+				},
+			})
+		},
+	)
+	defNative("derefweak",
+		Flds( // params
+			"w", "weakref",
+		),
+		Flds( // results
+			"", InterfaceT(nil),
+			"", "bool",
+		),
+		func(m *Machine) {
+			arg0 := m.LastBlock().GetParams1()
+			var oo Object
+			wv, ok := arg0.V.(WeakRefValue)
+			if ok {
+				oo = wv.GetTarget()
+			}
+			if oo == nil {
+				// target was deleted, or w is nil.
+				m.PushValue(TypedValue{})
+				m.PushValue(typedBool(false))
+				return
+			}
+			m.PushValue(TypedValue{
+				T: wv.T,
+				V: PointerValue{
+					TypedValue: &TypedValue{
+						T: wv.T.Elem(),
+						V: oo.(Value),
+					},
+				},
+			})
+			m.PushValue(typedBool(true))
+		},
+	)
 	return uverseNode
 }
 
@@ -595,6 +685,11 @@ func printString(tv *TypedValue) string {
 	case *ChanType:
 		panic("not yet implemented")
 		//return tv.V.(*ChanValue).String()
+	case *WeakRefType:
+		if tv.V == nil {
+			return "nil"
+		}
+		return tv.V.(WeakRefValue).String()
 	case *nativeType:
 		return fmt.Sprintf("%v",
 			tv.V.(*nativeValue).Value.Interface())
@@ -616,6 +711,21 @@ func copyDataToList(dst []TypedValue, data []byte, et Type) {
 	}
 }
 
+// Like copy(dst, src) where dst is within the list of array
+// av, but also informs rlm of changed references.
+func copyListToList2(rlm *Realm, av *ArrayValue, dst, src []TypedValue) {
+	if rlm == nil || !av.GetIsReal() {
+		copy(dst, src)
+		return
+	}
+	for i := 0; i < len(src); i++ {
+		xo := dst[i].GetFirstObject()
+		dst[i] = src[i]
+		co := dst[i].GetFirstObject()
+		rlm.DidUpdate(av, xo, co)
+	}
+}
+
 func copyListToData(dst []byte, tvs []TypedValue) {
 	for i := 0; i < len(tvs); i++ {
 		dst[i] = tvs[i].GetUint8()
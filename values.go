@@ -35,6 +35,8 @@ func (*PackageValue) assertValue()    {}
 func (nativeValue) assertValue()      {}
 func (escapeValue) assertValue()      {}
 func (blockValue) assertValue()       {}
+func (RefValue) assertValue()         {}
+func (WeakRefValue) assertValue()     {}
 
 var _ Value = StringValue("")
 var _ Value = BigintValue{}
@@ -51,6 +53,8 @@ var _ Value = &PackageValue{}
 var _ Value = nativeValue{}
 var _ Value = escapeValue{}
 var _ Value = blockValue{}
+var _ Value = RefValue{}
+var _ Value = WeakRefValue{}
 
 type StringValue string
 
@@ -74,9 +78,68 @@ type DataByteValue struct {
 // A pointer to a block var may end up pointing to an escape
 // value after a block var escapes "to the heap".
 type PointerValue struct {
-	*TypedValue             // escape val if pointer to var.
-	Base        *TypedValue // array/struct/block.
-	Index       int         // list/fields/values index.
+	*TypedValue       // escape val if pointer to var.
+	Base        Value // array/struct/map/block, if any.
+	Index       int   // list/fields/values index.
+}
+
+// Returns the object pointed to, if any, whether it has a
+// base or not.  Unlike GetBase(), which returns the object
+// that contains the value pointed to.
+func (pv PointerValue) getTarget() Object {
+	if pv.TypedValue == nil {
+		return nil
+	}
+	if oo, ok := fillValue(pv.TypedValue).V.(Object); ok {
+		return oo
+	}
+	return nil
+}
+
+// Returns the object that contains the value pointed to, if any.
+func (pv PointerValue) GetBase() Object {
+	switch cb := pv.Base.(type) {
+	case nil:
+		return nil
+	case blockValue:
+		return cb.Block
+	case RefValue:
+		return cb.GetObject()
+	case Object:
+		return cb
+	default:
+		panic("should not happen")
+	}
+}
+
+// Assigns tv2 to the value pointed to.  If the base is known,
+// rlm is informed of the change of references held by it.
+func (pv PointerValue) Assign2(rlm *Realm, tv2 TypedValue) {
+	if pv.Base == nil {
+		// e.g. `*p = x` where p points to an object.
+		checkRealmAccess(rlm, pv.getTarget())
+		pv.TypedValue.Assign(tv2)
+		return
+	}
+	if rlm == nil {
+		pv.TypedValue.Assign(tv2)
+		return
+	}
+	po := pv.GetBase()
+	checkRealmAccess(rlm, po)
+	rlm.WillUpdate(po)
+	xo := pv.TypedValue.GetFirstObject()
+	if _, ok := po.(*MapValue); ok {
+		// map values are replaced, never mutated.
+		*pv.TypedValue = TypedValue{}
+	}
+	pv.TypedValue.Assign(tv2)
+	co := pv.TypedValue.GetFirstObject()
+	owned := false
+	if sv, ok := po.(*StructValue); ok {
+		owned = sv.IsOwnedField(pv.Index)
+	}
+	rlm.didUpdate(po, xo, co, owned)
 }
 
 type ArrayValue struct {
@@ -136,6 +199,13 @@ type StructValue struct {
 	ObjectInfo
 	Fields []TypedValue // flattened
 
+	st *StructType // for field tags; nil if unknown.
+}
+
+// Returns true if the (flattened) i'th field is tagged
+// gno:"owned".
+func (sv *StructValue) IsOwnedField(i int) bool {
+	return sv.st != nil && sv.st.IsOwnedField(i)
 }
 
 // If value is undefined at path, sets default value before
@@ -149,7 +219,7 @@ func (sv *StructValue) GetValueRefAt2(path ValuePath, st *StructType) *TypedValu
 				path.Name, path))
 		}
 	}
-	fv := &sv.Fields[path.Index]
+	fv := fillValue(&sv.Fields[path.Index])
 	if fv.IsUndefined() {
 		ft := st.GetStaticTypeOfAt(path)
 		if ft.Kind() == InterfaceKind {
@@ -170,6 +240,7 @@ func (sv *StructValue) Copy() *StructValue {
 	copy(fields, sv.Fields)
 	return &StructValue{
 		Fields: fields,
+		st:     sv.st,
 	}
 }
 
@@ -293,14 +364,12 @@ func (mv *MapValue) GetLength() int {
 	return mv.List.Size // panics if uninitialized
 }
 
-// Caller must write to the result immediately with a star-expression
-// rather than .Assign().
+// If the key already exists the existing slot is returned, so
+// that its prior value may be read (e.g. for `m[k] += 1`).
 func (mv *MapValue) GetValueRefForKeyForAssign(key *TypedValue) *TypedValue {
 	kmk := key.ComputeMapKey(false)
 	if mli, ok := mv.vmap[kmk]; ok {
-		// clear slot for assignment.
-		mli.Value = TypedValue{}
-		return &mli.Value
+		return fillValue(&mli.Value)
 	} else {
 		mli := mv.List.Append(*key)
 		mv.vmap[kmk] = mli
@@ -311,7 +380,7 @@ func (mv *MapValue) GetValueRefForKeyForAssign(key *TypedValue) *TypedValue {
 func (mv *MapValue) GetValueForKey(key *TypedValue) (val TypedValue, ok bool) {
 	kmk := key.ComputeMapKey(false)
 	if mli, exists := mv.vmap[kmk]; exists {
-		val, ok = mli.Value, true
+		val, ok = *fillValue(&mli.Value), true
 		return
 	} else {
 		return
@@ -326,6 +395,25 @@ func (mv *MapValue) DeleteForKey(key *TypedValue) {
 	}
 }
 
+// Returns a copy of the list and index, for Realm.Rollback().
+func (mv *MapValue) copyList() (*MapList, map[MapKey]*MapListItem) {
+	if mv.List == nil {
+		return nil, nil
+	}
+	list := &MapList{}
+	items := make(map[*MapListItem]*MapListItem, mv.List.Size)
+	for cur := mv.List.Head; cur != nil; cur = cur.Next {
+		mli := list.Append(cur.Key)
+		mli.Value = cur.Value
+		items[cur] = mli
+	}
+	vmap := make(map[MapKey]*MapListItem, len(mv.vmap))
+	for mk, mli := range mv.vmap {
+		vmap[mk] = items[mli]
+	}
+	return list, vmap
+}
+
 // The type itself as a value.
 type TypeValue struct {
 	Type Type
@@ -355,6 +443,7 @@ func (pv *PackageValue) GetRealm() *Realm {
 
 func (pv *PackageValue) SetRealm(rlm *Realm) {
 	pv.realm = rlm
+	rlm.pkg = pv
 	if !pv.Block.ObjectInfo.ID.IsZero() {
 		panic("should not happen")
 	}
@@ -384,6 +473,103 @@ type blockValue struct {
 	*Block
 }
 
+// A placeholder for a real object that has not yet been
+// loaded from the realm's store.  Accessors replace it
+// in place with the loaded object upon first access, so
+// only the objects actually read are ever loaded.
+type RefValue struct {
+	ObjectID
+	Hash ValueHash // known without loading; zero if borrowed.
+
+	realm *Realm // to load from.
+}
+
+// Loads the object referred to from the realm's store.
+func (rv RefValue) GetObject() Object {
+	if rv.realm == nil {
+		panic(fmt.Sprintf(
+			"unbound reference to object %v",
+			rv.ObjectID))
+	}
+	return rv.realm.GetObject(rv.ObjectID)
+}
+
+// A weak reference to an object, which does not own it nor
+// increment its refcount.  Only TargetID is persisted; the
+// reference becomes invalid once the target is deleted.
+type WeakRefValue struct {
+	TargetID ObjectID // zero if target not yet real.
+	T        Type     // pointer type of target.
+
+	target Object // if known; not persisted.
+	realm  *Realm // to load from.
+}
+
+// Returns the object ID of the target, which may be zero if
+// the target was never persisted.
+func (wv WeakRefValue) GetTargetID() ObjectID {
+	if wv.target != nil {
+		return wv.target.GetObjectID()
+	}
+	return wv.TargetID
+}
+
+// Returns the target object, or nil if it was deleted (or
+// released) or never persisted.  A target not yet known is
+// loaded by its realm.
+func (wv WeakRefValue) GetTarget() Object {
+	if wv.target != nil {
+		if !isWeakTarget(wv.target, wv.TargetID) {
+			return nil
+		}
+		return wv.target
+	}
+	if wv.TargetID.IsZero() || wv.realm == nil {
+		return nil
+	}
+	trlm := wv.realm.getRealm(wv.TargetID.RealmID)
+	if trlm == nil || trlm.GetStore() == nil {
+		return nil
+	}
+	oo := trlm.findObject(wv.TargetID) // nil if deleted.
+	if oo == nil || !isWeakTarget(oo, wv.TargetID) {
+		return nil
+	}
+	return oo
+}
+
+// Returns true unless oo was deleted, or released (and
+// possibly re-homed, see AIR-OT) since it was the object of
+// tid, which is zero if oo was not yet real.
+func isWeakTarget(oo Object, tid ObjectID) bool {
+	if oo.GetIsDeleted() {
+		return false
+	}
+	if oo.GetObjectInfo().released {
+		return false
+	}
+	if !tid.IsZero() && oo.GetObjectID() != tid {
+		return false
+	}
+	return true
+}
+
+// If tv.V is a RefValue, replaces it with the loaded object.
+// Returns tv for convenience.
+func fillValue(tv *TypedValue) *TypedValue {
+	if rv, ok := tv.V.(RefValue); ok {
+		switch oo := rv.GetObject().(type) {
+		case *Block:
+			tv.V = blockValue{oo}
+		case Value:
+			tv.V = oo
+		default:
+			panic("should not happen")
+		}
+	}
+	return tv
+}
+
 //----------------------------------------
 // TypedValue
 
@@ -492,6 +678,8 @@ func (tv TypedValue) Copy() (cp TypedValue) {
 	case *StructValue:
 		cp.T = tv.T
 		cp.V = cv.Copy()
+	case RefValue:
+		cp = fillValue(&tv).Copy()
 	default:
 		cp = tv
 	}
@@ -996,6 +1184,24 @@ func (tv *TypedValue) GetValueRefAtForAssign(path ValuePath) *TypedValue {
 	return tv.getValueRefAt(path, true)
 }
 
+// Like GetValueRefAtForAssign(), but also returns the
+// containing object as the pointer's base.
+func (tv *TypedValue) GetPointerTo(path ValuePath) PointerValue {
+	ref := tv.GetValueRefAtForAssign(path)
+	var base Value
+	switch cv := tv.V.(type) {
+	case *StructValue:
+		base = cv
+	case *PackageValue:
+		base = blockValue{&cv.Block}
+	}
+	return PointerValue{
+		TypedValue: ref,
+		Base:       base,
+		Index:      int(path.Index),
+	}
+}
+
 func (tv *TypedValue) getValueRefAt(path ValuePath, forAssign bool) *TypedValue {
 	if debug {
 		if tv.IsUndefined() {
@@ -1165,7 +1371,7 @@ func (tv *TypedValue) GetValueAtIndex(iv *TypedValue) TypedValue {
 		av := tv.V.(*ArrayValue)
 		ii := iv.ConvertGetInt()
 		if av.Data == nil {
-			ev := av.List[ii] // copy, leave av alone
+			ev := *fillValue(&av.List[ii]) // copy, leave av alone
 			if ev.IsUndefined() && t.Elt.Kind() != InterfaceKind {
 				ev.T = t.Elt
 				ev.V = defaultValue(t.Elt)
@@ -1192,7 +1398,7 @@ func (tv *TypedValue) GetValueAtIndex(iv *TypedValue) TypedValue {
 				ii, sv.Length))
 		}
 		if sv.Base.Data == nil {
-			ev := sv.Base.List[sv.Offset+ii] // copy, leave sv alone
+			ev := *fillValue(&sv.Base.List[sv.Offset+ii]) // copy, leave sv alone
 			if ev.IsUndefined() && t.Elt.Kind() != InterfaceKind {
 				ev.T = t.Elt
 				ev.V = defaultValue(t.Elt)
@@ -1230,6 +1436,68 @@ func (tv *TypedValue) GetValueAtIndex(iv *TypedValue) TypedValue {
 	}
 }
 
+// Like GetValueRefAtIndexForAssign(), but also returns the
+// containing object as the pointer's base.
+func (tv *TypedValue) GetPointerAtIndex(iv *TypedValue) PointerValue {
+	ref := tv.GetValueRefAtIndexForAssign(iv)
+	switch cv := tv.V.(type) {
+	case *ArrayValue:
+		return PointerValue{
+			TypedValue: ref,
+			Base:       cv,
+			Index:      iv.ConvertGetInt(),
+		}
+	case *SliceValue:
+		return PointerValue{
+			TypedValue: ref,
+			Base:       cv.Base,
+			Index:      cv.Offset + iv.ConvertGetInt(),
+		}
+	case *MapValue:
+		return PointerValue{
+			TypedValue: ref,
+			Base:       cv,
+			Index:      -1, // not addressable.
+		}
+	default:
+		return PointerValue{TypedValue: ref}
+	}
+}
+
+// Returns the object directly referred to by tv, if any.
+// Pointers refer to their base if known, or else to the
+// object pointed to.  Slices refer to their underlying array.
+func (tv *TypedValue) GetFirstObject() Object {
+	switch cv := tv.V.(type) {
+	case PointerValue:
+		if cv.Base != nil {
+			return cv.GetBase()
+		}
+		if cv.TypedValue == nil {
+			return nil
+		}
+		if oo, ok := fillValue(cv.TypedValue).V.(Object); ok {
+			return oo
+		}
+		return nil
+	case *SliceValue:
+		if cv.Base == nil {
+			return nil
+		}
+		return cv.Base
+	case *ArrayValue:
+		return cv
+	case *StructValue:
+		return cv
+	case *MapValue:
+		return cv
+	case RefValue:
+		return cv.GetObject()
+	default:
+		return nil
+	}
+}
+
 // Like GetValueAtIndex(), except for assigning to or for
 // creating a pointer reference to.  For the latter case,
 // the value gets initialized via defaultValue().
@@ -1251,7 +1519,7 @@ func (tv *TypedValue) GetValueRefAtIndexForAssign(iv *TypedValue) *TypedValue {
 		av := tv.V.(*ArrayValue)
 		ii := iv.ConvertGetInt()
 		if av.Data == nil {
-			ev := &(tv.V.(*ArrayValue).List[ii])
+			ev := fillValue(&(tv.V.(*ArrayValue).List[ii]))
 			// in case reference escapes via PointerKind,
 			// set type if array elements are of concrete type.
 			if ev.IsUndefined() && t.Elt.Kind() != InterfaceKind {
@@ -1283,7 +1551,7 @@ func (tv *TypedValue) GetValueRefAtIndexForAssign(iv *TypedValue) *TypedValue {
 				ii, sv.Length))
 		}
 		if sv.Base.Data == nil {
-			ev := &sv.Base.List[sv.Offset+ii]
+			ev := fillValue(&sv.Base.List[sv.Offset+ii])
 			// in case reference escapes via PointerKind,
 			// set type if array elements are of concrete type.
 			if ev.IsUndefined() && t.Elt.Kind() != InterfaceKind {
@@ -1627,12 +1895,12 @@ LOOP:
 		i++
 		goto LOOP
 	}
-	return &b.Values[path.Index]
+	return fillValue(&b.Values[path.Index])
 }
 
-// Returns a reference to the value for assigning.
-// XXX use this everywhere necessary.
-func (b *Block) GetValueRefAtForAssign2(rlm *Realm, path ValuePath) (tv *TypedValue) {
+// Returns a pointer to the value, with the block that
+// holds it as base.
+func (b *Block) GetPointerTo(path ValuePath) PointerValue {
 	// NOTE: For most block paths, Depth starts at 1, but the
 	// generation for uverse is 0.  If path.Depth is 0, it
 	// implies that b == uverse, and loop will break.
@@ -1643,12 +1911,13 @@ LOOP:
 		i++
 		goto LOOP
 	}
-	if rlm != nil {
-		// NOTE: b is maybe no longer the block we started
-		// out with. This is a key security concern.
-		rlm.DidUpdate(b)
+	// NOTE: b is maybe no longer the block we started
+	// out with. This is a key security concern.
+	return PointerValue{
+		TypedValue: fillValue(&b.Values[path.Index]),
+		Base:       blockValue{b},
+		Index:      int(path.Index),
 	}
-	return &b.Values[path.Index]
 }
 
 // Result is used has lhs for any assignments to "_".
@@ -1682,6 +1951,11 @@ func (b *Block) GetParams3() (tv1, tv2, tv3 *TypedValue) {
 func defaultValue(t Type) Value {
 	switch ct := baseOf(t).(type) {
 	case *ArrayType:
+		if ct.Elt.Kind() == Uint8Kind {
+			return &ArrayValue{
+				Data: make([]byte, ct.Len),
+			}
+		}
 		tvs := make([]TypedValue, ct.Len)
 		return &ArrayValue{
 			List: tvs,
@@ -1696,6 +1970,7 @@ func defaultValue(t Type) Value {
 	case *StructType:
 		return &StructValue{
 			Fields: make([]TypedValue, len(ct.Fields)),
+			st:     ct,
 		}
 	case *nativeType:
 		return &nativeValue{
@@ -1712,6 +1987,12 @@ func untypedBool(b bool) TypedValue {
 	return tv
 }
 
+func typedBool(b bool) TypedValue {
+	tv := TypedValue{T: BoolType}
+	tv.SetBool(b)
+	return tv
+}
+
 func newSliceFromList(list []TypedValue) *SliceValue {
 	return &SliceValue{
 		Base: &ArrayValue{
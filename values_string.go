@@ -42,7 +42,19 @@ func (v *SliceValue) String() string {
 	}
 }
 
+// Objects being printed through pointers, to avoid infinite
+// recursion on cyclic values, e.g. when debug printing.
+// XXX not goroutine safe.
+var printingObjects = make(map[Object]struct{})
+
 func (v PointerValue) String() string {
+	if oo, ok := v.TypedValue.V.(Object); ok {
+		if _, ok := printingObjects[oo]; ok {
+			return fmt.Sprintf("*(cycle %p)", oo)
+		}
+		printingObjects[oo] = struct{}{}
+		defer delete(printingObjects, oo)
+	}
 	return fmt.Sprintf("*%s", v.TypedValue.String())
 }
 
@@ -140,3 +152,13 @@ func (v blockValue) String() string {
 	return fmt.Sprintf("block(%v)",
 		v.Block)
 }
+
+func (v RefValue) String() string {
+	return fmt.Sprintf("ref(%X)",
+		v.ObjectID.Bytes())
+}
+
+func (v WeakRefValue) String() string {
+	return fmt.Sprintf("weakref(%X)",
+		v.GetTargetID().Bytes())
+}